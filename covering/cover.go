@@ -2,6 +2,7 @@ package covering
 
 import (
 	"container/heap"
+	"fmt"
 	"sort"
 
 	"github.com/kpfaulkner/quadmap/quadmap"
@@ -12,6 +13,8 @@ type coveringTile struct {
 	qk quadmap.QuadKey
 	// area of the tile that lies outside the geometry
 	outsideArea float64
+	// area of the tile
+	area float64
 }
 
 type priorityQueue []coveringTile
@@ -32,7 +35,8 @@ func (pq *priorityQueue) Pop() any {
 	return x
 }
 
-func intersection(qk quadmap.QuadKey, g geom.Geometry) (coveringTile, bool, error) {
+// intersection scores tile qk against g, measuring area with metric.
+func intersection(qk quadmap.QuadKey, g geom.Geometry, metric quadmap.AreaMetric) (coveringTile, bool, error) {
 	tileEnv, err := qk.Envelope()
 	if err != nil {
 		return coveringTile{}, false, err
@@ -44,44 +48,173 @@ func intersection(qk quadmap.QuadKey, g geom.Geometry) (coveringTile, bool, erro
 	if intersection.IsEmpty() {
 		return coveringTile{}, false, nil
 	}
-	// TODO: correct area for web mercator distortion? Won't matter in most cases.
-	return coveringTile{qk, tileEnv.Area() - intersection.Area()}, true, nil
+	tileArea := qk.TileArea(metric)
+	return coveringTile{qk, tileArea - quadmap.Area(intersection, metric), tileArea}, true, nil
+}
+
+// CoveringOptions controls the QuadKeys produced by ExteriorCoveringWithOptions and
+// InteriorCoveringWithOptions. Modelled on the S2 RegionCoverer options.
+type CoveringOptions struct {
+	// MinZoom is the lowest zoom (largest tiles) that will be returned. Tiles coarser than this are
+	// always split, even if that exceeds MaxTiles.
+	MinZoom byte
+
+	// MaxZoom is the highest zoom (smallest tiles) that will be returned. 0 means quadmap.MaxZoom.
+	MaxZoom byte
+
+	// MaxTiles is the maximum number of tiles returned (unless MinZoom forces more). 0 means no limit.
+	MaxTiles int
+
+	// LevelMod restricts returned tiles to zoom levels MinZoom, MinZoom+LevelMod, MinZoom+2*LevelMod...
+	// eg. to line up with the zoom levels a quadmap is populated at. 0 or 1 means every zoom level.
+	LevelMod byte
+
+	// AreaMetric is used to measure the area of tiles lying outside the geometry, which decides
+	// which tiles are refined first. The default (quadmap.AreaDegrees) measures in lon/lat degrees
+	// which under-weights tiles at high latitudes, quadmap.AreaWebMercator or quadmap.AreaGeodesic
+	// spend the tile budget evenly regardless of latitude.
+	AreaMetric quadmap.AreaMetric
+}
+
+// validate returns an error if the zoom levels in the options are invalid
+func (o CoveringOptions) validate() error {
+	if o.MinZoom > quadmap.MaxZoom {
+		return fmt.Errorf("invalid MinZoom %d, maximum zoom is %d", o.MinZoom, quadmap.MaxZoom)
+	}
+	if o.MaxZoom != 0 && o.MinZoom > o.MaxZoom {
+		return fmt.Errorf("MinZoom %d is greater than MaxZoom %d", o.MinZoom, o.MaxZoom)
+	}
+	return nil
+}
+
+// step returns the number of zoom levels between returned tiles
+func (o CoveringOptions) step() byte {
+	if o.LevelMod == 0 {
+		return 1
+	}
+	return o.LevelMod
+}
+
+// maxZoom returns the highest zoom level that lines up with MinZoom/LevelMod
+func (o CoveringOptions) maxZoom() byte {
+	maxZoom := o.MaxZoom
+	if maxZoom == 0 || maxZoom > quadmap.MaxZoom {
+		maxZoom = quadmap.MaxZoom
+	}
+	if maxZoom < o.MinZoom {
+		return o.MinZoom
+	}
+	return maxZoom - (maxZoom-o.MinZoom)%o.step()
+}
+
+// underBudget returns true if n tiles is within the MaxTiles budget
+func (o CoveringOptions) underBudget(n int) bool {
+	return o.MaxTiles == 0 || n <= o.MaxTiles
+}
+
+// keepBest returns the candidates with the most area inside the geometry, at most as many as
+// there is budget left for when used tiles have already been taken.
+func (o CoveringOptions) keepBest(candidates []coveringTile, used int) []coveringTile {
+	if o.MaxTiles == 0 || len(candidates) <= o.MaxTiles-used {
+		return candidates
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].area-candidates[i].outsideArea > candidates[j].area-candidates[j].outsideArea
+	})
+	return candidates[:max(o.MaxTiles-used, 0)]
+}
+
+// overlappingDescendants returns the descendants of qk at zoom that overlap g, only splitting
+// tiles that overlap g.
+func overlappingDescendants(qk quadmap.QuadKey, zoom byte, g geom.Geometry, metric quadmap.AreaMetric) ([]coveringTile, error) {
+	var tiles []coveringTile
+	for _, ch := range qk.Children() {
+		score, overlap, err := intersection(ch, g, metric)
+		if err != nil {
+			return nil, err
+		}
+		if !overlap {
+			continue
+		}
+		if ch.Zoom() >= zoom {
+			tiles = append(tiles, score)
+			continue
+		}
+		desc, err := overlappingDescendants(ch, zoom, g, metric)
+		if err != nil {
+			return nil, err
+		}
+		tiles = append(tiles, desc...)
+	}
+	return tiles, nil
 }
 
 // ExteriorCovering returns a set of QuadKeys that approximates a Geometry
 // with no more than maxTiles keys. The covering fully covers the geometry,
 // but may also include some area outside it.
-func ExteriorCovering(g geom.Geometry, maxTiles int) ([]quadmap.QuadKey, error) { // TODO: minZoom
-	score, ok, err := intersection(0, g)
+// A maxTiles of 0 (or less) returns the root tile, unlike CoveringOptions.MaxTiles where 0 means
+// no limit.
+func ExteriorCovering(g geom.Geometry, maxTiles int) ([]quadmap.QuadKey, error) {
+	if maxTiles <= 0 {
+		_, ok, err := intersection(0, g, quadmap.AreaDegrees)
+		if err != nil || !ok {
+			return nil, err
+		}
+		return []quadmap.QuadKey{0}, nil
+	}
+	return ExteriorCoveringWithOptions(g, CoveringOptions{MaxTiles: maxTiles})
+}
+
+// ExteriorCoveringNoMax returns a set of QuadKeys that approximates a Geometry down to zoom 22,
+// with no limit on the number of keys.
+func ExteriorCoveringNoMax(g geom.Geometry) ([]quadmap.QuadKey, error) {
+	return ExteriorCoveringWithOptions(g, CoveringOptions{MaxZoom: 22})
+}
+
+// ExteriorCoveringWithOptions returns a set of QuadKeys that approximates a Geometry, constrained
+// by opts. The covering fully covers the geometry, but may also include some area outside it.
+// Tiles with the most area outside the geometry are refined first. Refinement stops once the
+// worst tile can't be refined any further (it's inside the geometry or at MaxZoom) or refining
+// it would exceed MaxTiles.
+func ExteriorCoveringWithOptions(g geom.Geometry, opts CoveringOptions) ([]quadmap.QuadKey, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	score, ok, err := intersection(0, g, opts.AreaMetric)
 	if err != nil {
 		return nil, err
 	}
 	if !ok {
 		return nil, nil
 	}
+
+	maxZoom := opts.maxZoom()
 	pq := priorityQueue{score}
+	if opts.MinZoom > 0 {
+		tiles, err := overlappingDescendants(0, opts.MinZoom, g, opts.AreaMetric)
+		if err != nil {
+			return nil, err
+		}
+		pq = tiles
+		heap.Init(&pq)
+	}
+
 	for len(pq) > 0 {
 		cell := heap.Pop(&pq).(coveringTile)
-		if cell.outsideArea == 0 {
+		if isContained(cell) {
 			heap.Push(&pq, cell)
 			break
 		}
-		if _, _, z := cell.qk.SlippyCoords(); z >= quadmap.MaxZoom {
+		if z := cell.qk.Zoom(); z >= maxZoom {
 			heap.Push(&pq, cell)
 			break
 		}
 
-		var next []coveringTile
-		for _, ch := range cell.qk.Children() {
-			score, overlap, err := intersection(ch, g)
-			if err != nil {
-				return nil, err
-			}
-			if overlap {
-				next = append(next, score)
-			}
+		next, err := overlappingDescendants(cell.qk, cell.qk.Zoom()+opts.step(), g, opts.AreaMetric)
+		if err != nil {
+			return nil, err
 		}
-		if len(pq)+len(next) > maxTiles {
+		if !opts.underBudget(len(pq) + len(next)) {
 			heap.Push(&pq, cell)
 			break
 		}
@@ -95,51 +228,87 @@ func ExteriorCovering(g geom.Geometry, maxTiles int) ([]quadmap.QuadKey, error)
 	}
 	return cover, nil
 }
-func ExteriorCoveringNoMax(g geom.Geometry) ([]quadmap.QuadKey, error) { // TODO: minZoom
-	score, ok, err := intersection(0, g)
+
+// containedTolerance is the fraction of a tile's area that may lie outside a geometry
+// while still treating the tile as contained by it. Allows for floating point error
+// when intersecting tiles that are exactly inside the geometry.
+const containedTolerance = 1e-9
+
+// InteriorCovering returns a set of QuadKeys that are fully contained in the Geometry, with
+// no more than maxTiles keys and no keys deeper than maxZoom. Unlike ExteriorCovering the
+// covering never includes area outside the geometry, but may leave parts of the geometry
+// uncovered (near its boundary, or everywhere if the budget runs out).
+// Larger (lower zoom) tiles are found first. Geometries without area (points and lines)
+// have an empty interior covering.
+// A maxTiles of 0 (or less) returns no tiles, unlike CoveringOptions.MaxTiles where 0 means
+// no limit.
+func InteriorCovering(g geom.Geometry, maxTiles int, maxZoom byte) ([]quadmap.QuadKey, error) {
+	if maxTiles <= 0 {
+		return nil, nil
+	}
+	return InteriorCoveringWithOptions(g, CoveringOptions{MaxTiles: maxTiles, MaxZoom: maxZoom})
+}
+
+// InteriorCoveringWithOptions returns a set of QuadKeys that are fully contained in the Geometry,
+// constrained by opts. See InteriorCovering.
+// Contained tiles coarser than MinZoom are split into their MinZoom descendants, each of
+// which counts towards MaxTiles.
+// MaxTiles also bounds the work done: at each zoom level only as many candidate tiles as there
+// is budget left for are refined (those with the most area inside the geometry), so a
+// geometry with a long boundary doesn't have every boundary tile refined down to MaxZoom.
+func InteriorCoveringWithOptions(g geom.Geometry, opts CoveringOptions) ([]quadmap.QuadKey, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+	score, ok, err := intersection(0, g, opts.AreaMetric)
 	if err != nil {
 		return nil, err
 	}
-	if !ok {
+	if !ok || g.Area() == 0 {
 		return nil, nil
 	}
-	pq := priorityQueue{score}
-	for len(pq) > 0 {
-		cell := heap.Pop(&pq).(coveringTile)
-		if cell.outsideArea == 0 {
-			heap.Push(&pq, cell)
-			break
-		}
-		if _, _, z := cell.qk.SlippyCoords(); z >= 22 {
-			heap.Push(&pq, cell)
-			break
+
+	maxZoom := opts.maxZoom()
+	var cover []quadmap.QuadKey
+
+	// candidates are processed a zoom level at a time (breadth first) so larger tiles are found first.
+	var candidates []coveringTile
+	if opts.MinZoom > 0 {
+		candidates, err = overlappingDescendants(0, opts.MinZoom, g, opts.AreaMetric)
+		if err != nil {
+			return nil, err
 		}
+	} else {
+		candidates = []coveringTile{score}
+	}
 
+	for len(candidates) > 0 {
 		var next []coveringTile
-		for _, ch := range cell.qk.Children() {
-			score, overlap, err := intersection(ch, g)
+		for _, cell := range opts.keepBest(candidates, len(cover)) {
+			if isContained(cell) {
+				cover = append(cover, cell.qk)
+				continue
+			}
+			if cell.qk.Zoom() >= maxZoom {
+				continue
+			}
+
+			children, err := overlappingDescendants(cell.qk, cell.qk.Zoom()+opts.step(), g, opts.AreaMetric)
 			if err != nil {
 				return nil, err
 			}
-			if overlap {
-				next = append(next, score)
-			}
-		}
-		//if len(pq)+len(next) > maxTiles {
-		//	heap.Push(&pq, cell)
-		//	break
-		//}
-		for _, c := range next {
-			heap.Push(&pq, c)
+			next = append(next, children...)
 		}
-	}
-	cover := make([]quadmap.QuadKey, len(pq))
-	for i, c := range pq {
-		cover[i] = c.qk
+		candidates = next
 	}
 	return cover, nil
 }
 
+// isContained returns true if the tile lies inside the geometry it was scored against
+func isContained(ct coveringTile) bool {
+	return ct.outsideArea <= ct.area*containedTolerance
+}
+
 func AllAncestors(quadKeys []quadmap.QuadKey, minZoom byte) ([]quadmap.QuadKey, error) {
 	seen := make(map[quadmap.QuadKey]bool)
 	for _, qk := range quadKeys {
@@ -166,23 +335,40 @@ func AllAncestors(quadKeys []quadmap.QuadKey, minZoom byte) ([]quadmap.QuadKey,
 }
 
 // SearchRanges takes a set of QuadKeys and returns a sorted list of ranges
-// that contain all of those tiles and any of their descendants.
+// that contain all of those tiles and any of their descendants, plus their ancestors
+// (down to minZoom).
 //
 // Note: these ranges also find a small number of keys outside those tiles (see
 // the note in QuadKey.Range()). Use QuadKey.IsAncestorOf() to filter those out
-// of the results.
+// of the results (QuadMap.TilesInRanges does this), or use SentinelSearchRanges if the
+// keys are stored as SentinelKeys.
+// The ranges of ancestors aren't merged into the range of a descendant that contains them
+// (ancestors in the 0,0 corner) so they can still be told apart from those false positives,
+// which means ranges can overlap.
 func SearchRanges(quadKeys []quadmap.QuadKey, minZoom byte) ([]quadmap.QuadKeyRange, error) {
 	ancestors, err := AllAncestors(quadKeys, minZoom)
 	if err != nil {
 		return nil, err
 	}
 
-	ranges := make([]quadmap.QuadKeyRange, 0, len(quadKeys)+len(ancestors))
+	ranges := make([]quadmap.QuadKeyRange, 0, len(quadKeys))
 	for _, qk := range quadKeys {
 		ranges = append(ranges, qk.Range())
 	}
+	ancestorRanges := make([]quadmap.QuadKeyRange, 0, len(ancestors))
 	for _, a := range ancestors {
-		ranges = append(ranges, a.SingleRange())
+		ancestorRanges = append(ancestorRanges, a.SingleRange())
+	}
+
+	ranges = append(mergeRanges(ranges), mergeRanges(ancestorRanges)...)
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+	return ranges, nil
+}
+
+// mergeRanges sorts ranges and merges any that overlap or are contiguous
+func mergeRanges(ranges []quadmap.QuadKeyRange) []quadmap.QuadKeyRange {
+	if len(ranges) == 0 {
+		return ranges
 	}
 	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
 
@@ -206,5 +392,84 @@ func SearchRanges(quadKeys []quadmap.QuadKey, minZoom byte) ([]quadmap.QuadKeyRa
 		i++
 		ranges[i] = ranges[j]
 	}
-	return ranges[:i+1], nil
+	return ranges[:i+1]
+}
+
+// SentinelSearchRanges is the same as SearchRanges but returns ranges of SentinelKeys.
+// Unlike SearchRanges these ranges are exact, they contain only the tiles, their
+// descendants and their ancestors (down to minZoom) so no filtering of the results is needed.
+func SentinelSearchRanges(quadKeys []quadmap.QuadKey, minZoom byte) ([]quadmap.SentinelKeyRange, error) {
+	ancestors, err := AllAncestors(quadKeys, minZoom)
+	if err != nil {
+		return nil, err
+	}
+
+	ranges := make([]quadmap.SentinelKeyRange, 0, len(quadKeys)+len(ancestors))
+	for _, qk := range quadKeys {
+		ranges = append(ranges, qk.SentinelKey().Range())
+	}
+	for _, a := range ancestors {
+		ranges = append(ranges, a.SentinelKey().SingleRange())
+	}
+	return mergeSentinelRanges(ranges), nil
+}
+
+// HilbertSearchRanges is the same as SentinelSearchRanges but returns ranges of HilbertKeys.
+// Ranges are exact, and for a compact set of tiles there are usually fewer (longer) ranges
+// than with QuadKeys or SentinelKeys.
+func HilbertSearchRanges(quadKeys []quadmap.QuadKey, minZoom byte) ([]quadmap.HilbertKeyRange, error) {
+	ancestors, err := AllAncestors(quadKeys, minZoom)
+	if err != nil {
+		return nil, err
+	}
+
+	// HilbertKeys have the same layout as SentinelKeys (just a different path) so merge them
+	// the same way.
+	ranges := make([]quadmap.SentinelKeyRange, 0, len(quadKeys)+len(ancestors))
+	for _, qk := range quadKeys {
+		r := qk.HilbertKey().Range()
+		ranges = append(ranges, quadmap.SentinelKeyRange{Start: quadmap.SentinelKey(r.Start), End: quadmap.SentinelKey(r.End)})
+	}
+	for _, a := range ancestors {
+		ranges = append(ranges, quadmap.SentinelKey(a.HilbertKey()).SingleRange())
+	}
+
+	merged := mergeSentinelRanges(ranges)
+	hilbertRanges := make([]quadmap.HilbertKeyRange, len(merged))
+	for i, r := range merged {
+		hilbertRanges[i] = quadmap.HilbertKeyRange{Start: quadmap.HilbertKey(r.Start), End: quadmap.HilbertKey(r.End)}
+	}
+	return hilbertRanges, nil
+}
+
+// mergeSentinelRanges sorts ranges and merges any that overlap, are contiguous or only have an
+// invalid key between them.
+func mergeSentinelRanges(ranges []quadmap.SentinelKeyRange) []quadmap.SentinelKeyRange {
+	if len(ranges) == 0 {
+		return nil
+	}
+	sort.Slice(ranges, func(i, j int) bool { return ranges[i].Start < ranges[j].Start })
+
+	i := 0
+	for j := range ranges {
+		rj := &ranges[j]
+		if i == j {
+			continue
+		}
+
+		ri := &ranges[i]
+		if ri.End >= rj.Start || // ranges overlap
+			ri.End == rj.Start-1 || // ranges are contiguous (beware overflow)
+			(ri.End == rj.Start-2 && !(ri.End + 1).IsValid()) { // only gap is an invalid key, eg. between siblings
+			if ri.End < rj.End {
+				// rj is bigger; extend ri
+				ri.End = rj.End
+			}
+			continue
+		}
+
+		i++
+		ranges[i] = ranges[j]
+	}
+	return ranges[:i+1]
 }
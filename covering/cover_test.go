@@ -3,6 +3,7 @@ package covering
 import (
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/kpfaulkner/quadmap/quadmap"
 	"github.com/peterstace/simplefeatures/geom"
@@ -195,9 +196,11 @@ func TestSearchRanges(t *testing.T) {
 				{Start: 0xd100000000000004, End: 0xd100000000000004},
 				{Start: 0xd180000000000000, End: 0xd1bfffffffffffff},
 				{Start: 0xd300000000000000, End: 0xd43fffffffffffff},
+				{Start: 0xd400000000000003, End: 0xd400000000000004}, // ancestors in the range above
 				{Start: 0xd4c0000000000000, End: 0xd4ffffffffffffff},
 				{Start: 0xd600000000000000, End: 0xd6ffffffffffffff},
 				{Start: 0xdc00000000000000, End: 0xdc7fffffffffffff},
+				{Start: 0xdc00000000000003, End: 0xdc00000000000004}, // ancestors in the range above
 			},
 		},
 		{
@@ -229,3 +232,375 @@ func TestSearchRanges(t *testing.T) {
 		})
 	}
 }
+
+// TestSearchRangesTilesInRanges checks tiles found with SearchRanges include ancestors (down to
+// minZoom) in the 0,0 corner of the range of their descendant, but not false positives.
+func TestSearchRangesTilesInRanges(t *testing.T) {
+	qm := quadmap.NewQuadMap(10)
+	for _, c := range []struct {
+		x, y uint32
+		z    byte
+	}{
+		{0, 0, 6},  // full ancestor
+		{0, 0, 9},  // the tile itself
+		{1, 1, 10}, // descendant
+		{0, 0, 3},  // ancestor below minZoom, in the 0,0 corner of the range but not found
+		{1, 0, 6},  // not related
+	} {
+		_, err := qm.CreateTileAtSlippyCoords(c.x, c.y, c.z, quadmap.TileTypeVert, true)
+		require.NoError(t, err)
+	}
+
+	cells := []quadmap.QuadKey{mustGenerateQuadKeyIndexFromSlippy(0, 0, 9)}
+	ranges, err := SearchRanges(cells, 5)
+	require.NoError(t, err)
+	tiles, err := qm.TilesInRanges(ranges, quadmap.TileTypeVert)
+	require.NoError(t, err)
+
+	var keys []quadmap.QuadKey
+	for _, tile := range tiles {
+		keys = append(keys, tile.QuadKey)
+	}
+	assert.Equal(t, []quadmap.QuadKey{
+		mustGenerateQuadKeyIndexFromSlippy(0, 0, 6),
+		mustGenerateQuadKeyIndexFromSlippy(0, 0, 9),
+		mustGenerateQuadKeyIndexFromSlippy(1, 1, 10),
+	}, keys)
+}
+
+func TestInteriorCovering(t *testing.T) {
+	tile := mustGenerateQuadKeyIndexFromSlippy(123, 456, 10)
+
+	t.Run("single tile", func(t *testing.T) {
+		g, err := geom.UnmarshalWKT(quadKeyToWKT(t, tile))
+		require.NoError(t, err)
+
+		cov, err := InteriorCovering(g, 20, 14)
+		require.NoError(t, err)
+		assert.Equal(t, []quadmap.QuadKey{tile}, cov)
+	})
+
+	t.Run("point has no interior", func(t *testing.T) {
+		g, err := geom.UnmarshalWKT("POINT(153.156 -30.316)")
+		require.NoError(t, err)
+
+		cov, err := InteriorCovering(g, 20, 24)
+		require.NoError(t, err)
+		assert.Empty(t, cov)
+	})
+
+	t.Run("tiles are inside geometry and within budget", func(t *testing.T) {
+		g, err := geom.UnmarshalWKT("POLYGON((151.17 -33.90, 151.20 -33.90, 151.20 -33.87, 151.17 -33.90))")
+		require.NoError(t, err)
+
+		for _, maxTiles := range []int{1, 5, 50} {
+			cov, err := InteriorCovering(g, maxTiles, 18)
+			require.NoError(t, err)
+			assert.NotEmpty(t, cov)
+			assert.LessOrEqual(t, len(cov), maxTiles)
+			for _, qk := range cov {
+				assert.LessOrEqual(t, qk.Zoom(), byte(18))
+				env, err := qk.Envelope()
+				require.NoError(t, err)
+				covers, err := geom.Covers(g, env.AsGeometry())
+				require.NoError(t, err)
+				assert.True(t, covers, "tile %x should be inside geometry", uint64(qk))
+			}
+		}
+	})
+}
+
+// TestInteriorCoveringSliver checks the budget also bounds the work done, a long thin polygon has
+// far too many boundary tiles to refine them all down to MaxZoom.
+func TestInteriorCoveringSliver(t *testing.T) {
+	g, err := geom.UnmarshalWKT("POLYGON((140 -20, 150 -30, 150.0001 -30, 140.0001 -20, 140 -20))")
+	require.NoError(t, err)
+
+	done := make(chan struct{})
+	var cov []quadmap.QuadKey
+	go func() {
+		defer close(done)
+		cov, err = InteriorCoveringWithOptions(g, CoveringOptions{MaxTiles: 100})
+	}()
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("interior covering of sliver took too long")
+	}
+
+	require.NoError(t, err)
+	assert.NotEmpty(t, cov)
+	assert.LessOrEqual(t, len(cov), 100)
+	for _, qk := range cov {
+		env, err := qk.Envelope()
+		require.NoError(t, err)
+		covers, err := geom.Covers(g, env.AsGeometry())
+		require.NoError(t, err)
+		assert.True(t, covers, "tile %x should be inside geometry", uint64(qk))
+	}
+}
+
+func TestExteriorCoveringWithOptions(t *testing.T) {
+	g, err := geom.UnmarshalWKT("LINESTRING (151.17777883970012 -33.89915886674083, 151.19474745138047 -33.88458149334736)")
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		name string
+		opts CoveringOptions
+	}{
+		{name: "min zoom", opts: CoveringOptions{MinZoom: 15, MaxTiles: 1}},
+		{name: "max zoom", opts: CoveringOptions{MaxZoom: 12, MaxTiles: 50}},
+		{name: "level mod", opts: CoveringOptions{MinZoom: 10, MaxZoom: 19, LevelMod: 4, MaxTiles: 30}},
+		{name: "no max tiles", opts: CoveringOptions{MaxZoom: 14}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			cov, err := ExteriorCoveringWithOptions(g, tc.opts)
+			require.NoError(t, err)
+			require.NotEmpty(t, cov)
+
+			if tc.opts.MaxTiles > 0 && tc.opts.MinZoom == 0 {
+				assert.LessOrEqual(t, len(cov), tc.opts.MaxTiles)
+			}
+
+			var union geom.Geometry
+			for _, qk := range cov {
+				z := qk.Zoom()
+				assert.GreaterOrEqual(t, z, tc.opts.MinZoom)
+				if tc.opts.MaxZoom > 0 {
+					assert.LessOrEqual(t, z, tc.opts.MaxZoom)
+				}
+				if tc.opts.LevelMod > 1 {
+					assert.Zero(t, (z-tc.opts.MinZoom)%tc.opts.LevelMod, "zoom %d not aligned", z)
+				}
+				env, err := qk.Envelope()
+				require.NoError(t, err)
+				union, err = geom.Union(union, env.AsGeometry())
+				require.NoError(t, err)
+			}
+
+			covers, err := geom.Covers(union, g)
+			require.NoError(t, err)
+			assert.True(t, covers, "covering should cover geometry")
+		})
+	}
+}
+
+func TestInteriorCoveringWithOptions(t *testing.T) {
+	g, err := geom.UnmarshalWKT("POLYGON((151.17 -33.90, 151.20 -33.90, 151.20 -33.87, 151.17 -33.90))")
+	require.NoError(t, err)
+
+	cov, err := InteriorCoveringWithOptions(g, CoveringOptions{MinZoom: 14, MaxZoom: 20, LevelMod: 3})
+	require.NoError(t, err)
+	require.NotEmpty(t, cov)
+	for _, qk := range cov {
+		assert.Contains(t, []byte{14, 17, 20}, qk.Zoom())
+	}
+}
+
+func TestCoveringOptionsInvalidZoom(t *testing.T) {
+	g, err := geom.UnmarshalWKT("POLYGON((151.17 -33.90, 151.20 -33.90, 151.20 -33.87, 151.17 -33.90))")
+	require.NoError(t, err)
+
+	for _, opts := range []CoveringOptions{
+		{MinZoom: quadmap.MaxZoom + 1},
+		{MinZoom: 15, MaxZoom: 12},
+	} {
+		_, err = ExteriorCoveringWithOptions(g, opts)
+		assert.Error(t, err, "%+v", opts)
+		_, err = InteriorCoveringWithOptions(g, opts)
+		assert.Error(t, err, "%+v", opts)
+	}
+}
+
+// TestCoveringZeroMaxTiles checks the original functions don't treat 0 tiles as unlimited
+func TestCoveringZeroMaxTiles(t *testing.T) {
+	g, err := geom.UnmarshalWKT("POLYGON((151.17 -33.90, 151.20 -33.90, 151.20 -33.87, 151.17 -33.90))")
+	require.NoError(t, err)
+
+	cov, err := ExteriorCovering(g, 0)
+	require.NoError(t, err)
+	assert.Equal(t, []quadmap.QuadKey{0}, cov)
+
+	cov, err = InteriorCovering(g, 0, 18)
+	require.NoError(t, err)
+	assert.Empty(t, cov)
+}
+
+func TestExteriorCoveringAreaMetric(t *testing.T) {
+	// southern Norway/Sweden
+	g, err := geom.UnmarshalWKT("POLYGON((5.0 58.0, 18.0 56.0, 20.0 63.0, 14.0 68.0, 8.0 63.0, 5.0 58.0))")
+	require.NoError(t, err)
+
+	for _, metric := range []quadmap.AreaMetric{quadmap.AreaDegrees, quadmap.AreaWebMercator, quadmap.AreaGeodesic} {
+		cov, err := ExteriorCoveringWithOptions(g, CoveringOptions{MaxTiles: 30, AreaMetric: metric})
+		require.NoError(t, err)
+		assert.LessOrEqual(t, len(cov), 30)
+
+		var union geom.Geometry
+		for _, qk := range cov {
+			env, err := qk.Envelope()
+			require.NoError(t, err)
+			union, err = geom.Union(union, env.AsGeometry())
+			require.NoError(t, err)
+		}
+		covers, err := geom.Covers(union, g)
+		require.NoError(t, err)
+		assert.True(t, covers, "covering should cover geometry for metric %d", metric)
+	}
+}
+
+// TestExteriorCoveringAreaMetricRefinement checks the metric changes which tiles are refined.
+// The same size (in degrees) squares on the equator and at high latitude are tiny and huge
+// respectively in Web Mercator, and the other way around on the sphere.
+func TestExteriorCoveringAreaMetricRefinement(t *testing.T) {
+	g, err := geom.UnmarshalWKT("MULTIPOLYGON(((10 0, 12 0, 12 2, 10 2, 10 0)),((10 78, 12 78, 12 80, 10 80, 10 78)))")
+	require.NoError(t, err)
+
+	northTiles := func(metric quadmap.AreaMetric) int {
+		cov, err := ExteriorCoveringWithOptions(g, CoveringOptions{MaxTiles: 30, AreaMetric: metric})
+		require.NoError(t, err)
+		north := 0
+		for _, qk := range cov {
+			env, err := qk.Envelope()
+			require.NoError(t, err)
+			if center, _ := env.Center().XY(); center.Y > 45 {
+				north++
+			}
+		}
+		return north
+	}
+
+	degrees := northTiles(quadmap.AreaDegrees)
+	assert.Greater(t, northTiles(quadmap.AreaWebMercator), degrees)
+	assert.Less(t, northTiles(quadmap.AreaGeodesic), degrees)
+}
+
+// TestExteriorCoveringContainedTile checks a geometry that is exactly a tile isn't refined any
+// further, even though the area outside the geometry isn't exactly 0 for every metric.
+func TestExteriorCoveringContainedTile(t *testing.T) {
+	for _, qk := range []quadmap.QuadKey{0, mustGenerateQuadKeyIndexFromSlippy(1, 0, 1)} {
+		env, err := qk.Envelope()
+		require.NoError(t, err)
+
+		for _, metric := range []quadmap.AreaMetric{quadmap.AreaDegrees, quadmap.AreaWebMercator, quadmap.AreaGeodesic} {
+			cov, err := ExteriorCoveringWithOptions(env.AsGeometry(), CoveringOptions{MaxTiles: 100, AreaMetric: metric})
+			require.NoError(t, err)
+
+			// tiles just touching the geometry are also included, but qk shouldn't have been split
+			assert.Contains(t, cov, qk, "metric %d", metric)
+			for _, c := range cov {
+				assert.False(t, c != qk && qk.IsAncestorOf(c), "metric %d", metric)
+			}
+		}
+	}
+}
+
+func TestSentinelSearchRanges(t *testing.T) {
+	tile := mustGenerateQuadKeyIndexFromSlippy(123, 456, 9)
+	sibling := mustGenerateQuadKeyIndexFromSlippy(122, 456, 9)
+
+	t.Run("single tile", func(t *testing.T) {
+		r, err := SentinelSearchRanges([]quadmap.QuadKey{tile}, 5)
+		require.NoError(t, err)
+
+		// 4 ancestors (zoom 5-8) and the tile itself. The tile is child 1 of its parent so
+		// the parent immediately follows the tile's range and they are merged.
+		parent, err := tile.Parent()
+		require.NoError(t, err)
+		require.Len(t, r, 4)
+		assert.Equal(t, quadmap.SentinelKeyRange{Start: tile.SentinelKey().Range().Start, End: parent.SentinelKey()}, r[1])
+	})
+
+	t.Run("siblings are merged", func(t *testing.T) {
+		r, err := SentinelSearchRanges([]quadmap.QuadKey{tile, sibling}, 9)
+		require.NoError(t, err)
+		require.Len(t, r, 1)
+		assert.Equal(t, sibling.SentinelKey().Range().Start, r[0].Start)
+		assert.Equal(t, tile.SentinelKey().Range().End, r[0].End)
+	})
+
+	t.Run("exact", func(t *testing.T) {
+		cells := []quadmap.QuadKey{tile, sibling}
+		minZoom := byte(6)
+		r, err := SentinelSearchRanges(cells, minZoom)
+		require.NoError(t, err)
+
+		// check every tile at zoom 6-11 under the common zoom 6 ancestor
+		ancestor := tile
+		for ancestor.Zoom() > minZoom {
+			ancestor, err = ancestor.Parent()
+			require.NoError(t, err)
+		}
+
+		inRanges := func(qk quadmap.QuadKey) bool {
+			for _, sr := range r {
+				if sr.Contains(qk.SentinelKey()) {
+					return true
+				}
+			}
+			return false
+		}
+		expected := func(qk quadmap.QuadKey) bool {
+			for _, c := range cells {
+				if c.IsAncestorOf(qk) || qk.IsAncestorOf(c) {
+					return true
+				}
+			}
+			return false
+		}
+
+		keys := []quadmap.QuadKey{ancestor}
+		for i := 0; i < len(keys); i++ {
+			qk := keys[i]
+			assert.Equal(t, expected(qk), inRanges(qk), "quadkey %x", qk)
+			if qk.Zoom() < 11 {
+				keys = append(keys, qk.Children()...)
+			}
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		r, err := SentinelSearchRanges(nil, 0)
+		require.NoError(t, err)
+		assert.Empty(t, r)
+	})
+}
+
+func TestHilbertSearchRanges(t *testing.T) {
+	// unaligned 4x4 block of zoom 10 tiles that straddles zoom 8 tile boundaries
+	var cells []quadmap.QuadKey
+	for x := uint32(511); x < 515; x++ {
+		for y := uint32(303); y < 307; y++ {
+			cells = append(cells, mustGenerateQuadKeyIndexFromSlippy(x, y, 10))
+		}
+	}
+	minZoom := byte(10)
+
+	hilbertRanges, err := HilbertSearchRanges(cells, minZoom)
+	require.NoError(t, err)
+	sentinelRanges, err := SentinelSearchRanges(cells, minZoom)
+	require.NoError(t, err)
+	assert.Less(t, len(hilbertRanges), len(sentinelRanges))
+
+	inRanges := func(hk quadmap.HilbertKey) bool {
+		for _, r := range hilbertRanges {
+			if r.Contains(hk) {
+				return true
+			}
+		}
+		return false
+	}
+
+	// every cell (and descendants) found, neighbouring tiles not.
+	for _, c := range cells {
+		assert.True(t, inRanges(c.HilbertKey()))
+		for _, child := range c.Children() {
+			assert.True(t, inRanges(child.HilbertKey()))
+		}
+	}
+	for x := uint32(508); x < 516; x++ {
+		for _, y := range []uint32{301, 302, 307, 308} {
+			assert.False(t, inRanges(mustGenerateQuadKeyIndexFromSlippy(x, y, 10).HilbertKey()))
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package covering
+
+import (
+	"errors"
+
+	"github.com/kpfaulkner/quadmap/quadmap"
+	"github.com/peterstace/simplefeatures/geom"
+)
+
+// QuadMap is the part of the quadmap API used by the helpers in this package, so they work with
+// both quadmap.QuadMap and quadmap.ShardedQuadMap
+type QuadMap interface {
+	CoverageForQuadKeys(quadKeys []quadmap.QuadKey, tileType quadmap.TileType) (quadmap.Coverage, []quadmap.QuadKey, error)
+	CreateTileAtSlippyCoords(x uint32, y uint32, z byte, tileType quadmap.TileType, full bool) (*quadmap.Tile, error)
+	GetExactTileForQuadKey(quadKey quadmap.QuadKey) (*quadmap.Tile, error)
+	TilesInRanges(ranges []quadmap.QuadKeyRange, tileType quadmap.TileType) ([]*quadmap.Tile, error)
+}
+
+// GeometryCoverage determines whether the AOI g is covered by tiles of tileType in qm.
+// The AOI is converted to an exterior covering (of at most maxTiles QuadKeys) which is then
+// checked against the quadmap, honouring full flags on ancestors (see QuadMap.CoverageForQuadKeys).
+// Since the exterior covering may include some area outside g, tiles just outside g can
+// contribute to the coverage.
+// Returns the coverage and the QuadKeys of the tiles that provide it.
+func GeometryCoverage(qm QuadMap, g geom.Geometry, tileType quadmap.TileType, maxTiles int) (quadmap.Coverage, []quadmap.QuadKey, error) {
+	cover, err := ExteriorCovering(g, maxTiles)
+	if err != nil {
+		return quadmap.NotCovered, nil, err
+	}
+	return qm.CoverageForQuadKeys(cover, tileType)
+}
+
+// gapSearchTiles is the size of the exterior covering used to find the tiles in the AOI
+const gapSearchTiles = 16
+
+// CoverageGaps returns the QuadKeys within the AOI g that aren't covered by tileType at zoom.
+// A tile at zoom is covered if it has tileType, or an ancestor has tileType and is full (the same
+// rules as QuadMap.CoverageQuadKeys).
+// Gaps that lie entirely inside g are returned at the coarsest zoom possible (ie a returned
+// QuadKey may be at a lower zoom than zoom, in which case all of its descendants at zoom are
+// gaps, see QuadKey.GetAllPossibleChildrenAtZoom). Gaps on the boundary of g are returned at zoom.
+// Evicted tiles are reloaded (see QuadMap.TilesInRanges) so they're checked like any other tile.
+func CoverageGaps(qm QuadMap, g geom.Geometry, tileType quadmap.TileType, zoom byte) ([]quadmap.QuadKey, error) {
+	if zoom < quadmap.MinZoom || zoom > quadmap.MaxZoom {
+		return nil, errors.New("invalid zoom level")
+	}
+	if g.IsEmpty() {
+		return nil, nil
+	}
+
+	// tiles with tileType that may be in g. Every tile find looks at is an ancestor or
+	// descendant of a quadkey in cover, so these are all the tiles needed.
+	cover, err := ExteriorCovering(g, gapSearchTiles)
+	if err != nil {
+		return nil, err
+	}
+	// ranges don't (in general) include the ancestors of cover, so those are added individually
+	var ranges []quadmap.QuadKeyRange
+	ancestors := make(map[quadmap.QuadKey]bool)
+	for _, qk := range cover {
+		ranges = append(ranges, qk.Range())
+		for a, err := qk.Parent(); err == nil && !ancestors[a]; a, err = a.Parent() {
+			ancestors[a] = true
+			ranges = append(ranges, quadmap.QuadKeyRange{Start: uint64(a), End: uint64(a)})
+		}
+	}
+	tiles, err := qm.TilesInRanges(ranges, tileType)
+	if err != nil {
+		return nil, err
+	}
+
+	f := gapFinder{
+		g:              g,
+		areal:          g.Dimension() == 2,
+		tileType:       tileType,
+		zoom:           zoom,
+		tiles:          make(map[quadmap.QuadKey]*quadmap.Tile, len(tiles)),
+		hasDescendants: make(map[quadmap.QuadKey]bool),
+	}
+	for _, t := range tiles {
+		f.tiles[t.QuadKey] = t
+		for qk, err := t.QuadKey.Parent(); err == nil && !f.hasDescendants[qk]; qk, err = qk.Parent() {
+			f.hasDescendants[qk] = true
+		}
+	}
+
+	for _, child := range quadmap.QuadKey(0).Children() {
+		if err := f.find(child); err != nil {
+			return nil, err
+		}
+	}
+	return f.gaps, nil
+}
+
+// CoverageGapsGeometry returns the gaps in the coverage of tileType at zoom within the AOI g
+// (see CoverageGaps), dissolved and clipped to g.
+func CoverageGapsGeometry(qm QuadMap, g geom.Geometry, tileType quadmap.TileType, zoom byte) (geom.Geometry, error) {
+	gaps, err := CoverageGaps(qm, g, tileType, zoom)
+	if err != nil {
+		return geom.Geometry{}, err
+	}
+	dissolved, err := quadmap.DissolveQuadKeys(gaps)
+	if err != nil {
+		return geom.Geometry{}, err
+	}
+	return geom.Intersection(dissolved, g)
+}
+
+type gapFinder struct {
+	g geom.Geometry
+
+	// areal geometries only include tiles they overlap, rather than just touch
+	areal bool
+
+	tileType quadmap.TileType
+	zoom     byte
+
+	// tiles with tileType that may be in g
+	tiles map[quadmap.QuadKey]*quadmap.Tile
+
+	// quadkeys with a descendant that has tileType
+	hasDescendants map[quadmap.QuadKey]bool
+
+	gaps []quadmap.QuadKey
+}
+
+// find adds the gaps in qk (and its descendants) that overlap g.
+// Ancestors of qk have already been checked, so aren't full.
+func (f *gapFinder) find(qk quadmap.QuadKey) error {
+	score, overlap, err := intersection(qk, f.g, quadmap.AreaDegrees)
+	if err != nil {
+		return err
+	}
+	if !overlap || (f.areal && score.outsideArea >= score.area) {
+		return nil
+	}
+
+	hasTileType := false
+	if existing, ok := f.tiles[qk]; ok {
+		var isFull bool
+		hasTileType, isFull = existing.HasTileTypeAndFull(f.tileType)
+		if hasTileType && isFull {
+			return nil
+		}
+	}
+
+	if qk.Zoom() >= f.zoom {
+		if !hasTileType {
+			f.gaps = append(f.gaps, qk)
+		}
+		return nil
+	}
+
+	// nothing below qk, so it's a gap as a whole if it's inside g
+	if !f.hasDescendants[qk] && f.areal && isContained(score) {
+		f.gaps = append(f.gaps, qk)
+		return nil
+	}
+
+	for _, child := range qk.Children() {
+		if err := f.find(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
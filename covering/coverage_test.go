@@ -0,0 +1,180 @@
+package covering
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kpfaulkner/quadmap/quadmap"
+	"github.com/peterstace/simplefeatures/geom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGeometryCoverage(t *testing.T) {
+	qm := quadmap.NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(947, 602, 10, quadmap.TileTypeVert, true)
+	require.NoError(t, err)
+
+	point, err := geom.UnmarshalWKT("POINT(153.156 -30.316)")
+	require.NoError(t, err)
+
+	coverage, matches, err := GeometryCoverage(qm, point, quadmap.TileTypeVert, 20)
+	require.NoError(t, err)
+	assert.Equal(t, quadmap.FullyCovered, coverage)
+	assert.Equal(t, []quadmap.QuadKey{mustGenerateQuadKeyIndexFromSlippy(947, 602, 10)}, matches)
+
+	coverage, matches, err = GeometryCoverage(qm, point, quadmap.TileTypeDSM, 20)
+	require.NoError(t, err)
+	assert.Equal(t, quadmap.NotCovered, coverage)
+	assert.Empty(t, matches)
+
+	// polygon straddling the edge of the tile is only partially covered.
+	env, err := mustGenerateQuadKeyIndexFromSlippy(947, 602, 10).Envelope()
+	require.NoError(t, err)
+	minXY, maxXY, _ := env.MinMaxXYs()
+	width := maxXY.X - minXY.X
+	straddle := geom.NewEnvelope(
+		geom.XY{X: maxXY.X - width/2, Y: minXY.Y + 0.01},
+		geom.XY{X: maxXY.X + width/2, Y: maxXY.Y - 0.01},
+	).AsGeometry()
+
+	coverage, matches, err = GeometryCoverage(qm, straddle, quadmap.TileTypeVert, 20)
+	require.NoError(t, err)
+	assert.Equal(t, quadmap.PartiallyCovered, coverage)
+	assert.Equal(t, []quadmap.QuadKey{mustGenerateQuadKeyIndexFromSlippy(947, 602, 10)}, matches)
+}
+
+func TestCoverageGaps(t *testing.T) {
+	aoiKey := mustGenerateQuadKeyIndexFromSlippy(200, 100, 8)
+	env, err := aoiKey.Envelope()
+	require.NoError(t, err)
+	aoi := env.AsGeometry()
+	children := aoiKey.Children()
+
+	qm := quadmap.NewQuadMap(10)
+
+	// nothing covered, the whole aoi is a gap
+	gaps, err := CoverageGaps(qm, aoi, quadmap.TileTypeDSM, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []quadmap.QuadKey{aoiKey}, gaps)
+
+	// first child full, one zoom 10 tile in the second child (not full, but it's at the
+	// requested zoom so counts) and a tile of another tiletype in the third.
+	x, y, z := children[0].SlippyCoords()
+	_, err = qm.CreateTileAtSlippyCoords(x, y, z, quadmap.TileTypeDSM, true)
+	require.NoError(t, err)
+	covered := children[1].Children()[2]
+	x, y, z = covered.SlippyCoords()
+	_, err = qm.CreateTileAtSlippyCoords(x, y, z, quadmap.TileTypeDSM, false)
+	require.NoError(t, err)
+	x, y, z = children[2].SlippyCoords()
+	_, err = qm.CreateTileAtSlippyCoords(x, y, z, quadmap.TileTypeVert, true)
+	require.NoError(t, err)
+
+	gaps, err = CoverageGaps(qm, aoi, quadmap.TileTypeDSM, 10)
+	require.NoError(t, err)
+	expected := []quadmap.QuadKey{children[2], children[3]}
+	for _, qk := range children[1].Children() {
+		if qk != covered {
+			expected = append(expected, qk)
+		}
+	}
+	assert.ElementsMatch(t, expected, gaps)
+
+	gapGeometry, err := CoverageGapsGeometry(qm, aoi, quadmap.TileTypeDSM, 10)
+	require.NoError(t, err)
+	expectedArea := aoiKey.TileArea(quadmap.AreaDegrees) - children[0].TileArea(quadmap.AreaDegrees) - covered.TileArea(quadmap.AreaDegrees)
+	assert.InEpsilon(t, expectedArea, gapGeometry.Area(), 1e-6)
+
+	// full ancestor covers everything
+	parent, err := aoiKey.Parent()
+	require.NoError(t, err)
+	x, y, z = parent.SlippyCoords()
+	_, err = qm.CreateTileAtSlippyCoords(x, y, z, quadmap.TileTypeDSM, true)
+	require.NoError(t, err)
+	gaps, err = CoverageGaps(qm, aoi, quadmap.TileTypeDSM, 10)
+	require.NoError(t, err)
+	assert.Empty(t, gaps)
+
+	_, err = CoverageGaps(qm, aoi, quadmap.TileTypeDSM, quadmap.MaxZoom+1)
+	assert.Error(t, err)
+}
+
+// TestCoverageGapsEvictedTiles confirms evicted tiles are reloaded, so an evicted full parent
+// still covers the AOI
+func TestCoverageGapsEvictedTiles(t *testing.T) {
+	aoiKey := mustGenerateQuadKeyIndexFromSlippy(200, 100, 8)
+	env, err := aoiKey.Envelope()
+	require.NoError(t, err)
+	aoi := env.AsGeometry()
+
+	// full parent of the aoi
+	parent, err := aoiKey.Parent()
+	require.NoError(t, err)
+	x, y, z := parent.SlippyCoords()
+
+	backing := quadmap.NewQuadMap(10)
+	_, err = backing.CreateTileAtSlippyCoords(x, y, z, quadmap.TileTypeDSM, true)
+	require.NoError(t, err)
+
+	loads := 0
+	qm := quadmap.NewQuadMap(10)
+	qm.SetDataReader(quadmap.BinaryDataReader)
+	qm.SetEvictionPolicy(quadmap.EvictionPolicy{
+		MinEvictableZoom: 1,
+		Loader: func(quadKey quadmap.QuadKey) (*[]byte, error) {
+			loads++
+			tile, err := backing.GetExactTileForQuadKey(quadKey)
+			if err != nil {
+				return nil, err
+			}
+			var buf bytes.Buffer
+			if err := quadmap.EncodeTiles(&buf, []*quadmap.Tile{tile}); err != nil {
+				return nil, err
+			}
+			data := buf.Bytes()
+			return &data, nil
+		},
+	})
+	_, err = qm.CreateTileAtSlippyCoords(x, y, z, quadmap.TileTypeDSM, true)
+	require.NoError(t, err)
+	evicted, err := qm.EvictTilesDeeperThan(6)
+	require.NoError(t, err)
+	require.Equal(t, 1, evicted)
+
+	gaps, err := CoverageGaps(qm, aoi, quadmap.TileTypeDSM, 10)
+	require.NoError(t, err)
+	assert.Empty(t, gaps)
+	assert.Equal(t, 1, loads)
+	assert.Equal(t, 0, qm.NumberOfEvictedTiles())
+}
+
+func TestCoverageGapsPartialAOI(t *testing.T) {
+	qm := quadmap.NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(947, 602, 10, quadmap.TileTypeVert, true)
+	require.NoError(t, err)
+
+	// aoi straddling the east edge of the tile, gaps are only on the east side
+	tileKey := mustGenerateQuadKeyIndexFromSlippy(947, 602, 10)
+	env, err := tileKey.Envelope()
+	require.NoError(t, err)
+	minXY, maxXY, _ := env.MinMaxXYs()
+	width := maxXY.X - minXY.X
+	aoi := geom.NewEnvelope(
+		geom.XY{X: maxXY.X - width/2, Y: minXY.Y + 0.01},
+		geom.XY{X: maxXY.X + width/2, Y: maxXY.Y - 0.01},
+	).AsGeometry()
+
+	gaps, err := CoverageGaps(qm, aoi, quadmap.TileTypeVert, 14)
+	require.NoError(t, err)
+	assert.NotEmpty(t, gaps)
+	for _, qk := range gaps {
+		x, _, _ := qk.SlippyCoords()
+		assert.GreaterOrEqual(t, x>>(qk.Zoom()-10), uint32(948))
+		assert.LessOrEqual(t, qk.Zoom(), byte(14))
+	}
+
+	gapGeometry, err := CoverageGapsGeometry(qm, aoi, quadmap.TileTypeVert, 14)
+	require.NoError(t, err)
+	assert.InEpsilon(t, aoi.Area()/2, gapGeometry.Area(), 1e-6)
+}
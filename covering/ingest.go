@@ -0,0 +1,101 @@
+package covering
+
+import (
+	"errors"
+
+	"github.com/kpfaulkner/quadmap/quadmap"
+	"github.com/peterstace/simplefeatures/geom"
+)
+
+// AddGeometry adds the area of g (eg. a survey footprint) to qm as tileType.
+// Tiles that lie entirely inside g are added as full at the coarsest zoom possible, tiles on the
+// boundary of g are refined down to maxZoom and added as non-full. The ancestors of all added
+// tiles are added as non-full.
+// Existing tiles that are full for tileType (or have a full ancestor) are left as they are, and
+// nothing is added below them.
+// Lives in covering (rather than on QuadMap) since quadmap can't depend on the covering code.
+func AddGeometry(qm QuadMap, g geom.Geometry, tileType quadmap.TileType, maxZoom byte) error {
+	if maxZoom < quadmap.MinZoom || maxZoom > quadmap.MaxZoom {
+		return errors.New("invalid zoom level")
+	}
+	if g.IsEmpty() {
+		return nil
+	}
+
+	a := geometryAdder{
+		qm:       qm,
+		g:        g,
+		areal:    g.Dimension() == 2,
+		tileType: tileType,
+		maxZoom:  maxZoom,
+	}
+	for _, child := range quadmap.QuadKey(0).Children() {
+		if _, err := a.add(child); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type geometryAdder struct {
+	qm QuadMap
+	g  geom.Geometry
+
+	// areal geometries only add tiles they overlap, rather than just touch
+	areal bool
+
+	tileType quadmap.TileType
+	maxZoom  byte
+}
+
+// add adds qk and its descendants that overlap g. Returns true if anything was added
+// (or was already covered).
+func (a geometryAdder) add(qk quadmap.QuadKey) (bool, error) {
+	score, overlap, err := intersection(qk, a.g, quadmap.AreaDegrees)
+	if err != nil {
+		return false, err
+	}
+	if !overlap || (a.areal && score.outsideArea >= score.area) {
+		return false, nil
+	}
+
+	hasTileType := false
+	existing, err := a.qm.GetExactTileForQuadKey(qk)
+	if err != nil && !errors.Is(err, quadmap.TileNotFoundError) {
+		return false, err
+	}
+	if err == nil {
+		var isFull bool
+		hasTileType, isFull = existing.HasTileTypeAndFull(a.tileType)
+		if isFull {
+			return true, nil
+		}
+	}
+
+	x, y, z := qk.SlippyCoords()
+	if a.areal && isContained(score) {
+		_, err := a.qm.CreateTileAtSlippyCoords(x, y, z, a.tileType, true)
+		return err == nil, err
+	}
+
+	if z < a.maxZoom {
+		added := false
+		for _, child := range qk.Children() {
+			childAdded, err := a.add(child)
+			if err != nil {
+				return false, err
+			}
+			added = added || childAdded
+		}
+		if !added {
+			return false, nil
+		}
+	}
+
+	if !hasTileType {
+		if _, err := a.qm.CreateTileAtSlippyCoords(x, y, z, a.tileType, false); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,135 @@
+package covering
+
+import (
+	"testing"
+
+	"github.com/kpfaulkner/quadmap/quadmap"
+	"github.com/peterstace/simplefeatures/geom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// footprint returns a polygon covering tile 8,8,4 and the left third of 9,8,4
+func footprint(t *testing.T) geom.Geometry {
+	env, err := mustGenerateQuadKeyIndexFromSlippy(8, 8, 4).Envelope()
+	require.NoError(t, err)
+	minXY, maxXY, _ := env.MinMaxXYs()
+	width := maxXY.X - minXY.X
+	return geom.NewEnvelope(minXY, geom.XY{X: maxXY.X + width/3, Y: maxXY.Y}).AsGeometry()
+}
+
+func tileState(t *testing.T, qm *quadmap.QuadMap, x, y uint32, z byte) (bool, bool) {
+	tile, err := qm.GetExactTileForSlippy(x, y, z)
+	if err != nil {
+		return false, false
+	}
+	return tile.HasTileTypeAndFull(quadmap.TileTypeVert)
+}
+
+func TestAddGeometry(t *testing.T) {
+	qm := quadmap.NewQuadMap(10)
+	require.NoError(t, AddGeometry(qm, footprint(t), quadmap.TileTypeVert, 6))
+
+	type state struct{ hasTileType, isFull bool }
+	for _, tc := range []struct {
+		x, y   uint32
+		z      byte
+		expect state
+	}{
+		{1, 1, 1, state{true, false}},
+		{2, 2, 2, state{true, false}},
+		{4, 4, 3, state{true, false}},
+		{8, 8, 4, state{true, true}},
+		{9, 8, 4, state{true, false}},
+		{18, 16, 5, state{true, false}},
+		{18, 17, 5, state{true, false}},
+		{36, 32, 6, state{true, true}},
+		{36, 35, 6, state{true, true}},
+		{37, 32, 6, state{true, false}},
+		{37, 35, 6, state{true, false}},
+
+		// interior tiles aren't refined, tiles only touching the footprint aren't added
+		{16, 16, 5, state{}},
+		{38, 32, 6, state{}},
+		{8, 7, 4, state{}},
+	} {
+		hasTileType, isFull := tileState(t, qm, tc.x, tc.y, tc.z)
+		assert.Equal(t, tc.expect, state{hasTileType, isFull}, "%d,%d,%d", tc.x, tc.y, tc.z)
+	}
+	assert.Equal(t, 15, qm.NumberOfTiles())
+
+	covered, _, err := qm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(130, 130, 8, quadmap.TileTypeVert)
+	require.NoError(t, err)
+	assert.True(t, covered)
+}
+
+func TestAddGeometryKeepsExistingFullTiles(t *testing.T) {
+	qm := quadmap.NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(9, 8, 4, quadmap.TileTypeVert, true)
+	require.NoError(t, err)
+
+	require.NoError(t, AddGeometry(qm, footprint(t), quadmap.TileTypeVert, 6))
+
+	hasTileType, isFull := tileState(t, qm, 9, 8, 4)
+	assert.True(t, hasTileType)
+	assert.True(t, isFull)
+	assert.Empty(t, qm.GetTilesForTypeAndZoom(quadmap.TileTypeVert, 5))
+	assert.Empty(t, qm.GetTilesForTypeAndZoom(quadmap.TileTypeVert, 6))
+}
+
+func TestAddGeometryLine(t *testing.T) {
+	qm := quadmap.NewQuadMap(10)
+	line, err := geom.UnmarshalWKT("LINESTRING(1 -1, 2 -2)")
+	require.NoError(t, err)
+
+	require.NoError(t, AddGeometry(qm, line, quadmap.TileTypeVert, 8))
+	assert.NotEmpty(t, qm.GetTilesForTypeAndZoom(quadmap.TileTypeVert, 8))
+	assert.Empty(t, qm.GetTilesForTypeAndZoom(quadmap.TileTypeVert, 9))
+
+	// lines have no area so no tiles are full
+	tiles, err := qm.GetAllTiles(false)
+	require.NoError(t, err)
+	for _, tile := range tiles {
+		_, isFull := tile.HasTileTypeAndFull(quadmap.TileTypeVert)
+		assert.False(t, isFull)
+	}
+}
+
+// TestAddGeometryShardedQuadMap checks the helpers give the same results for a ShardedQuadMap
+// (with the footprint spread over several shards) as for a QuadMap
+func TestAddGeometryShardedQuadMap(t *testing.T) {
+	qm := quadmap.NewQuadMap(10)
+	sqm, err := quadmap.NewShardedQuadMap(5, 10)
+	require.NoError(t, err)
+	require.NoError(t, AddGeometry(qm, footprint(t), quadmap.TileTypeVert, 7))
+	require.NoError(t, AddGeometry(sqm, footprint(t), quadmap.TileTypeVert, 7))
+
+	expected, err := qm.GetAllTiles(true)
+	require.NoError(t, err)
+	actual, err := sqm.GetAllTiles(true)
+	require.NoError(t, err)
+	assert.Equal(t, expected, actual)
+
+	aoi, err := mustGenerateQuadKeyIndexFromSlippy(4, 4, 3).Envelope()
+	require.NoError(t, err)
+	coverage, matches, err := GeometryCoverage(qm, aoi.AsGeometry(), quadmap.TileTypeVert, 20)
+	require.NoError(t, err)
+	shardedCoverage, shardedMatches, err := GeometryCoverage(sqm, aoi.AsGeometry(), quadmap.TileTypeVert, 20)
+	require.NoError(t, err)
+	assert.Equal(t, quadmap.PartiallyCovered, shardedCoverage)
+	assert.Equal(t, coverage, shardedCoverage)
+	assert.ElementsMatch(t, matches, shardedMatches)
+
+	gaps, err := CoverageGaps(qm, aoi.AsGeometry(), quadmap.TileTypeVert, 7)
+	require.NoError(t, err)
+	shardedGaps, err := CoverageGaps(sqm, aoi.AsGeometry(), quadmap.TileTypeVert, 7)
+	require.NoError(t, err)
+	assert.NotEmpty(t, shardedGaps)
+	assert.ElementsMatch(t, gaps, shardedGaps)
+}
+
+func TestAddGeometryInvalidZoom(t *testing.T) {
+	qm := quadmap.NewQuadMap(10)
+	assert.Error(t, AddGeometry(qm, footprint(t), quadmap.TileTypeVert, 0))
+	assert.Error(t, AddGeometry(qm, footprint(t), quadmap.TileTypeVert, quadmap.MaxZoom+1))
+}
@@ -0,0 +1,199 @@
+package quadmap
+
+import (
+	"math"
+
+	"github.com/peterstace/simplefeatures/geom"
+)
+
+// AreaMetric determines how the area of lon/lat geometries is measured
+type AreaMetric int
+
+const (
+	// AreaDegrees is planar area in square degrees of lon/lat. Cheap, but tiles (and geometries)
+	// at high latitudes are under-weighted.
+	AreaDegrees AreaMetric = iota
+
+	// AreaWebMercator is planar area in Web Mercator (EPSG:3857) "metres". All tiles at a given
+	// zoom have the same area.
+	AreaWebMercator
+
+	// AreaGeodesic is the true area in square metres on a spherical earth.
+	AreaGeodesic
+)
+
+const (
+	// EarthRadius is the WGS84 semi-major axis in metres, as used by Web Mercator
+	EarthRadius = 6378137.0
+
+	// maxMercatorLat is the latitude at which Web Mercator is clipped (the edge of the slippy map)
+	maxMercatorLat = 85.05112877980659
+)
+
+// Area returns the area of g (in lon/lat degrees) using the given metric.
+func Area(g geom.Geometry, metric AreaMetric) float64 {
+	switch metric {
+	case AreaWebMercator:
+		return g.TransformXY(WebMercator).Area()
+	case AreaGeodesic:
+		return geodesicArea(g)
+	}
+	return g.Area()
+}
+
+// WebMercator projects a lon/lat point to Web Mercator (EPSG:3857)
+func WebMercator(lonLat geom.XY) geom.XY {
+	lat := math.Max(-maxMercatorLat, math.Min(maxMercatorLat, lonLat.Y))
+	x := EarthRadius * lonLat.X * math.Pi / 180
+	y := EarthRadius * math.Log(math.Tan(math.Pi/4+lat*math.Pi/360))
+	return geom.XY{X: x, Y: y}
+}
+
+// geodesicArea returns the area of polygons in g on a spherical earth, in square metres.
+// Non areal geometries have no area.
+func geodesicArea(g geom.Geometry) float64 {
+	switch g.Type() {
+	case geom.TypePolygon:
+		return polygonGeodesicArea(g.MustAsPolygon())
+	case geom.TypeMultiPolygon:
+		mp := g.MustAsMultiPolygon()
+		area := 0.0
+		for i := 0; i < mp.NumPolygons(); i++ {
+			area += polygonGeodesicArea(mp.PolygonN(i))
+		}
+		return area
+	case geom.TypeGeometryCollection:
+		gc := g.MustAsGeometryCollection()
+		area := 0.0
+		for i := 0; i < gc.NumGeometries(); i++ {
+			area += geodesicArea(gc.GeometryN(i))
+		}
+		return area
+	}
+	return 0
+}
+
+func polygonGeodesicArea(p geom.Polygon) float64 {
+	if p.IsEmpty() {
+		return 0
+	}
+	area := ringGeodesicArea(p.ExteriorRing())
+	for i := 0; i < p.NumInteriorRings(); i++ {
+		area -= ringGeodesicArea(p.InteriorRingN(i))
+	}
+	return math.Max(area, 0)
+}
+
+// ringGeodesicArea returns the area enclosed by a ring on a sphere, using the method from
+// "Some Algorithms for Polygons on a Sphere" (Chamberlain & Duquette, JPL 2007).
+func ringGeodesicArea(ring geom.LineString) float64 {
+	seq := ring.Coordinates()
+	n := seq.Length()
+	if n < 3 {
+		return 0
+	}
+
+	sum := 0.0
+	for i := 0; i < n-1; i++ {
+		p1 := seq.GetXY(i)
+		p2 := seq.GetXY(i + 1)
+		sum += (p2.X - p1.X) * math.Pi / 180 * (2 + math.Sin(p1.Y*math.Pi/180) + math.Sin(p2.Y*math.Pi/180))
+	}
+	return math.Abs(sum * EarthRadius * EarthRadius / 2)
+}
+
+// TileArea returns the area of the tile represented by q using the given metric. Cheaper than
+// calling Area on the tile's envelope.
+func (q QuadKey) TileArea(metric AreaMetric) float64 {
+	x, y, z := q.SlippyCoords()
+	topLeft := SlippyTopLeftToLonLat(x, y, z)
+	bottomRight := SlippyTopLeftToLonLat(x+1, y+1, z)
+
+	switch metric {
+	case AreaWebMercator:
+		side := 2 * math.Pi * EarthRadius / float64(uint64(1)<<z)
+		return side * side
+	case AreaGeodesic:
+		dLon := (bottomRight.X - topLeft.X) * math.Pi / 180
+		return EarthRadius * EarthRadius * dLon * math.Abs(math.Sin(topLeft.Y*math.Pi/180)-math.Sin(bottomRight.Y*math.Pi/180))
+	}
+	return (bottomRight.X - topLeft.X) * (topLeft.Y - bottomRight.Y)
+}
+
+// CoveredArea returns the total area covered by tileType using the given metric (AreaGeodesic
+// for square metres). A full tile covers its whole subtree, so its descendants aren't counted
+// again, and a tile that isn't full only counts if there are no tiles with tileType below it
+// (otherwise the tiles below it describe what is actually covered).
+// Evicted tiles aren't included.
+func (qm *QuadMap) CoveredArea(tileType TileType, metric AreaMetric) float64 {
+	return coveredArea(qm.coveredAreaQuadKeys(tileType), metric)
+}
+
+func coveredArea(quadKeys []QuadKey, metric AreaMetric) float64 {
+	area := 0.0
+	for _, qk := range quadKeys {
+		area += qk.TileArea(metric)
+	}
+	return area
+}
+
+// CoveredAreaInAOI is CoveredArea but only counts the area within aoi (in lon/lat degrees).
+// Tiles on the edge of the aoi are clipped to it.
+func (qm *QuadMap) CoveredAreaInAOI(tileType TileType, metric AreaMetric, aoi geom.Geometry) (float64, error) {
+	return coveredAreaInAOI(qm.coveredAreaQuadKeys(tileType), metric, aoi)
+}
+
+func coveredAreaInAOI(quadKeys []QuadKey, metric AreaMetric, aoi geom.Geometry) (float64, error) {
+	aoiEnv := aoi.Envelope()
+	area := 0.0
+	for _, qk := range quadKeys {
+		env, err := qk.Envelope()
+		if err != nil {
+			return 0, err
+		}
+		if !env.Intersects(aoiEnv) {
+			continue
+		}
+
+		clipped, err := geom.Intersection(env.AsGeometry(), aoi)
+		if err != nil {
+			return 0, err
+		}
+		area += Area(clipped, metric)
+	}
+	return area, nil
+}
+
+// coveredAreaQuadKeys returns the quadkeys of the tiles that make up the area covered by
+// tileType, see CoveredArea. None of the tiles returned overlap.
+func (qm *QuadMap) coveredAreaQuadKeys(tileType TileType) []QuadKey {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+	return coveredAreaQuadKeys(qm.quadKeyMap, tileType)
+}
+
+func coveredAreaQuadKeys(tiles map[QuadKey]*Tile, tileType TileType) []QuadKey {
+	// tiles that have a descendant with tileType
+	hasDescendant := make(map[QuadKey]bool)
+	for quadKey, t := range tiles {
+		if !t.HasTileType(tileType) {
+			continue
+		}
+		for qk, err := quadKey.Parent(); err == nil && !hasDescendant[qk]; qk, err = qk.Parent() {
+			hasDescendant[qk] = true
+		}
+	}
+
+	var keys []QuadKey
+	for quadKey, t := range tiles {
+		hasTileType, isFull := t.HasTileTypeAndFull(tileType)
+		if !hasTileType || (!isFull && hasDescendant[quadKey]) {
+			continue
+		}
+		if hasFullAncestor(tiles, quadKey, tileType) {
+			continue
+		}
+		keys = append(keys, quadKey)
+	}
+	return keys
+}
@@ -0,0 +1,98 @@
+package quadmap
+
+import (
+	"testing"
+
+	"github.com/peterstace/simplefeatures/geom"
+	"github.com/stretchr/testify/assert"
+)
+
+// TestTileAreaMatchesGeometryArea confirms the shortcut tile areas match the generic geometry areas
+func TestTileAreaMatchesGeometryArea(t *testing.T) {
+	for _, qk := range []QuadKey{
+		mustQuadKey(t, 123, 456, 10),
+		mustQuadKey(t, 60292, 39326, 16),
+		mustQuadKey(t, 1, 0, 3),
+	} {
+		env, err := qk.Envelope()
+		assert.NoError(t, err)
+		for _, metric := range []AreaMetric{AreaDegrees, AreaWebMercator, AreaGeodesic} {
+			assert.InEpsilon(t, Area(env.AsGeometry(), metric), qk.TileArea(metric), 1e-6, "metric %d", metric)
+		}
+	}
+}
+
+func TestArea(t *testing.T) {
+	// all tiles at a zoom level have the same web mercator area
+	equator := mustQuadKey(t, 512, 511, 10)
+	north := mustQuadKey(t, 512, 100, 10)
+	assert.InEpsilon(t, equator.TileArea(AreaWebMercator), north.TileArea(AreaWebMercator), 1e-9)
+	assert.Greater(t, equator.TileArea(AreaGeodesic), 3*north.TileArea(AreaGeodesic), "high latitude tiles are smaller")
+
+	// 1x1 degree square on the equator is roughly 12,392 km^2 on the sphere
+	g, err := geom.UnmarshalWKT("POLYGON((0 0, 1 0, 1 1, 0 1, 0 0))")
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 12391.4e6, Area(g, AreaGeodesic), 1e-3)
+	assert.InEpsilon(t, 1, Area(g, AreaDegrees), 1e-9)
+
+	// holes are subtracted
+	g, err = geom.UnmarshalWKT("POLYGON((0 0, 2 0, 2 2, 0 2, 0 0),(0.5 0.5, 1.5 0.5, 1.5 1.5, 0.5 1.5, 0.5 0.5))")
+	assert.NoError(t, err)
+	assert.InEpsilon(t, 3*12391.4e6, Area(g, AreaGeodesic), 1e-2)
+
+	point, err := geom.UnmarshalWKT("POINT(1 1)")
+	assert.NoError(t, err)
+	assert.Zero(t, Area(point, AreaGeodesic))
+}
+
+func TestCoveredArea(t *testing.T) {
+	qm := NewQuadMap(10)
+	full := mustQuadKey(t, 10, 10, 6)
+	_, err := qm.CreateTileAtSlippyCoords(10, 10, 6, TileTypeDSM, true)
+	assert.NoError(t, err)
+
+	// descendants of a full tile aren't counted again
+	_, err = qm.CreateTileAtSlippyCoords(40, 40, 8, TileTypeDSM, false)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, full.TileArea(AreaGeodesic), qm.CoveredArea(TileTypeDSM, AreaGeodesic), 1e-9)
+
+	// a tile that isn't full only counts if there's nothing below it
+	partial := mustQuadKey(t, 0, 0, 5)
+	leaf := mustQuadKey(t, 1, 1, 7)
+	_, err = qm.CreateTileAtSlippyCoords(0, 0, 5, TileTypeDSM, false)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, full.TileArea(AreaGeodesic)+partial.TileArea(AreaGeodesic), qm.CoveredArea(TileTypeDSM, AreaGeodesic), 1e-9)
+	_, err = qm.CreateTileAtSlippyCoords(1, 1, 7, TileTypeDSM, false)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, full.TileArea(AreaGeodesic)+leaf.TileArea(AreaGeodesic), qm.CoveredArea(TileTypeDSM, AreaGeodesic), 1e-9)
+
+	// other tiletypes don't count
+	_, err = qm.CreateTileAtSlippyCoords(20, 20, 6, TileTypeVert, true)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, full.TileArea(AreaWebMercator)+leaf.TileArea(AreaWebMercator), qm.CoveredArea(TileTypeDSM, AreaWebMercator), 1e-9)
+	assert.Equal(t, 0.0, qm.CoveredArea(TileTypeTrueOrtho, AreaGeodesic))
+}
+
+func TestCoveredAreaInAOI(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(0, 0, 1, TileTypeDSM, true)
+	assert.NoError(t, err)
+
+	// the full tile covers the north west quarter of the world, so only the western half of the
+	// aoi is covered
+	aoi, err := geom.UnmarshalWKT("POLYGON((-1 10, 1 10, 1 11, -1 11, -1 10))")
+	assert.NoError(t, err)
+	covered, err := geom.UnmarshalWKT("POLYGON((-1 10, 0 10, 0 11, -1 11, -1 10))")
+	assert.NoError(t, err)
+
+	area, err := qm.CoveredAreaInAOI(TileTypeDSM, AreaGeodesic, aoi)
+	assert.NoError(t, err)
+	assert.InEpsilon(t, Area(covered, AreaGeodesic), area, 1e-6)
+
+	// aoi outside of the coverage
+	aoi, err = geom.UnmarshalWKT("POLYGON((10 -10, 11 -10, 11 -11, 10 -11, 10 -10))")
+	assert.NoError(t, err)
+	area, err = qm.CoveredAreaInAOI(TileTypeDSM, AreaGeodesic, aoi)
+	assert.NoError(t, err)
+	assert.Equal(t, 0.0, area)
+}
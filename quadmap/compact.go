@@ -0,0 +1,149 @@
+package quadmap
+
+// Compact removes redundant information for tileType from the quadmap:
+//   - tiles below a tile that is full for tileType have tileType removed (since the full
+//     ancestor already covers them).
+//   - when all four children of a tile are full for tileType, the tile is marked as full instead
+//     and tileType is removed from the children. This is repeated up the tree.
+//
+// Tiles with metadata for tileType (see TileMetadata) are left as is, since removing tileType
+// from them (or collapsing them into their parent) would lose the metadata.
+// Tiles left without any tiletypes are removed. Results of
+// IsTileCoveredForSlippyCoordsAndTileTypeTopDown for tileType are unchanged (for other tiletypes
+// it can change, since that also returns true if a tile of any type exists at the exact coords).
+// Returns the number of tiles removed from the quadmap.
+func (qm *QuadMap) Compact(tileType TileType) int {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	before := len(qm.quadKeyMap)
+
+	// copy of the keys, since tiles are removed from the index while compacting
+	keys := qm.index.keys()
+
+	// Ancestors sort before their descendants so a full ancestor is always seen (and kept, or
+	// removed) before anything below it.
+	fullByZoom := make([][]QuadKey, MaxZoom+1)
+	for _, qk := range keys {
+		t, ok := qm.quadKeyMap[qk]
+		if !ok {
+			continue
+		}
+		hasTileType, isFull := t.HasTileTypeAndFull(tileType)
+		if !hasTileType {
+			continue
+		}
+		if qm.hasMetadataForTileTypeLocked(t.QuadKey, tileType) {
+			continue
+		}
+		if qm.hasFullAncestorLocked(qk, tileType) {
+			qm.removeTileTypeLocked(t, tileType)
+			continue
+		}
+		if isFull {
+			fullByZoom[qk.Zoom()] = append(fullByZoom[qk.Zoom()], qk)
+		}
+	}
+
+	// collapse full siblings, bottom up. Don't collapse into the root tile (zoom 0)
+	for z := MaxZoom; z > MinZoom; z-- {
+		seen := make(map[QuadKey]bool)
+		for _, qk := range fullByZoom[z] {
+			parent, _ := qk.Parent()
+			if seen[parent] {
+				continue
+			}
+			seen[parent] = true
+
+			if qm.collapseChildrenLocked(parent, tileType) {
+				fullByZoom[z-1] = append(fullByZoom[z-1], parent)
+			}
+		}
+	}
+
+	return before - len(qm.quadKeyMap)
+}
+
+// SetCompactOnInsert enables (or disables) compacting as tiles are created with
+// CreateTileAtSlippyCoords. When a full tile is created, tileType is removed from its
+// descendants, and if it completes a set of four full siblings they are collapsed into their
+// parent (repeating up the tree). See Compact.
+// When that happens CreateTileAtSlippyCoords returns the ancestor the tile was collapsed into,
+// rather than the tile for the requested coords.
+func (qm *QuadMap) SetCompactOnInsert(compact bool) {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+	qm.compactOnInsert = compact
+}
+
+// compactFullTileLocked compacts around a tile that has just been marked as full for tileType.
+// Returns the quadkey of the tile now covering quadKey, ie quadKey or the ancestor it was
+// collapsed into.
+// Caller must hold the write lock.
+func (qm *QuadMap) compactFullTileLocked(quadKey QuadKey, tileType TileType) QuadKey {
+	for _, t := range qm.subtreeTilesLocked(quadKey, tileType) {
+		if t.QuadKey != quadKey && !qm.hasMetadataForTileTypeLocked(t.QuadKey, tileType) {
+			qm.removeTileTypeLocked(t, tileType)
+		}
+	}
+
+	for quadKey.Zoom() > MinZoom {
+		parent, _ := quadKey.Parent()
+		if !qm.collapseChildrenLocked(parent, tileType) {
+			return quadKey
+		}
+		quadKey = parent
+	}
+	return quadKey
+}
+
+// collapseChildrenLocked marks quadKey as full for tileType (and removes tileType from its
+// children) if all four children are full for tileType. Returns true if collapsed.
+// Caller must hold the write lock.
+func (qm *QuadMap) collapseChildrenLocked(quadKey QuadKey, tileType TileType) bool {
+	children := make([]*Tile, 4)
+	for i, child := range quadKey.Children() {
+		t, ok := qm.quadKeyMap[child]
+		if !ok {
+			return false
+		}
+		if _, isFull := t.HasTileTypeAndFull(tileType); !isFull || qm.hasMetadataForTileTypeLocked(t.QuadKey, tileType) {
+			return false
+		}
+		children[i] = t
+	}
+
+	t, ok := qm.quadKeyMap[quadKey]
+	if !ok {
+		t = NewTileWithQuadKey(quadKey)
+		qm.putTileLocked(t)
+	}
+	qm.addTileTypeLocked(t, tileType, true)
+
+	for _, c := range children {
+		qm.removeTileTypeLocked(c, tileType)
+	}
+	return true
+}
+
+// hasFullAncestorLocked returns true if any ancestor of quadKey is full for tileType.
+// Caller must hold (at least) the read lock.
+func (qm *QuadMap) hasFullAncestorLocked(quadKey QuadKey, tileType TileType) bool {
+	return hasFullAncestor(qm.quadKeyMap, quadKey, tileType)
+}
+
+// hasFullAncestor returns true if any ancestor of quadKey in tiles is full for tileType
+func hasFullAncestor(tiles map[QuadKey]*Tile, quadKey QuadKey, tileType TileType) bool {
+	for {
+		parent, err := quadKey.Parent()
+		if err != nil {
+			return false
+		}
+		if t, ok := tiles[parent]; ok {
+			if _, isFull := t.HasTileTypeAndFull(tileType); isFull {
+				return true
+			}
+		}
+		quadKey = parent
+	}
+}
@@ -0,0 +1,171 @@
+package quadmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// coverageSnapshot returns IsTileCoveredForSlippyCoordsAndTileTypeTopDown for every tile
+// under parent, down to zoom.
+func coverageSnapshot(t *testing.T, qm *QuadMap, parent QuadKey, tileType TileType, zoom byte) map[QuadKey]bool {
+	snapshot := make(map[QuadKey]bool)
+	keys := []QuadKey{parent}
+	for i := 0; i < len(keys); i++ {
+		qk := keys[i]
+		x, y, z := qk.SlippyCoords()
+		covered, _, err := qm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(x, y, z, tileType)
+		require.NoError(t, err)
+		snapshot[qk] = covered
+		if z < zoom {
+			keys = append(keys, qk.Children()...)
+		}
+	}
+	return snapshot
+}
+
+func TestCompact(t *testing.T) {
+	qm := NewQuadMap(100)
+	for _, c := range []struct {
+		x, y uint32
+		z    byte
+		tt   TileType
+		full bool
+	}{
+		// chain down to 4,4,4
+		{1, 1, 2, TileTypeVert, false},
+		{2, 2, 3, TileTypeVert, false},
+		{4, 4, 4, TileTypeVert, false},
+
+		// 16 full grandchildren of 4,4,4
+		{16, 16, 6, TileTypeVert, true}, {17, 16, 6, TileTypeVert, true}, {16, 17, 6, TileTypeVert, true}, {17, 17, 6, TileTypeVert, true},
+		{18, 16, 6, TileTypeVert, true}, {19, 16, 6, TileTypeVert, true}, {18, 17, 6, TileTypeVert, true}, {19, 17, 6, TileTypeVert, true},
+		{16, 18, 6, TileTypeVert, true}, {17, 18, 6, TileTypeVert, true}, {16, 19, 6, TileTypeVert, true}, {17, 19, 6, TileTypeVert, true},
+		{18, 18, 6, TileTypeVert, true}, {19, 18, 6, TileTypeVert, true}, {18, 19, 6, TileTypeVert, true}, {19, 19, 6, TileTypeVert, true},
+		{8, 8, 5, TileTypeVert, false}, {9, 8, 5, TileTypeVert, false}, {8, 9, 5, TileTypeVert, false}, {9, 9, 5, TileTypeVert, false},
+
+		// redundant descendant of a full tile
+		{36, 36, 7, TileTypeVert, false},
+
+		// 3 of 4 children full, can't be collapsed
+		{3, 3, 3, TileTypeVert, false},
+		{6, 6, 4, TileTypeVert, true}, {7, 6, 4, TileTypeVert, true}, {6, 7, 4, TileTypeVert, true},
+
+		// another tile type on one of the full tiles
+		{19, 19, 6, TileTypeDSM, false},
+	} {
+		_, err := qm.CreateTileAtSlippyCoords(c.x, c.y, c.z, c.tt, c.full)
+		require.NoError(t, err)
+	}
+
+	root := mustQuadKey(t, 0, 0, 1)
+	before := coverageSnapshot(t, qm, root, TileTypeVert, 8)
+	numTiles := qm.NumberOfTiles()
+
+	removed := qm.Compact(TileTypeVert)
+
+	// 16 grandchildren (one kept for DSM), 4 children, 36,36,7
+	assert.Equal(t, 20, removed)
+	assert.Equal(t, numTiles-removed, qm.NumberOfTiles())
+	assert.Equal(t, before, coverageSnapshot(t, qm, root, TileTypeVert, 8))
+
+	tile, err := qm.GetExactTileForSlippy(4, 4, 4)
+	require.NoError(t, err)
+	_, isFull := tile.HasTileTypeAndFull(TileTypeVert)
+	assert.True(t, isFull)
+
+	tile, err = qm.GetExactTileForSlippy(19, 19, 6)
+	require.NoError(t, err)
+	assert.False(t, tile.HasTileType(TileTypeVert))
+	assert.True(t, tile.HasTileType(TileTypeDSM))
+
+	tile, err = qm.GetExactTileForSlippy(3, 3, 3)
+	require.NoError(t, err)
+	_, isFull = tile.HasTileTypeAndFull(TileTypeVert)
+	assert.False(t, isFull)
+
+	// nothing left to do
+	assert.Equal(t, 0, qm.Compact(TileTypeVert))
+}
+
+func TestCompactOnInsert(t *testing.T) {
+	qm := NewQuadMap(100)
+	qm.SetCompactOnInsert(true)
+
+	_, err := qm.CreateTileAtSlippyCoords(2, 2, 3, TileTypeVert, false)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(16, 16, 6, TileTypeVert, false)
+	require.NoError(t, err)
+
+	for _, c := range [][2]uint32{{4, 4}, {5, 4}, {4, 5}, {5, 5}, {6, 4}, {7, 4}, {6, 5}} {
+		_, err := qm.CreateTileAtSlippyCoords(c[0], c[1], 4, TileTypeVert, true)
+		require.NoError(t, err)
+	}
+
+	// 4,4,4 no longer has the descendant 16,16,6. First 4 tiles collapsed into 2,2,3
+	assert.Equal(t, 4, qm.NumberOfTiles())
+	_, err = qm.GetExactTileForSlippy(16, 16, 6)
+	assert.ErrorIs(t, err, TileNotFoundError)
+
+	// last tile completes 3,2,3 then 2,2,3, 3,2,3, 2,3,3 are not all full so stop there
+	_, err = qm.CreateTileAtSlippyCoords(7, 5, 4, TileTypeVert, true)
+	require.NoError(t, err)
+	assert.Equal(t, 2, qm.NumberOfTiles())
+	for _, c := range [][2]uint32{{2, 2}, {3, 2}} {
+		tile, err := qm.GetExactTileForSlippy(c[0], c[1], 3)
+		require.NoError(t, err)
+		_, isFull := tile.HasTileTypeAndFull(TileTypeVert)
+		assert.True(t, isFull)
+	}
+}
+
+// TestCompactOnInsertReturnsCollapsedTile checks the tile returned when siblings are collapsed is
+// the ancestor they were collapsed into, not the tile for the requested coords
+func TestCompactOnInsertReturnsCollapsedTile(t *testing.T) {
+	qm := NewQuadMap(100)
+	qm.SetCompactOnInsert(true)
+
+	// 2,2,3's children, then the siblings of 2,2,3 except 3,3,3
+	for _, c := range [][3]uint32{{4, 4, 4}, {5, 4, 4}, {4, 5, 4}, {3, 2, 3}, {2, 3, 3}} {
+		tile, err := qm.CreateTileAtSlippyCoords(c[0], c[1], byte(c[2]), TileTypeVert, true)
+		require.NoError(t, err)
+		assert.Equal(t, mustQuadKey(t, c[0], c[1], byte(c[2])), tile.QuadKey)
+	}
+
+	// completes 2,2,3, 1,1,2 isn't complete without 3,3,3
+	tile, err := qm.CreateTileAtSlippyCoords(5, 5, 4, TileTypeVert, true)
+	require.NoError(t, err)
+	assert.Equal(t, mustQuadKey(t, 2, 2, 3), tile.QuadKey, "Should be the ancestor the tile collapsed into")
+	exact, err := qm.GetExactTileForQuadKey(tile.QuadKey)
+	require.NoError(t, err)
+	assert.Same(t, exact, tile)
+	_, isFull := tile.HasTileTypeAndFull(TileTypeVert)
+	assert.True(t, isFull)
+	_, err = qm.GetExactTileForSlippy(5, 5, 4)
+	assert.ErrorIs(t, err, TileNotFoundError)
+
+	// making an existing tile (the remaining child of 1,1,2) full collapses it too
+	tile, err = qm.CreateTileAtSlippyCoords(3, 3, 3, TileTypeVert, false)
+	require.NoError(t, err)
+	assert.Equal(t, mustQuadKey(t, 3, 3, 3), tile.QuadKey)
+	tile, err = qm.CreateTileAtSlippyCoords(3, 3, 3, TileTypeVert, true)
+	require.NoError(t, err)
+	assert.Equal(t, mustQuadKey(t, 1, 1, 2), tile.QuadKey)
+	assert.Equal(t, 1, qm.NumberOfTiles())
+}
+
+// BenchmarkCreateTilesCompactOnInsert creates spread out full tiles with compaction on insert.
+// Each insert only looks at the new tile's subtree and ancestors, so this should scale linearly
+// with the number of tiles.
+func BenchmarkCreateTilesCompactOnInsert(b *testing.B) {
+	for i := 0; i < b.N; i++ {
+		qm := NewQuadMap(8000)
+		qm.SetCompactOnInsert(true)
+		for j := uint32(0); j < 8000; j++ {
+			if _, err := qm.CreateTileAtSlippyCoords(j*7, j*3, 16, TileTypeDSM, true); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package quadmap
+
+import (
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// These tests are mainly useful when run with the race detector (go test -race)
+
+// TestConcurrentAddAndQuery adds tiles while other goroutines query the quadmap
+func TestConcurrentAddAndQuery(t *testing.T) {
+	qm := NewQuadMap(1000)
+	_, err := qm.CreateTileAtSlippyCoords(0, 0, 1, TileTypeVert, false)
+	require.NoError(t, err)
+
+	const numWriters = 4
+	const tilesPerWriter = 200
+
+	var wg sync.WaitGroup
+	for w := 0; w < numWriters; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < tilesPerWriter; i++ {
+				x, y := uint32(w*tilesPerWriter+i), uint32(i)
+				_, err := qm.CreateTileAtSlippyCoords(x, y, 12, TileTypeVert, i%2 == 0)
+				assert.NoError(t, err)
+				_, err = qm.CreateTileAtSlippyCoords(x, y, 12, TileTypeDSM, true)
+				assert.NoError(t, err)
+				err = qm.AddTileMetadata(mustQuadKey(t, x, y, 12), TileMetadata{TileType: TileTypeVert, CaptureDate: time.Unix(int64(i), 0)})
+				assert.NoError(t, err)
+				// and to a tile that already existed, which the queries will have seen
+				err = qm.AddTileMetadata(mustQuadKey(t, 0, 0, 1), TileMetadata{TileType: TileTypeVert, Scale: uint16(w*tilesPerWriter + i)})
+				assert.NoError(t, err)
+			}
+		}(w)
+	}
+
+	for _, query := range []func(){
+		func() { qm.GetAllTiles(true) },
+		func() { qm.NumberOfTiles() },
+		func() { qm.NumberOfTilesForZoom(12) },
+		func() { qm.GetTilesForTypeAndZoom(TileTypeVert, 12) },
+		func() { qm.GetSlippyBoundsForTileTypeAndZoom(TileTypeVert, 12) },
+		func() { qm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(3, 3, 12, TileTypeVert) },
+		func() {
+			qm.TilesInRanges([]QuadKeyRange{mustQuadKey(t, 0, 0, 1).Range()}, TileTypeVert)
+		},
+		func() { qm.CoverageQuadKeys(TileTypeDSM, 12) },
+		func() { qm.NewestMetadataForSlippy(3, 3, 12, TileTypeVert) },
+		func() { qm.GetTileMetadata(mustQuadKey(t, 3, 3, 12), TileTypeVert) },
+		func() { qm.LatestMetadataPerTile(TileTypeVert, TimeWindow{}) },
+		func() { qm.MarshalBinary() },
+		func() { qm.WriteBinary(io.Discard) },
+		func() {
+			qm.Range(func(tile *Tile) bool {
+				tile.HasTileTypeAndFull(TileTypeVert)
+				return true
+			})
+		},
+		func() {
+			tiles, _ := qm.GetAllTiles(false)
+			for _, tile := range tiles {
+				tile.HasTileTypeAndFull(TileTypeDSM)
+			}
+		},
+	} {
+		wg.Add(1)
+		go func(query func()) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				query()
+			}
+		}(query)
+	}
+	wg.Wait()
+
+	assert.Equal(t, numWriters*tilesPerWriter+1, qm.NumberOfTiles())
+	assert.Equal(t, numWriters*tilesPerWriter, qm.NumberOfTilesForZoom(12))
+	assert.Len(t, qm.GetTilesForTypeAndZoom(TileTypeDSM, 12), numWriters*tilesPerWriter)
+}
+
+// TestConcurrentModifyExistingTiles updates the same tiles from multiple goroutines
+func TestConcurrentModifyExistingTiles(t *testing.T) {
+	qm := NewQuadMap(10)
+	for x := uint32(0); x < 4; x++ {
+		_, err := qm.CreateTileAtSlippyCoords(x, 0, 5, TileTypeVert, false)
+		require.NoError(t, err)
+	}
+
+	var wg sync.WaitGroup
+	for i, tt := range []TileType{TileTypeEast, TileTypeNorth, TileTypeSouth, TileTypeWest} {
+		wg.Add(1)
+		go func(tt TileType, full bool) {
+			defer wg.Done()
+			for x := uint32(0); x < 4; x++ {
+				_, err := qm.CreateTileAtSlippyCoords(x, 0, 5, tt, full)
+				assert.NoError(t, err)
+				qm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(x, 0, 5, tt)
+			}
+		}(tt, i%2 == 0)
+	}
+	wg.Wait()
+
+	qm.Range(func(tile *Tile) bool {
+		for i, tt := range []TileType{TileTypeEast, TileTypeNorth, TileTypeSouth, TileTypeWest} {
+			hasTileType, isFull := tile.HasTileTypeAndFull(tt)
+			assert.True(t, hasTileType)
+			assert.Equal(t, i%2 == 0, isFull)
+		}
+		return true
+	})
+}
+
+// TestRange confirms Range stops early and can modify the quadmap while iterating
+func TestRange(t *testing.T) {
+	qm := NewQuadMap(10)
+	for x := uint32(0); x < 8; x++ {
+		_, err := qm.CreateTileAtSlippyCoords(x, 0, 5, TileTypeVert, false)
+		require.NoError(t, err)
+	}
+
+	count := 0
+	qm.Range(func(tile *Tile) bool {
+		count++
+		return count < 3
+	})
+	assert.Equal(t, 3, count, "Should stop when f returns false")
+
+	count = 0
+	qm.Range(func(tile *Tile) bool {
+		count++
+		assert.NoError(t, qm.RemoveTile(tile.QuadKey))
+		return true
+	})
+	assert.Equal(t, 8, count, "Should see all tiles")
+	assert.Equal(t, 0, qm.NumberOfTiles(), "Should have removed all tiles while iterating")
+}
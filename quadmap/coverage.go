@@ -0,0 +1,151 @@
+package quadmap
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Coverage indicates how much of an area is covered by the tiles in a quadmap
+type Coverage int
+
+const (
+	NotCovered Coverage = iota
+	PartiallyCovered
+	FullyCovered
+)
+
+func (c Coverage) String() string {
+	switch c {
+	case NotCovered:
+		return "NotCovered"
+	case PartiallyCovered:
+		return "PartiallyCovered"
+	case FullyCovered:
+		return "FullyCovered"
+	}
+	return fmt.Sprintf("Coverage(%d)", int(c))
+}
+
+// CoverageForQuadKeys determines how much of the area represented by quadKeys (eg. a covering of
+// an AOI) is covered by tiles of tileType.
+// A quadkey is fully covered if it, or one of its ancestors, has tileType and is marked as full.
+// Otherwise it is partially covered if any of its descendants (or itself) has tileType.
+// Also returns the quadkeys of the tiles that provide the coverage, ie the full ancestors and
+// the tiles found below partially covered quadkeys.
+func (qm *QuadMap) CoverageForQuadKeys(quadKeys []QuadKey, tileType TileType) (Coverage, []QuadKey, error) {
+	return coverageForQuadKeys(qm.lookupTile, quadKeys, tileType)
+}
+
+func coverageForQuadKeys(lookup tileLookup, quadKeys []QuadKey, tileType TileType) (Coverage, []QuadKey, error) {
+
+	if len(quadKeys) == 0 {
+		return NotCovered, nil, nil
+	}
+
+	seen := make(map[QuadKey]bool)
+	var matches []QuadKey
+	addMatch := func(qk QuadKey) {
+		if !seen[qk] {
+			seen[qk] = true
+			matches = append(matches, qk)
+		}
+	}
+
+	numFull := 0
+	for _, qk := range quadKeys {
+		fullKey, isFull, err := fullAncestorOrSelf(lookup, qk, tileType)
+		if err != nil {
+			return NotCovered, nil, err
+		}
+		if isFull {
+			numFull++
+			addMatch(fullKey)
+			continue
+		}
+
+		descendants, err := coveringDescendants(lookup, qk, tileType)
+		if err != nil {
+			return NotCovered, nil, err
+		}
+		if len(descendants) == 0 {
+			// tile itself may be present (but not full) without any descendants.
+			t, err := lookup(qk)
+			if err != nil && !errors.Is(err, TileNotFoundError) {
+				return NotCovered, nil, err
+			}
+			if err == nil && t.HasTileType(tileType) {
+				addMatch(qk)
+			}
+		}
+		for _, d := range descendants {
+			addMatch(d)
+		}
+	}
+
+	switch {
+	case numFull == len(quadKeys):
+		return FullyCovered, matches, nil
+	case len(matches) == 0:
+		return NotCovered, nil, nil
+	}
+	return PartiallyCovered, matches, nil
+}
+
+// fullAncestorOrSelf walks up from qk looking for a tile with tileType that is marked as full.
+func fullAncestorOrSelf(lookup tileLookup, qk QuadKey, tileType TileType) (QuadKey, bool, error) {
+	for {
+		t, err := lookup(qk)
+		if err != nil && !errors.Is(err, TileNotFoundError) {
+			return 0, false, err
+		}
+		if err == nil {
+			if hasTileType, isFull := t.HasTileTypeAndFull(tileType); hasTileType && isFull {
+				return qk, true, nil
+			}
+		}
+
+		qk, err = qk.Parent()
+		if err != nil {
+			return 0, false, nil
+		}
+	}
+}
+
+// coveringDescendants returns the descendants of qk with tileType. Traversal stops at full
+// tiles, and for tiles that aren't full the deepest tiles found are returned.
+func coveringDescendants(lookup tileLookup, qk QuadKey, tileType TileType) ([]QuadKey, error) {
+	if qk.Zoom() >= MaxZoom {
+		return nil, nil
+	}
+
+	var keys []QuadKey
+	for _, child := range qk.Children() {
+		t, err := lookup(child)
+		if errors.Is(err, TileNotFoundError) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		hasTileType, isFull := t.HasTileTypeAndFull(tileType)
+		if !hasTileType {
+			continue
+		}
+		if isFull {
+			keys = append(keys, child)
+			continue
+		}
+
+		childKeys, err := coveringDescendants(lookup, child, tileType)
+		if err != nil {
+			return nil, err
+		}
+		if len(childKeys) == 0 {
+			keys = append(keys, child)
+			continue
+		}
+		keys = append(keys, childKeys...)
+	}
+	return keys, nil
+}
@@ -0,0 +1,77 @@
+package quadmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mustQuadKey(t *testing.T, x uint32, y uint32, z byte) QuadKey {
+	qk, err := GenerateQuadKeyIndexFromSlippy(x, y, z)
+	assert.NoError(t, err)
+	return qk
+}
+
+func TestCoverageForQuadKeys(t *testing.T) {
+	qm := NewQuadMap(10)
+
+	// full tile at zoom 3, and a partial hierarchy 4,4,4 -> 8,8,5 (full) + 9,8,5 (not full)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 3, TileTypeVert, true)
+	assert.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(4, 4, 4, TileTypeVert, false)
+	assert.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(8, 8, 5, TileTypeVert, true)
+	assert.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(9, 8, 5, TileTypeVert, false)
+	assert.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(0, 0, 5, TileTypeDSM, true)
+	assert.NoError(t, err)
+
+	for _, tc := range []struct {
+		name      string
+		quadKeys  []QuadKey
+		tileType  TileType
+		coverage  Coverage
+		matchKeys []QuadKey
+	}{
+		{
+			name:     "no keys",
+			tileType: TileTypeVert,
+			coverage: NotCovered,
+		},
+		{
+			name:      "covered by full ancestor",
+			quadKeys:  []QuadKey{mustQuadKey(t, 4, 5, 5), mustQuadKey(t, 2, 2, 4)},
+			tileType:  TileTypeVert,
+			coverage:  FullyCovered,
+			matchKeys: []QuadKey{mustQuadKey(t, 1, 1, 3)},
+		},
+		{
+			name:      "partially covered by descendants",
+			quadKeys:  []QuadKey{mustQuadKey(t, 4, 4, 4)},
+			tileType:  TileTypeVert,
+			coverage:  PartiallyCovered,
+			matchKeys: []QuadKey{mustQuadKey(t, 8, 8, 5), mustQuadKey(t, 9, 8, 5)},
+		},
+		{
+			name:      "mix of covered and not covered",
+			quadKeys:  []QuadKey{mustQuadKey(t, 4, 5, 5), mustQuadKey(t, 0, 0, 5)},
+			tileType:  TileTypeVert,
+			coverage:  PartiallyCovered,
+			matchKeys: []QuadKey{mustQuadKey(t, 1, 1, 3)},
+		},
+		{
+			name:     "wrong tile type",
+			quadKeys: []QuadKey{mustQuadKey(t, 4, 5, 5)},
+			tileType: TileTypeDSM,
+			coverage: NotCovered,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			coverage, matches, err := qm.CoverageForQuadKeys(tc.quadKeys, tc.tileType)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.coverage, coverage)
+			assert.ElementsMatch(t, tc.matchKeys, matches)
+		})
+	}
+}
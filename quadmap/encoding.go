@@ -0,0 +1,575 @@
+package quadmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"maps"
+	"sort"
+	"time"
+)
+
+// Binary format for a serialised QuadMap. All values are little endian.
+//
+//		|---------------------------------- header ----------------------------------|
+//		| magic "QMAP" (4) | version (2) | flags (2) | num tile types (2)             |
+//		| per tile type: value (4) | name length (1) | name (n)                      |
+//		| num tiles (8)                                                              |
+//		|---------------------------------- tiles -----------------------------------|
+//		| per tile: QuadKey (8) | Details (8)     (sorted by QuadKey)                |
+//		|----------------------- metadata (if flag set) -----------------------------|
+//		| num metadata (8)                                                           |
+//		| per metadata: QuadKey (8) | TileType (4) | capture date (8)               |
+//		|               retired date (8) | scale (2) | num details ids (4)           |
+//		|               details ids (8 each)                                         |
+//		|---------------------------------- trailer ---------------------------------|
+//		| CRC32 (IEEE) of everything above (4)                                       |
+//
+// The tile type registry maps the TileType bit values used in the Details of this
+// file to TileType names. When reading, the bits are remapped to the current values
+// for those names. Names that aren't registered when reading are only an error if a tile
+// (or metadata) in the file actually uses them.
+//
+// The metadata section is only present if encodingFlagMetadata is set. Capture
+// and retired dates are unix nanoseconds, 0 meaning no date.
+//
+// Version 1 (still readable) used 2 byte tile type values and the legacy Details layout
+// (see ConvertLegacyDetails).
+
+const (
+	encodingVersion = 2
+
+	// legacyEncodingVersion is the version used before TileType was extended to 32 bits
+	legacyEncodingVersion = 1
+
+	// size of a single encoded tile entry
+	encodedTileSize = 16
+
+	// size of the CRC32 trailer
+	encodedChecksumSize = 4
+
+	// encodingFlagMetadata indicates the metadata section follows the tiles
+	encodingFlagMetadata = 1 << 0
+)
+
+var (
+	encodingMagic = [4]byte{'Q', 'M', 'A', 'P'}
+
+	InvalidEncodingError     = errors.New("invalid quadmap encoding")
+	UnsupportedVersionError  = errors.New("unsupported quadmap encoding version")
+	ChecksumMismatchError    = errors.New("quadmap encoding checksum mismatch")
+	UnknownTileTypeNameError = errors.New("unknown tile type name in quadmap encoding")
+)
+
+// MarshalBinary serialises all tiles in the quadmap.
+func (qm *QuadMap) MarshalBinary() ([]byte, error) {
+	var buf bytes.Buffer
+	if err := qm.WriteBinary(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// UnmarshalBinary populates the quadmap with the serialised tiles in data.
+// Tiles already in the quadmap are merged with the serialised ones.
+func (qm *QuadMap) UnmarshalBinary(data []byte) error {
+	return BinaryDataReader(qm, &data, 0)
+}
+
+// WriteBinary writes all tiles in the quadmap (and their metadata) to w
+func (qm *QuadMap) WriteBinary(w io.Writer) error {
+	tiles, metadata := qm.snapshotWithMetadata()
+	return encodeTiles(w, tiles, metadata)
+}
+
+// snapshotWithMetadata returns copies of all tiles and their metadata. Taken under the read lock
+// so the tiles and metadata are consistent with each other while the quadmap is being modified.
+func (qm *QuadMap) snapshotWithMetadata() ([]*Tile, map[QuadKey][]TileMetadata) {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+
+	tiles := make([]*Tile, 0, len(qm.quadKeyMap))
+	for _, t := range qm.quadKeyMap {
+		tiles = append(tiles, t.clone())
+	}
+	return tiles, maps.Clone(qm.metadata)
+}
+
+// EncodeTiles writes the tiles to w in the quadmap binary format.
+// Tiles are sorted by QuadKey as part of encoding.
+// Metadata is kept by the quadmap rather than the tiles so isn't written, use
+// QuadMap.WriteBinary to include it.
+func EncodeTiles(w io.Writer, tiles []*Tile) error {
+	return encodeTiles(w, tiles, nil)
+}
+
+// encodeTiles writes the tiles, and the metadata for those tiles, to w. See EncodeTiles
+func encodeTiles(w io.Writer, tiles []*Tile, metadata map[QuadKey][]TileMetadata) error {
+	sorted := make([]*Tile, len(tiles))
+	copy(sorted, tiles)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].QuadKey < sorted[j].QuadKey
+	})
+
+	crc := crc32.NewIEEE()
+	bw := &errWriter{w: io.MultiWriter(w, crc)}
+
+	var flags uint16
+	numMetadata := 0
+	for _, t := range sorted {
+		numMetadata += len(metadata[t.QuadKey])
+	}
+	if numMetadata > 0 {
+		flags |= encodingFlagMetadata
+	}
+
+	bw.write(encodingMagic[:])
+	bw.writeUint16(encodingVersion)
+	bw.writeUint16(flags)
+
+	registered := AllTileTypes()
+	bw.writeUint16(uint16(len(registered)))
+	for _, tt := range registered {
+		name := tt.String()
+		bw.writeUint32(uint32(tt))
+		bw.write([]byte{byte(len(name))})
+		bw.write([]byte(name))
+	}
+
+	bw.writeUint64(uint64(len(sorted)))
+	for _, t := range sorted {
+		bw.writeUint64(uint64(t.QuadKey))
+		bw.writeUint64(t.LoadDetails())
+	}
+
+	if flags&encodingFlagMetadata != 0 {
+		bw.writeUint64(uint64(numMetadata))
+		for _, t := range sorted {
+			for _, md := range metadata[t.QuadKey] {
+				bw.writeUint64(uint64(t.QuadKey))
+				bw.writeUint32(uint32(md.TileType))
+				bw.writeUint64(uint64(encodeDate(md.CaptureDate)))
+				bw.writeUint64(uint64(encodeDate(md.RetiredDate)))
+				bw.writeUint16(md.Scale)
+				bw.writeUint32(uint32(len(md.DetailsIDs)))
+				for _, id := range md.DetailsIDs {
+					bw.writeUint64(uint64(id))
+				}
+			}
+		}
+	}
+	if bw.err != nil {
+		return bw.err
+	}
+
+	var sum [encodedChecksumSize]byte
+	binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+	_, err := w.Write(sum[:])
+	return err
+}
+
+// BinaryDataReader is a DataReader for data written by EncodeTiles/MarshalBinary.
+// If tileType is 0 all tile types are read, otherwise only tiles with tileType are
+// read and only the tileType (and its full flag and metadata) is kept for those tiles.
+// Tiles already in the quadmap are merged with the serialised ones.
+func BinaryDataReader(qm *QuadMap, data *[]byte, tileType TileType) error {
+	tiles, metadata, err := decodeTilesForTileType(data, tileType)
+	if err != nil {
+		return err
+	}
+
+	for _, t := range tiles {
+		qm.mergeTile(t, metadata[t.QuadKey])
+	}
+	return nil
+}
+
+// decodeTilesForTileType decodes tiles (and their metadata) written by EncodeTiles. If tileType
+// is not 0 only tiles with tileType are returned, and only the tileType (and its full flag and
+// metadata) is kept.
+func decodeTilesForTileType(data *[]byte, tileType TileType) ([]*Tile, map[QuadKey][]TileMetadata, error) {
+	if data == nil {
+		return nil, nil, InvalidEncodingError
+	}
+
+	tiles, metadata, err := decodeTiles(*data)
+	if err != nil {
+		return nil, nil, err
+	}
+	if tileType == 0 {
+		return tiles, metadata, nil
+	}
+
+	mask := (uint64(tileType) << TileTypeOffset) | uint64(tileType)
+	filtered := tiles[:0]
+	for _, t := range tiles {
+		if !t.HasTileType(tileType) {
+			delete(metadata, t.QuadKey)
+			continue
+		}
+		t.Details &= mask
+		if md := metadataForTileType(metadata[t.QuadKey], tileType); md != nil {
+			metadata[t.QuadKey] = md
+		} else {
+			delete(metadata, t.QuadKey)
+		}
+		filtered = append(filtered, t)
+	}
+	return filtered, metadata, nil
+}
+
+// DecodeTiles decodes tiles written by EncodeTiles. The checksum is verified before
+// any tiles are returned.
+// Metadata isn't returned (see EncodeTiles), use BinaryDataReader to read it into a quadmap.
+func DecodeTiles(data []byte) ([]*Tile, error) {
+	tiles, _, err := decodeTiles(data)
+	return tiles, err
+}
+
+// decodeTiles decodes tiles written by EncodeTiles, along with their metadata.
+func decodeTiles(data []byte) ([]*Tile, map[QuadKey][]TileMetadata, error) {
+	r := bytes.NewReader(data)
+	header, err := decodeHeader(r, int64(len(data)))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := verifyChecksum(r, int64(len(data))); err != nil {
+		return nil, nil, err
+	}
+
+	tiles := make([]*Tile, header.numTiles)
+	offset := header.size
+	for i := range tiles {
+		qk := QuadKey(binary.LittleEndian.Uint64(data[offset:]))
+		details := binary.LittleEndian.Uint64(data[offset+8:])
+		if details, err = header.remapDetails(details); err != nil {
+			return nil, nil, err
+		}
+		tiles[i] = &Tile{QuadKey: qk, Details: details}
+		offset += encodedTileSize
+	}
+
+	var metadata map[QuadKey][]TileMetadata
+	if header.flags&encodingFlagMetadata != 0 {
+		if metadata, err = header.decodeMetadata(r, int64(len(data)), tiles); err != nil {
+			return nil, nil, err
+		}
+	}
+	return tiles, metadata, nil
+}
+
+// decodeMetadata decodes the metadata section, returning the metadata for each of tiles (which
+// must be the sorted tiles from the same data).
+func (h *encodingHeader) decodeMetadata(ra io.ReaderAt, size int64, tiles []*Tile) (map[QuadKey][]TileMetadata, error) {
+	r := &byteReader{r: ra, size: size - encodedChecksumSize, offset: int64(h.metadataOffset())}
+
+	metadata := make(map[QuadKey][]TileMetadata)
+	numMetadata := r.readUint64()
+	for i := uint64(0); i < numMetadata && r.err == nil; i++ {
+		qk := QuadKey(r.readUint64())
+		tileType, err := h.remapTileType(TileType(r.readUint32()))
+		if err != nil {
+			return nil, err
+		}
+		md := TileMetadata{
+			TileType:    tileType,
+			CaptureDate: decodeDate(int64(r.readUint64())),
+			RetiredDate: decodeDate(int64(r.readUint64())),
+			Scale:       r.readUint16(),
+		}
+		numIDs := r.readUint32()
+		if r.err != nil || int64(numIDs)*8 > r.size-r.offset {
+			return nil, InvalidEncodingError
+		}
+		if numIDs > 0 {
+			md.DetailsIDs = make([]int64, numIDs)
+			for j := range md.DetailsIDs {
+				md.DetailsIDs[j] = int64(r.readUint64())
+			}
+		}
+
+		j := sort.Search(len(tiles), func(j int) bool { return tiles[j].QuadKey >= qk })
+		if j >= len(tiles) || tiles[j].QuadKey != qk {
+			return nil, InvalidEncodingError
+		}
+		metadata[qk] = addMetadata(metadata[qk], md)
+	}
+	if r.err != nil || r.offset != r.size {
+		return nil, InvalidEncodingError
+	}
+	return metadata, nil
+}
+
+// encodeDate converts a metadata date to unix nanoseconds, 0 for no date
+func encodeDate(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// decodeDate is the reverse of encodeDate. Dates are returned in UTC
+func decodeDate(nanos int64) time.Time {
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos).UTC()
+}
+
+// encodingHeader is the decoded header of serialised quadmap
+type encodingHeader struct {
+	version  uint16
+	flags    uint16
+	numTiles uint64
+
+	// size of the header in bytes, ie offset of the first tile
+	size int
+
+	// maps TileType bits in the serialised data to the current TileType bits.
+	// nil if no remapping is required.
+	tileTypeMapping map[TileType]TileType
+
+	// TileType bits in the serialised data (and their names) that aren't registered in this
+	// process. nil if there are none.
+	unknownTileTypes map[TileType]string
+}
+
+// decodeHeader decodes and validates the header, including checking that the size of
+// the encoded data matches the number of tiles listed in the header.
+func decodeHeader(ra io.ReaderAt, size int64) (*encodingHeader, error) {
+	r := &byteReader{r: ra, size: size}
+
+	magic := r.read(len(encodingMagic))
+	if r.err != nil || !bytes.Equal(magic, encodingMagic[:]) {
+		return nil, InvalidEncodingError
+	}
+
+	h := &encodingHeader{}
+	h.version = r.readUint16()
+	h.flags = r.readUint16()
+	if r.err != nil {
+		return nil, InvalidEncodingError
+	}
+	if h.version != encodingVersion && h.version != legacyEncodingVersion {
+		return nil, fmt.Errorf("%w: %d", UnsupportedVersionError, h.version)
+	}
+
+	numTileTypes := r.readUint16()
+	for i := 0; i < int(numTileTypes); i++ {
+		var value TileType
+		if h.version == legacyEncodingVersion {
+			value = TileType(r.readUint16())
+		} else {
+			value = TileType(r.readUint32())
+		}
+		nameLen := r.read(1)
+		if r.err != nil {
+			return nil, InvalidEncodingError
+		}
+		name := string(r.read(int(nameLen[0])))
+		if r.err != nil {
+			return nil, InvalidEncodingError
+		}
+
+		current, ok := TileTypeByName(name)
+		if !ok {
+			// only an error if a tile actually uses it, see remapDetails
+			if h.unknownTileTypes == nil {
+				h.unknownTileTypes = make(map[TileType]string)
+			}
+			h.unknownTileTypes[value] = name
+			continue
+		}
+		if current != value {
+			if h.tileTypeMapping == nil {
+				h.tileTypeMapping = make(map[TileType]TileType)
+			}
+			h.tileTypeMapping[value] = current
+		}
+	}
+
+	h.numTiles = r.readUint64()
+	if r.err != nil {
+		return nil, InvalidEncodingError
+	}
+	h.size = int(r.offset)
+
+	expectedSize := uint64(h.size) + h.numTiles*encodedTileSize + encodedChecksumSize
+	if h.numTiles > uint64(size)/encodedTileSize {
+		return nil, InvalidEncodingError
+	}
+	if h.flags&encodingFlagMetadata != 0 {
+		// metadata is variable length, so can only check there is room for the metadata count
+		if uint64(size) < expectedSize+8 {
+			return nil, InvalidEncodingError
+		}
+	} else if uint64(size) != expectedSize {
+		return nil, InvalidEncodingError
+	}
+	return h, nil
+}
+
+// metadataOffset returns the offset of the metadata section, ie just after the tiles
+func (h *encodingHeader) metadataOffset() int {
+	return h.size + int(h.numTiles)*encodedTileSize
+}
+
+// remapTileType converts a TileType from the serialised value to the current value
+// Returns UnknownTileTypeNameError if tt isn't registered in this process.
+func (h *encodingHeader) remapTileType(tt TileType) (TileType, error) {
+	if err := h.checkKnown(tt); err != nil {
+		return 0, err
+	}
+	if to, ok := h.tileTypeMapping[tt]; ok {
+		return to, nil
+	}
+	return tt, nil
+}
+
+// checkKnown returns UnknownTileTypeNameError if any of the TileTypes in tt (serialised values)
+// aren't registered in this process
+func (h *encodingHeader) checkKnown(tt TileType) error {
+	for bit, name := range h.unknownTileTypes {
+		if tt&bit != 0 {
+			return fmt.Errorf("%w: %s", UnknownTileTypeNameError, name)
+		}
+	}
+	return nil
+}
+
+// remapDetails converts TileType bits in details from the serialised values to the
+// current values (and legacy Details to the current layout).
+// Returns UnknownTileTypeNameError if details has a TileType that isn't registered in this process.
+func (h *encodingHeader) remapDetails(details uint64) (uint64, error) {
+	if h.version == legacyEncodingVersion {
+		details = ConvertLegacyDetails(details)
+	}
+	if err := h.checkKnown(TileType(details>>TileTypeOffset) | TileType(details)); err != nil {
+		return 0, err
+	}
+	if h.tileTypeMapping == nil {
+		return details, nil
+	}
+
+	t := Tile{}
+	src := Tile{Details: details}
+	for from, to := range h.tileTypeMapping {
+		if hasTileType, isFull := src.HasTileTypeAndFull(from); hasTileType {
+			t.AddTileType(to, isFull)
+		}
+	}
+
+	// carry over any tile types that kept the same bits
+	var remapped uint64
+	for from := range h.tileTypeMapping {
+		remapped |= (uint64(from) << TileTypeOffset) | uint64(from)
+	}
+	return (details &^ remapped) | t.Details, nil
+}
+
+// verifyChecksum checks the CRC32 trailer against the rest of the encoded data
+func verifyChecksum(ra io.ReaderAt, size int64) error {
+	if size < encodedChecksumSize {
+		return InvalidEncodingError
+	}
+
+	var sum [encodedChecksumSize]byte
+	if _, err := ra.ReadAt(sum[:], size-encodedChecksumSize); err != nil {
+		return InvalidEncodingError
+	}
+
+	crc := crc32.NewIEEE()
+	if _, err := io.Copy(crc, io.NewSectionReader(ra, 0, size-encodedChecksumSize)); err != nil {
+		return err
+	}
+	if crc.Sum32() != binary.LittleEndian.Uint32(sum[:]) {
+		return ChecksumMismatchError
+	}
+	return nil
+}
+
+// errWriter keeps the first error encountered so the encoder doesn't need to check
+// every single write.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (ew *errWriter) write(b []byte) {
+	if ew.err != nil {
+		return
+	}
+	_, ew.err = ew.w.Write(b)
+}
+
+func (ew *errWriter) writeUint16(v uint16) {
+	var b [2]byte
+	binary.LittleEndian.PutUint16(b[:], v)
+	ew.write(b[:])
+}
+
+func (ew *errWriter) writeUint32(v uint32) {
+	var b [4]byte
+	binary.LittleEndian.PutUint32(b[:], v)
+	ew.write(b[:])
+}
+
+func (ew *errWriter) writeUint64(v uint64) {
+	var b [8]byte
+	binary.LittleEndian.PutUint64(b[:], v)
+	ew.write(b[:])
+}
+
+// byteReader reads sequentially from an io.ReaderAt, keeping the first error (reading
+// past the end of the data).
+type byteReader struct {
+	r      io.ReaderAt
+	size   int64
+	offset int64
+	err    error
+}
+
+func (br *byteReader) read(n int) []byte {
+	if br.err != nil {
+		return nil
+	}
+	if br.offset+int64(n) > br.size {
+		br.err = InvalidEncodingError
+		return nil
+	}
+	b := make([]byte, n)
+	if _, err := br.r.ReadAt(b, br.offset); err != nil {
+		br.err = InvalidEncodingError
+		return nil
+	}
+	br.offset += int64(n)
+	return b
+}
+
+func (br *byteReader) readUint16() uint16 {
+	b := br.read(2)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint16(b)
+}
+
+func (br *byteReader) readUint32() uint32 {
+	b := br.read(4)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint32(b)
+}
+
+func (br *byteReader) readUint64() uint64 {
+	b := br.read(8)
+	if b == nil {
+		return 0
+	}
+	return binary.LittleEndian.Uint64(b)
+}
@@ -0,0 +1,234 @@
+package quadmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func populatedQuadMap(t *testing.T) *QuadMap {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 1, TileTypeVert, false)
+	assert.NoError(t, err, "Should not have error when adding tile")
+	_, err = qm.CreateTileAtSlippyCoords(1, 1, 1, TileTypeDSM, true)
+	assert.NoError(t, err, "Should not have error when adding tile")
+	_, err = qm.CreateTileAtSlippyCoords(5, 5, 5, TileTypeNorth, true)
+	assert.NoError(t, err, "Should not have error when adding tile")
+	_, err = qm.CreateTileAtSlippyCoords(60292, 39326, 16, TileTypeTrueOrtho, false)
+	assert.NoError(t, err, "Should not have error when adding tile")
+	return qm
+}
+
+// TestEncodeDecodeRoundTrip confirms a quadmap can be serialised and read back losslessly
+func TestEncodeDecodeRoundTrip(t *testing.T) {
+	qm := populatedQuadMap(t)
+
+	data, err := qm.MarshalBinary()
+	assert.NoError(t, err, "Should not have error when encoding")
+
+	qm2 := NewQuadMap(10)
+	qm2.SetDataReader(BinaryDataReader)
+	err = qm2.ReadData(&data, 0)
+	assert.NoError(t, err, "Should not have error when decoding")
+
+	expected, _ := qm.GetAllTiles(true)
+	actual, _ := qm2.GetAllTiles(true)
+	assert.Equal(t, expected, actual, "Tiles should match after round trip")
+}
+
+// TestDecodeForTileType confirms only the requested tile type is read
+func TestDecodeForTileType(t *testing.T) {
+	qm := populatedQuadMap(t)
+	data, err := qm.MarshalBinary()
+	assert.NoError(t, err, "Should not have error when encoding")
+
+	qm2 := NewQuadMap(10)
+	err = BinaryDataReader(qm2, &data, TileTypeDSM)
+	assert.NoError(t, err, "Should not have error when decoding")
+	assert.EqualValues(t, 1, qm2.NumberOfTiles(), "Should only have DSM tile")
+
+	tile, err := qm2.GetExactTileForSlippy(1, 1, 1)
+	assert.NoError(t, err, "Should have tile")
+	hasTileType, isFull := tile.HasTileTypeAndFull(TileTypeDSM)
+	assert.True(t, hasTileType, "Should have DSM")
+	assert.True(t, isFull, "Should be full")
+	assert.False(t, tile.HasTileType(TileTypeVert), "Should not have Vert")
+}
+
+// TestDecodeCorrupt confirms corrupt or truncated data is rejected
+func TestDecodeCorrupt(t *testing.T) {
+	qm := populatedQuadMap(t)
+	data, err := qm.MarshalBinary()
+	assert.NoError(t, err, "Should not have error when encoding")
+
+	corrupt := make([]byte, len(data))
+	copy(corrupt, data)
+	corrupt[len(corrupt)-10] ^= 0xff
+	_, err = DecodeTiles(corrupt)
+	assert.ErrorIs(t, err, ChecksumMismatchError)
+
+	_, err = DecodeTiles(data[:len(data)-1])
+	assert.ErrorIs(t, err, InvalidEncodingError)
+
+	_, err = DecodeTiles([]byte("nope"))
+	assert.ErrorIs(t, err, InvalidEncodingError)
+}
+
+// TestDecodeRemapsTileTypes confirms tile type bits are remapped via the name registry
+func TestDecodeRemapsTileTypes(t *testing.T) {
+	tile, err := NewTileWithTileTypeAndFull(3, 3, 3, TileTypeVert, true)
+	assert.NoError(t, err, "Should not have error creating tile")
+	tile.AddTileType(TileTypeEast, false)
+
+	var buf bytes.Buffer
+	err = EncodeTiles(&buf, []*Tile{tile})
+	assert.NoError(t, err, "Should not have error when encoding")
+	data := buf.Bytes()
+
+	// swap the values for Vert and East in the registry so Vert data is read as East etc.
+	header, err := decodeHeader(bytes.NewReader(data), int64(len(data)))
+	assert.NoError(t, err)
+	assert.Nil(t, header.tileTypeMapping)
+	header.tileTypeMapping = map[TileType]TileType{TileTypeVert: TileTypeEast, TileTypeEast: TileTypeVert}
+	details, err := header.remapDetails(tile.Details)
+	require.NoError(t, err)
+	remapped := Tile{Details: details}
+
+	hasTileType, isFull := remapped.HasTileTypeAndFull(TileTypeEast)
+	assert.True(t, hasTileType)
+	assert.True(t, isFull)
+	hasTileType, isFull = remapped.HasTileTypeAndFull(TileTypeVert)
+	assert.True(t, hasTileType)
+	assert.False(t, isFull)
+}
+
+// TestDecodeLegacyVersion confirms data written with 2 byte tile types and the legacy Details
+// layout can still be read
+func TestDecodeLegacyVersion(t *testing.T) {
+	qk, err := GenerateQuadKeyIndexFromSlippy(3, 3, 3)
+	assert.NoError(t, err)
+
+	var buf bytes.Buffer
+	crc := crc32.NewIEEE()
+	w := &errWriter{w: io.MultiWriter(&buf, crc)}
+	w.write(encodingMagic[:])
+	w.writeUint16(legacyEncodingVersion)
+	w.writeUint16(0)
+	w.writeUint16(2)
+	for _, tt := range []TileType{TileTypeVert, TileTypeDSM} {
+		w.writeUint16(uint16(tt))
+		w.write([]byte{byte(len(tt.String()))})
+		w.write([]byte(tt.String()))
+	}
+	w.writeUint64(1)
+	w.writeUint64(uint64(qk))
+	w.writeUint64(uint64(TileTypeVert|TileTypeDSM)<<legacyTileTypeOffset | uint64(TileTypeDSM))
+	assert.NoError(t, w.err)
+	var sum [4]byte
+	binary.LittleEndian.PutUint32(sum[:], crc.Sum32())
+	buf.Write(sum[:])
+
+	tiles, err := DecodeTiles(buf.Bytes())
+	assert.NoError(t, err, "Should not have error decoding legacy data")
+	assert.Len(t, tiles, 1)
+	assert.Equal(t, qk, tiles[0].QuadKey)
+
+	hasTileType, isFull := tiles[0].HasTileTypeAndFull(TileTypeDSM)
+	assert.True(t, hasTileType)
+	assert.True(t, isFull)
+	hasTileType, isFull = tiles[0].HasTileTypeAndFull(TileTypeVert)
+	assert.True(t, hasTileType)
+	assert.False(t, isFull)
+}
+
+// TestEncodeRegisteredTileType confirms registered tile types survive a round trip
+func TestEncodeRegisteredTileType(t *testing.T) {
+	tt := MustRegisterTileType("TestEncodeRegisteredTileType")
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(2, 2, 2, tt, true)
+	assert.NoError(t, err, "Should not have error when adding tile")
+
+	data, err := qm.MarshalBinary()
+	assert.NoError(t, err, "Should not have error when encoding")
+
+	qm2 := NewQuadMap(10)
+	err = BinaryDataReader(qm2, &data, tt)
+	assert.NoError(t, err, "Should not have error when decoding")
+	tile, err := qm2.GetExactTileForSlippy(2, 2, 2)
+	assert.NoError(t, err, "Should have tile")
+	hasTileType, isFull := tile.HasTileTypeAndFull(tt)
+	assert.True(t, hasTileType)
+	assert.True(t, isFull)
+}
+
+// TestEncodeMetadata confirms tile metadata survives a round trip
+func TestEncodeMetadata(t *testing.T) {
+	qm := populatedQuadMap(t)
+	vert := TileMetadata{TileType: TileTypeVert, CaptureDate: time.Date(2022, 3, 4, 5, 6, 7, 8, time.UTC), RetiredDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC), Scale: 6, DetailsIDs: []int64{1, 2}}
+	dsm := TileMetadata{TileType: TileTypeDSM, Scale: 3}
+	north := TileMetadata{TileType: TileTypeNorth, DetailsIDs: []int64{-1}}
+	assert.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 1), vert))
+	assert.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 1), dsm))
+	assert.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 5, 5, 5), north))
+
+	data, err := qm.MarshalBinary()
+	assert.NoError(t, err, "Should not have error when encoding")
+
+	qm2 := NewQuadMap(10)
+	assert.NoError(t, qm2.UnmarshalBinary(data), "Should not have error when decoding")
+	expected, _ := qm.GetAllTiles(true)
+	actual, _ := qm2.GetAllTiles(true)
+	assert.Equal(t, expected, actual, "Tiles should match after round trip")
+	assert.Equal(t, qm.metadata, qm2.metadata, "Metadata should match after round trip")
+
+	qm3 := NewQuadMap(10)
+	assert.NoError(t, BinaryDataReader(qm3, &data, TileTypeDSM))
+	md, err := qm3.GetTileMetadata(mustQuadKey(t, 1, 1, 1), TileTypeDSM)
+	assert.NoError(t, err)
+	assert.Equal(t, []TileMetadata{dsm}, md)
+	assert.Equal(t, map[QuadKey][]TileMetadata{mustQuadKey(t, 1, 1, 1): {dsm}}, qm3.metadata, "Should only have DSM metadata")
+
+	_, err = DecodeTiles(data[:len(data)-12])
+	assert.Error(t, err, "Truncated metadata should be rejected")
+}
+
+// TestDecodeUnknownTileTypeName confirms data written by a process with extra TileTypes
+// registered can be read, unless a tile actually uses one of those TileTypes
+func TestDecodeUnknownTileTypeName(t *testing.T) {
+	saved := tileTypes
+	defer func() { tileTypes = saved }()
+
+	// write with an extra TileType registered
+	tileTypes = newTileTypeRegistry()
+	pan, err := RegisterTileType("Pan")
+	require.NoError(t, err)
+	qm := NewQuadMap(10)
+	_, err = qm.CreateTileAtSlippyCoords(1, 1, 2, TileTypeVert, true)
+	require.NoError(t, err)
+	withoutPan, err := qm.MarshalBinary()
+	require.NoError(t, err)
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 2), TileMetadata{TileType: TileTypeVert, Scale: 2}))
+	withMetadata, err := qm.MarshalBinary()
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(2, 1, 2, pan, true)
+	require.NoError(t, err)
+	withPan, err := qm.MarshalBinary()
+	require.NoError(t, err)
+
+	// and read without it
+	tileTypes = newTileTypeRegistry()
+	for _, data := range [][]byte{withoutPan, withMetadata} {
+		tiles, err := DecodeTiles(data)
+		require.NoError(t, err)
+		require.Len(t, tiles, 1)
+		assert.True(t, tiles[0].HasTileType(TileTypeVert))
+	}
+	_, err = DecodeTiles(withPan)
+	assert.ErrorIs(t, err, UnknownTileTypeNameError)
+}
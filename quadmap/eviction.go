@@ -0,0 +1,343 @@
+package quadmap
+
+import (
+	"errors"
+	"slices"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"unsafe"
+)
+
+const (
+	// mapEntryBytes is a rough estimate of the overhead of a map entry (hash bucket space etc.)
+	// on top of the key and value themselves.
+	mapEntryBytes = 16
+
+	// evictionBatchDivisor controls how far under budget eviction goes. Once over budget,
+	// tiles are evicted until the quadmap is 1/evictionBatchDivisor under the limits, so the
+	// (least recently used) tiles don't have to be found again for every tile added.
+	evictionBatchDivisor = 10
+)
+
+var (
+	// tileBytes is the approximate memory used by a tile in memory, ie the Tile along with its
+	// entries in the quadkey map and the index. Metadata is counted separately.
+	tileBytes = int64(unsafe.Sizeof(Tile{})) + 2*int64(unsafe.Sizeof(QuadKey(0))) + mapEntryBytes
+
+	// evictedTileBytes is the approximate memory used to record an evicted tile
+	evictedTileBytes = int64(unsafe.Sizeof(QuadKey(0))) + mapEntryBytes
+)
+
+var NoEvictionPolicyError = errors.New("no eviction policy set")
+
+// TileLoader returns serialised data (readable by the quadmap's DataReader) that
+// contains the tile for quadKey. It may also contain other tiles (eg. the descendants
+// of quadKey), these will also be added to the quadmap.
+type TileLoader func(quadKey QuadKey) (*[]byte, error)
+
+// EvictionPolicy determines when tiles are evicted from a QuadMap.
+// Evicted tiles are reloaded on demand (via Loader and the DataReader) when they are
+// next requested through lookups such as GetExactTileForQuadKey,
+// IsTileCoveredForSlippyCoordsAndTileTypeTopDown or TilesInRanges.
+// Functions that scan the whole quadmap (eg. GetAllTiles, NumberOfTiles) only see the tiles
+// currently in memory.
+// Once over a limit, tiles are evicted (least recently used first) until the quadmap is a
+// little under it, so it doesn't have to happen again for every tile added.
+type EvictionPolicy struct {
+	// MaxTiles is the maximum number of tiles kept in memory. 0 means no limit.
+	MaxTiles int
+
+	// MaxMemoryBytes is the approximate memory budget for tiles, their metadata and the records
+	// of evicted tiles (kept so they can be reloaded). 0 means no limit.
+	MaxMemoryBytes int64
+
+	// MinEvictableZoom is the lowest zoom level that can be evicted. Tiles at a lower zoom
+	// (ie larger tiles) are never evicted.
+	MinEvictableZoom byte
+
+	// Loader is used to reload evicted tiles. If nil, evicted tiles are simply dropped (and
+	// not recorded as evicted).
+	Loader TileLoader
+}
+
+// evictionState tracks when tiles were last used and which tiles have been evicted.
+// Tiles record when they were last used (Tile.lastUsed) from clock, so reads don't need a lock.
+// Lock ordering: QuadMap.lock is always acquired before evictionState.lock
+type evictionState struct {
+	policy EvictionPolicy
+
+	// advanced every time a tile is used, see touch
+	clock atomic.Uint64
+
+	// approximate memory used by tile metadata. Only modified while holding the QuadMap
+	// write lock.
+	metadataBytes int64
+
+	// tiles that have been evicted and can be reloaded
+	evicted map[QuadKey]struct{}
+
+	// tiles being reloaded, which can't be evicted until the reload has finished. Otherwise
+	// a reload that also loads lots of other tiles (eg. descendants) could evict the tile it
+	// is reloading.
+	pinned map[QuadKey]int
+
+	lock sync.Mutex
+}
+
+func newEvictionState(policy EvictionPolicy) *evictionState {
+	return &evictionState{
+		policy:  policy,
+		evicted: make(map[QuadKey]struct{}),
+		pinned:  make(map[QuadKey]int),
+	}
+}
+
+// metadataSize returns the approximate memory used by the metadata of a tile
+func metadataSize(metadata []TileMetadata) int64 {
+	if len(metadata) == 0 {
+		return 0
+	}
+	size := int64(unsafe.Sizeof(QuadKey(0))) + int64(unsafe.Sizeof(metadata)) + mapEntryBytes
+	for _, md := range metadata {
+		size += int64(unsafe.Sizeof(md)) + int64(len(md.DetailsIDs))*int64(unsafe.Sizeof(int64(0)))
+	}
+	return size
+}
+
+// SetEvictionPolicy sets the eviction policy for the quadmap and immediately evicts tiles
+// if the quadmap is over budget.
+// Existing tiles are considered least recently used in an arbitrary order.
+func (qm *QuadMap) SetEvictionPolicy(policy EvictionPolicy) {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	qm.eviction = newEvictionState(policy)
+	for _, t := range qm.quadKeyMap {
+		atomic.StoreUint64(&t.lastUsed, 0)
+	}
+	for _, metadata := range qm.metadata {
+		qm.eviction.metadataBytes += metadataSize(metadata)
+	}
+	qm.enforceEvictionPolicyLocked()
+}
+
+// EvictTilesDeeperThan evicts all tiles with a zoom level greater than zoom, regardless of
+// when they were last used (tiles that are being reloaded aren't evicted). Returns the number of
+// tiles evicted.
+func (qm *QuadMap) EvictTilesDeeperThan(zoom byte) (int, error) {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	if qm.eviction == nil {
+		return 0, NoEvictionPolicyError
+	}
+
+	count := 0
+	for qk := range qm.quadKeyMap {
+		if qk.Zoom() > zoom && !qm.eviction.isPinned(qk) {
+			qm.evictTileLocked(qk)
+			count++
+		}
+	}
+	return count, nil
+}
+
+// NumberOfEvictedTiles returns the number of tiles that have been evicted and not (yet) reloaded.
+// Evicted tiles are only recorded if the EvictionPolicy has a Loader.
+func (qm *QuadMap) NumberOfEvictedTiles() int {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+
+	if qm.eviction == nil {
+		return 0
+	}
+	qm.eviction.lock.Lock()
+	defer qm.eviction.lock.Unlock()
+	return len(qm.eviction.evicted)
+}
+
+// enforceEvictionPolicyLocked evicts least recently used tiles if the quadmap is over the policy
+// limits, until it's a little under them (or there are no evictable tiles left).
+// Caller must hold the write lock.
+func (qm *QuadMap) enforceEvictionPolicyLocked() {
+	e := qm.eviction
+	if e == nil || !e.overLimits(len(qm.quadKeyMap), false) {
+		return
+	}
+
+	var candidates []*Tile
+	e.lock.Lock()
+	for qk, t := range qm.quadKeyMap {
+		if qk.Zoom() >= e.policy.MinEvictableZoom && e.pinned[qk] == 0 {
+			candidates = append(candidates, t)
+		}
+	}
+	e.lock.Unlock()
+
+	sort.Slice(candidates, func(i, j int) bool {
+		return atomic.LoadUint64(&candidates[i].lastUsed) < atomic.LoadUint64(&candidates[j].lastUsed)
+	})
+	for _, t := range candidates {
+		if !e.overLimits(len(qm.quadKeyMap), true) {
+			return
+		}
+		qm.evictTileLocked(t.QuadKey)
+	}
+}
+
+// overLimits returns true if the quadmap, with numTiles tiles, is over the policy limits. If
+// margin is true the limits are lowered by 1/evictionBatchDivisor, see EvictionPolicy.
+// Caller must hold the QuadMap lock.
+func (e *evictionState) overLimits(numTiles int, margin bool) bool {
+	lower := func(limit int64) int64 {
+		if margin {
+			return limit - limit/evictionBatchDivisor
+		}
+		return limit
+	}
+
+	if e.policy.MaxTiles > 0 && int64(numTiles) > lower(int64(e.policy.MaxTiles)) {
+		return true
+	}
+	if e.policy.MaxMemoryBytes <= 0 {
+		return false
+	}
+	e.lock.Lock()
+	numEvicted := len(e.evicted)
+	e.lock.Unlock()
+	bytes := int64(numTiles)*tileBytes + e.metadataBytes + int64(numEvicted)*evictedTileBytes
+	return bytes > lower(e.policy.MaxMemoryBytes)
+}
+
+// evictTileLocked removes tile from the quadmap and records it as evicted (if it can be reloaded).
+// Caller must hold the write lock.
+func (qm *QuadMap) evictTileLocked(qk QuadKey) {
+	qm.deleteTileLocked(qk)
+	if qm.eviction.policy.Loader != nil {
+		qm.eviction.evictedTile(qk)
+	}
+}
+
+// reloadEvictedTile reloads the tile for qk if it was previously evicted (according to e, the
+// quadmap's eviction state).
+// Returns TileNotFoundError if the tile was not evicted or could not be reloaded.
+// The tile stays recorded as evicted until it has been reloaded (see evictionState.added), so
+// if the Loader fails it can be retried on the next lookup.
+func (qm *QuadMap) reloadEvictedTile(e *evictionState, qk QuadKey) (*Tile, error) {
+	e.lock.Lock()
+	_, wasEvicted := e.evicted[qk]
+	e.lock.Unlock()
+
+	if !wasEvicted || e.policy.Loader == nil {
+		return nil, TileNotFoundError
+	}
+
+	e.pin(qk)
+	defer e.unpin(qk)
+
+	data, err := e.policy.Loader(qk)
+	if err != nil {
+		return nil, err
+	}
+	if err := qm.ReadData(data, 0); err != nil {
+		return nil, err
+	}
+
+	qm.lock.RLock()
+	t, ok := qm.quadKeyMap[qk]
+	qm.lock.RUnlock()
+	if !ok {
+		// loaded fine but the tile isn't in the data (qk is pinned, so it can't have been
+		// evicted again), so there is nothing to reload.
+		e.lock.Lock()
+		delete(e.evicted, qk)
+		e.lock.Unlock()
+		return nil, TileNotFoundError
+	}
+	e.touch(t)
+	return t, nil
+}
+
+// reloadEvictedTilesInRanges reloads the evicted tiles that TilesInRanges would return for
+// ranges. Tiles that can't be found by the Loader are skipped.
+func (qm *QuadMap) reloadEvictedTilesInRanges(ranges []QuadKeyRange) error {
+	qm.lock.RLock()
+	e := qm.eviction
+	qm.lock.RUnlock()
+	if e == nil || e.policy.Loader == nil {
+		return nil
+	}
+
+	var keys []QuadKey
+	e.lock.Lock()
+	for qk := range e.evicted {
+		for _, r := range ranges {
+			if r.Contains(qk) && rangeMatches(r, qk) {
+				keys = append(keys, qk)
+				break
+			}
+		}
+	}
+	e.lock.Unlock()
+
+	// ancestors first, their data may include the descendants
+	slices.Sort(keys)
+	for _, qk := range keys {
+		if _, err := qm.reloadEvictedTile(e, qk); err != nil && !errors.Is(err, TileNotFoundError) {
+			return err
+		}
+	}
+	return nil
+}
+
+// added records tile t being added to the quadmap as the most recently used tile
+func (e *evictionState) added(t *Tile) {
+	atomic.StoreUint64(&t.lastUsed, e.clock.Add(1))
+
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	delete(e.evicted, t.QuadKey)
+}
+
+// evictedTile records the tile for qk as evicted
+func (e *evictionState) evictedTile(qk QuadKey) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+	e.evicted[qk] = struct{}{}
+}
+
+// touch marks tile t as the most recently used. Doesn't take a lock, so readers holding the
+// QuadMap read lock don't contend with each other here. The clock is only advanced if t isn't
+// already the most recently used tile, so reading the same tile repeatedly doesn't write to
+// memory shared with the other readers.
+func (e *evictionState) touch(t *Tile) {
+	if atomic.LoadUint64(&t.lastUsed) == e.clock.Load() {
+		return
+	}
+	atomic.StoreUint64(&t.lastUsed, e.clock.Add(1))
+}
+
+// pin stops qk from being evicted until unpin is called (the same number of times)
+func (e *evictionState) pin(qk QuadKey) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	e.pinned[qk]++
+}
+
+func (e *evictionState) unpin(qk QuadKey) {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	if e.pinned[qk]--; e.pinned[qk] <= 0 {
+		delete(e.pinned, qk)
+	}
+}
+
+func (e *evictionState) isPinned(qk QuadKey) bool {
+	e.lock.Lock()
+	defer e.lock.Unlock()
+
+	return e.pinned[qk] > 0
+}
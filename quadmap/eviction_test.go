@@ -0,0 +1,194 @@
+package quadmap
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// backingLoader returns a TileLoader that serves tiles from a separate quadmap
+func backingLoader(t *testing.T, backing *QuadMap, loads *int) TileLoader {
+	return func(quadKey QuadKey) (*[]byte, error) {
+		*loads++
+		tile, err := backing.GetExactTileForQuadKey(quadKey)
+		if err != nil {
+			return nil, err
+		}
+		var buf bytes.Buffer
+		err = EncodeTiles(&buf, []*Tile{tile})
+		assert.NoError(t, err, "Should not have error encoding tile")
+		data := buf.Bytes()
+		return &data, nil
+	}
+}
+
+// TestEvictLeastRecentlyUsed confirms least recently used tiles are evicted and reloaded on demand
+func TestEvictLeastRecentlyUsed(t *testing.T) {
+	backing := populatedQuadMap(t)
+	loads := 0
+
+	qm := NewQuadMap(10)
+	qm.SetDataReader(BinaryDataReader)
+	qm.SetEvictionPolicy(EvictionPolicy{MaxTiles: 2, MinEvictableZoom: 2, Loader: backingLoader(t, backing, &loads)})
+
+	// zoom 1 tile can never be evicted.
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 1, TileTypeDSM, true)
+	assert.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(5, 5, 5, TileTypeNorth, true)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, qm.NumberOfTiles())
+
+	_, err = qm.CreateTileAtSlippyCoords(60292, 39326, 16, TileTypeTrueOrtho, false)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 2, qm.NumberOfTiles(), "Should have evicted a tile")
+	assert.EqualValues(t, 1, qm.NumberOfEvictedTiles())
+
+	// 5,5,5 was least recently used so should have been evicted.
+	qk, _ := GenerateQuadKeyIndexFromSlippy(5, 5, 5)
+	tile, err := qm.GetExactTileForQuadKey(qk)
+	assert.NoError(t, err, "Evicted tile should be reloaded")
+	assert.True(t, tile.HasTileType(TileTypeNorth))
+	assert.EqualValues(t, 1, loads, "Should have loaded once")
+
+	// reloading evicts the zoom 16 tile instead.
+	assert.EqualValues(t, 2, qm.NumberOfTiles())
+	_, err = qm.GetExactTileForSlippy(1, 1, 1)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, loads, "Zoom 1 tile should never be evicted")
+
+	// tiles never added are not loaded.
+	_, err = qm.GetExactTileForSlippy(0, 0, 8)
+	assert.ErrorIs(t, err, TileNotFoundError)
+	assert.EqualValues(t, 1, loads)
+}
+
+// TestEvictTilesDeeperThan confirms depth based eviction and reload via ancestor traversal
+func TestEvictTilesDeeperThan(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.EvictTilesDeeperThan(3)
+	assert.ErrorIs(t, err, NoEvictionPolicyError)
+
+	backing := NewQuadMap(10)
+	_, err = backing.CreateTileAtSlippyCoords(2, 2, 3, TileTypeVert, true)
+	assert.NoError(t, err)
+	_, err = backing.CreateTileAtSlippyCoords(17, 17, 5, TileTypeVert, true)
+	assert.NoError(t, err)
+	data, err := backing.MarshalBinary()
+	assert.NoError(t, err)
+
+	loads := 0
+	qm.SetDataReader(BinaryDataReader)
+	err = qm.ReadData(&data, 0)
+	assert.NoError(t, err)
+	qm.SetEvictionPolicy(EvictionPolicy{Loader: backingLoader(t, backing, &loads)})
+
+	count, err := qm.EvictTilesDeeperThan(3)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, count, "Should evict zoom 5 tile")
+	assert.EqualValues(t, 1, qm.NumberOfTiles())
+
+	covered, coveredKey, err := qm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(34, 35, 6, TileTypeVert)
+	assert.NoError(t, err)
+	assert.True(t, covered, "Should be covered by reloaded zoom 5 tile")
+	expected, _ := GenerateQuadKeyIndexFromSlippy(17, 17, 5)
+	assert.Equal(t, expected, coveredKey)
+	assert.EqualValues(t, 1, loads)
+	assert.EqualValues(t, 2, qm.NumberOfTiles())
+}
+
+// TestEvictedTileReloadRetried confirms a tile stays evicted (rather than being lost) if the
+// Loader fails, so it can be reloaded on a later lookup.
+func TestEvictedTileReloadRetried(t *testing.T) {
+	backing := populatedQuadMap(t)
+	loads := 0
+	loader := backingLoader(t, backing, &loads)
+	loadErr := errors.New("load failed")
+	fail := true
+
+	qm := NewQuadMap(10)
+	qm.SetDataReader(BinaryDataReader)
+	qm.SetEvictionPolicy(EvictionPolicy{MaxTiles: 1, Loader: func(quadKey QuadKey) (*[]byte, error) {
+		if fail {
+			fail = false
+			return nil, loadErr
+		}
+		return loader(quadKey)
+	}})
+
+	_, err := qm.CreateTileAtSlippyCoords(5, 5, 5, TileTypeNorth, true)
+	assert.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(60292, 39326, 16, TileTypeTrueOrtho, false)
+	assert.NoError(t, err)
+	assert.EqualValues(t, 1, qm.NumberOfEvictedTiles())
+
+	_, err = qm.GetExactTileForSlippy(5, 5, 5)
+	assert.ErrorIs(t, err, loadErr)
+	assert.EqualValues(t, 1, qm.NumberOfEvictedTiles(), "Should still be evicted after failed load")
+
+	tile, err := qm.GetExactTileForSlippy(5, 5, 5)
+	assert.NoError(t, err, "Evicted tile should be reloaded on retry")
+	assert.True(t, tile.HasTileType(TileTypeNorth))
+	assert.EqualValues(t, 1, loads)
+}
+
+// TestEvictedTileReloadWithDescendants confirms a reloaded tile isn't evicted again by the other
+// tiles loaded with it, when they don't all fit in the memory budget.
+func TestEvictedTileReloadWithDescendants(t *testing.T) {
+	parent := mustQuadKey(t, 5, 5, 5)
+	backing := NewQuadMap(10)
+	for _, qk := range append([]QuadKey{parent}, parent.Children()...) {
+		x, y, z := qk.SlippyCoords()
+		_, err := backing.CreateTileAtSlippyCoords(x, y, z, TileTypeDSM, false)
+		require.NoError(t, err)
+	}
+	data, err := backing.MarshalBinary()
+	require.NoError(t, err)
+
+	loads := 0
+	qm := NewQuadMap(10)
+	qm.SetDataReader(BinaryDataReader)
+	qm.SetEvictionPolicy(EvictionPolicy{
+		MaxMemoryBytes:   2 * tileBytes,
+		MinEvictableZoom: 1,
+		Loader: func(quadKey QuadKey) (*[]byte, error) {
+			loads++
+			return &data, nil
+		},
+	})
+	_, err = qm.CreateTileAtSlippyCoords(5, 5, 5, TileTypeDSM, false)
+	require.NoError(t, err)
+	_, err = qm.EvictTilesDeeperThan(4)
+	require.NoError(t, err)
+
+	tile, err := qm.GetExactTileForQuadKey(parent)
+	require.NoError(t, err, "Reloaded tile should not be evicted by its descendants")
+	assert.Equal(t, parent, tile.QuadKey)
+	assert.EqualValues(t, 1, loads)
+	assert.LessOrEqual(t, qm.NumberOfTiles(), 2)
+
+	// the children that didn't fit are evicted, so they can be reloaded later
+	assert.EqualValues(t, 5, qm.NumberOfTiles()+qm.NumberOfEvictedTiles())
+}
+
+// TestEvictionCountsMetadata confirms metadata counts towards MaxMemoryBytes, and that evicted
+// tiles aren't recorded when there is no Loader to reload them
+func TestEvictionCountsMetadata(t *testing.T) {
+	qm := NewQuadMap(10)
+	qm.SetEvictionPolicy(EvictionPolicy{MaxMemoryBytes: 3 * tileBytes, MinEvictableZoom: 2})
+
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 1, TileTypeVert, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(5, 5, 5, TileTypeVert, true)
+	require.NoError(t, err)
+	assert.EqualValues(t, 2, qm.NumberOfTiles())
+
+	md := TileMetadata{TileType: TileTypeVert, DetailsIDs: make([]int64, 100)}
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 1), md))
+	assert.EqualValues(t, 1, qm.NumberOfTiles(), "Metadata should push the quadmap over budget")
+	_, err = qm.GetExactTileForSlippy(1, 1, 1)
+	assert.NoError(t, err, "Zoom 1 tile can't be evicted")
+	assert.EqualValues(t, 0, qm.NumberOfEvictedTiles(), "Evicted tiles can't be reloaded without a Loader")
+}
@@ -0,0 +1,107 @@
+package quadmap
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/peterstace/simplefeatures/geom"
+)
+
+// CoverageQuadKeys returns the quadkeys that make up the coverage of tileType at zoom. ie tiles
+// at zoom with tileType, and full tiles at a lower zoom (which cover all their descendants).
+// Tiles already covered by a full ancestor aren't included. Quadkeys are sorted.
+func (qm *QuadMap) CoverageQuadKeys(tileType TileType, zoom byte) []QuadKey {
+	tiles := qm.coverageTiles(tileType, zoom)
+	keys := make([]QuadKey, len(tiles))
+	for i, t := range tiles {
+		keys[i] = t.QuadKey
+	}
+	return keys
+}
+
+// coverageTiles returns the tiles for CoverageQuadKeys, sorted by quadkey
+func (qm *QuadMap) coverageTiles(tileType TileType, zoom byte) []*Tile {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+
+	tiles := []*Tile{}
+	for quadKey, t := range qm.quadKeyMap {
+		z := quadKey.Zoom()
+		if z > zoom {
+			continue
+		}
+		hasTileType, isFull := t.HasTileTypeAndFull(tileType)
+		if !hasTileType || (z != zoom && !isFull) {
+			continue
+		}
+		if qm.hasFullAncestorLocked(quadKey, tileType) {
+			continue
+		}
+		tiles = append(tiles, t)
+	}
+
+	sort.Slice(tiles, func(i, j int) bool { return tiles[i].QuadKey < tiles[j].QuadKey })
+	return tiles
+}
+
+// CoverageFeatures returns the coverage of tileType at zoom (see CoverageQuadKeys) as a GeoJSON
+// FeatureCollection with a polygon per tile. Each feature has the properties quadkey (as a string,
+// since it doesn't fit in a JSON number), x, y, z and full.
+func (qm *QuadMap) CoverageFeatures(tileType TileType, zoom byte) (geom.GeoJSONFeatureCollection, error) {
+	tiles := qm.coverageTiles(tileType, zoom)
+
+	features := make(geom.GeoJSONFeatureCollection, 0, len(tiles))
+	for _, t := range tiles {
+		qk := t.QuadKey
+		env, err := qk.Envelope()
+		if err != nil {
+			return nil, err
+		}
+		_, isFull := t.HasTileTypeAndFull(tileType)
+
+		x, y, z := qk.SlippyCoords()
+		features = append(features, geom.GeoJSONFeature{
+			Geometry: env.AsGeometry(),
+			Properties: map[string]interface{}{
+				"quadkey": fmt.Sprintf("%d", uint64(qk)),
+				"x":       x,
+				"y":       y,
+				"z":       z,
+				"full":    isFull,
+			},
+		})
+	}
+	return features, nil
+}
+
+// CoverageGeometry returns the coverage of tileType at zoom (see CoverageQuadKeys) dissolved
+// into a single MultiPolygon. Use AsText on the result for WKT.
+func (qm *QuadMap) CoverageGeometry(tileType TileType, zoom byte) (geom.Geometry, error) {
+	return DissolveQuadKeys(qm.CoverageQuadKeys(tileType, zoom))
+}
+
+// DissolveQuadKeys returns the union of the tiles for quadKeys as a MultiPolygon.
+// If there are no quadkeys then an empty MultiPolygon is returned.
+func DissolveQuadKeys(quadKeys []QuadKey) (geom.Geometry, error) {
+	tiles := make([]geom.Geometry, 0, len(quadKeys))
+	for _, qk := range quadKeys {
+		env, err := qk.Envelope()
+		if err != nil {
+			return geom.Geometry{}, err
+		}
+		tiles = append(tiles, env.AsGeometry())
+	}
+
+	dissolved, err := geom.UnionMany(tiles)
+	if err != nil {
+		return geom.Geometry{}, err
+	}
+
+	switch dissolved.Type() {
+	case geom.TypeMultiPolygon:
+		return dissolved, nil
+	case geom.TypePolygon:
+		return dissolved.MustAsPolygon().AsMultiPolygon().AsGeometry(), nil
+	}
+	return geom.MultiPolygon{}.AsGeometry(), nil
+}
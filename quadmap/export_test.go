@@ -0,0 +1,85 @@
+package quadmap
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/peterstace/simplefeatures/geom"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func exportQuadMap(t *testing.T) *QuadMap {
+	qm := NewQuadMap(10)
+	for _, c := range []struct {
+		x, y uint32
+		z    byte
+		tt   TileType
+		full bool
+	}{
+		{1, 1, 2, TileTypeVert, true},
+		{2, 2, 3, TileTypeVert, true}, // redundant, 1,1,2 is full
+		{0, 0, 2, TileTypeVert, false},
+		{0, 0, 3, TileTypeVert, false},
+		{1, 0, 3, TileTypeVert, true},
+		{0, 0, 4, TileTypeVert, true}, // deeper than export zoom
+		{3, 3, 3, TileTypeDSM, true},
+	} {
+		_, err := qm.CreateTileAtSlippyCoords(c.x, c.y, c.z, c.tt, c.full)
+		require.NoError(t, err)
+	}
+	return qm
+}
+
+func TestCoverageQuadKeys(t *testing.T) {
+	qm := exportQuadMap(t)
+	keys := qm.CoverageQuadKeys(TileTypeVert, 3)
+	assert.Equal(t, []QuadKey{mustQuadKey(t, 0, 0, 3), mustQuadKey(t, 1, 0, 3), mustQuadKey(t, 1, 1, 2)}, keys)
+	assert.Empty(t, qm.CoverageQuadKeys(TileTypeNorth, 3))
+}
+
+func TestCoverageFeatures(t *testing.T) {
+	qm := exportQuadMap(t)
+	features, err := qm.CoverageFeatures(TileTypeVert, 3)
+	require.NoError(t, err)
+	require.Len(t, features, 3)
+
+	f := features[2]
+	assert.Equal(t, uint32(1), f.Properties["x"])
+	assert.Equal(t, byte(2), f.Properties["z"])
+	assert.Equal(t, true, f.Properties["full"])
+	env, err := mustQuadKey(t, 1, 1, 2).Envelope()
+	require.NoError(t, err)
+	assert.True(t, geom.ExactEquals(env.AsGeometry(), f.Geometry))
+	assert.Equal(t, false, features[0].Properties["full"])
+
+	// round trips through JSON
+	b, err := json.Marshal(features)
+	require.NoError(t, err)
+	var decoded geom.GeoJSONFeatureCollection
+	require.NoError(t, json.Unmarshal(b, &decoded))
+	assert.Len(t, decoded, 3)
+}
+
+func TestCoverageGeometry(t *testing.T) {
+	qm := exportQuadMap(t)
+	g, err := qm.CoverageGeometry(TileTypeVert, 3)
+	require.NoError(t, err)
+	assert.Equal(t, geom.TypeMultiPolygon, g.Type())
+
+	// 0,0,3 and 1,0,3 are adjacent so are dissolved together, 1,1,2 is separate
+	assert.Equal(t, 2, g.MustAsMultiPolygon().NumPolygons())
+
+	var expectedArea float64
+	for _, qk := range qm.CoverageQuadKeys(TileTypeVert, 3) {
+		env, err := qk.Envelope()
+		require.NoError(t, err)
+		expectedArea += env.Area()
+	}
+	assert.InDelta(t, expectedArea, g.Area(), 1e-6)
+
+	empty, err := qm.CoverageGeometry(TileTypeNorth, 3)
+	require.NoError(t, err)
+	assert.Equal(t, geom.TypeMultiPolygon, empty.Type())
+	assert.True(t, empty.IsEmpty())
+}
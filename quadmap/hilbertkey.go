@@ -0,0 +1,145 @@
+package quadmap
+
+import (
+	"errors"
+)
+
+// HilbertKey is a key representing a Slippy tile, ordered along a Hilbert curve rather than the
+// Z-order (Morton) curve used by QuadKey.
+//
+//	|63-----------------------(64-2z)|(63-2z)|(62-2z)-----------0|
+//	| Hilbert index (2 bits per zoom)|   1   |        0          |
+//
+// Same layout as SentinelKey (the zoom is implied by the sentinel bit) so ranges are exact, but
+// tiles that are next to each other on the map are much more likely to be next to each other
+// in the key order. This means a compact area of interest can be searched with fewer, longer
+// ranges.
+// The Hilbert index of a tile's parent is the tile's index with the last 2 bits removed, so
+// every tile's descendants are still within a single range (see HilbertKey.Range).
+type HilbertKey uint64
+
+// HilbertKey converts QuadKey to a HilbertKey
+func (q QuadKey) HilbertKey() HilbertKey {
+	x, y, z := q.SlippyCoords()
+	return hilbertKeyFromSlippy(x, y, z)
+}
+
+// GenerateHilbertKeyFromSlippy generates the HilbertKey from slippy coords
+// If zoom level is < MinZoomLevel or > MaxZoomLevel return error.
+func GenerateHilbertKeyFromSlippy(x uint32, y uint32, zoomLevel byte) (HilbertKey, error) {
+	if zoomLevel < MinZoom || zoomLevel > MaxZoom {
+		return 0, errors.New("invalid zoom level")
+	}
+	n := uint32(1) << zoomLevel
+	if x >= n || y >= n {
+		return 0, errors.New("invalid slippy coords")
+	}
+	return hilbertKeyFromSlippy(x, y, zoomLevel), nil
+}
+
+// QuadKey converts HilbertKey back to a QuadKey
+func (h HilbertKey) QuadKey() QuadKey {
+	x, y, z := h.SlippyCoords()
+	if z == 0 {
+		return 0
+	}
+	qk, _ := GenerateQuadKeyIndexFromSlippy(x, y, z)
+	return qk
+}
+
+// SlippyCoords generates the slippy coords from the HilbertKey
+func (h HilbertKey) SlippyCoords() (uint32, uint32, byte) {
+	z := h.Zoom()
+	d := h.index()
+	var x, y uint64
+	for s := uint64(1); s < uint64(1)<<z; s <<= 1 {
+		rx := 1 & (d / 2)
+		ry := 1 & (d ^ rx)
+		x, y = hilbertRotate(s, x, y, rx, ry)
+		x += s * rx
+		y += s * ry
+		d /= 4
+	}
+	return uint32(x), uint32(y), z
+}
+
+// Zoom returns the zoom level of the HilbertKey
+func (h HilbertKey) Zoom() byte {
+	return SentinelKey(h).Zoom()
+}
+
+// IsValid returns true if h is a valid HilbertKey (eg. not just any value within a
+// HilbertKeyRange)
+func (h HilbertKey) IsValid() bool {
+	return SentinelKey(h).IsValid()
+}
+
+// Parent returns the parent HilbertKey
+func (h HilbertKey) Parent() (HilbertKey, error) {
+	p, err := SentinelKey(h).Parent()
+	return HilbertKey(p), err
+}
+
+// Children returns the 4 children of the HilbertKey, in Hilbert curve order.
+// NOTE: unlike QuadKey.Children, the position of a child in the slice doesn't indicate which
+// quadrant of the parent it is in, that depends on the orientation of the curve within the parent.
+func (h HilbertKey) Children() []HilbertKey {
+	if h.Zoom() >= MaxZoom {
+		return nil
+	}
+	children := make([]HilbertKey, 4)
+	for i := range children {
+		c, _ := SentinelKey(h).ChildAtPos(i)
+		children[i] = HilbertKey(c)
+	}
+	return children
+}
+
+// IsAncestorOf checks whether a HilbertKey is an ancestor of (or equal to) another HilbertKey.
+func (h HilbertKey) IsAncestorOf(desc HilbertKey) bool {
+	return h.Range().Contains(desc)
+}
+
+// index returns the Hilbert index of the tile within its zoom level
+func (h HilbertKey) index() uint64 {
+	z := h.Zoom()
+	if z == 0 {
+		return 0
+	}
+	return uint64(h) >> (64 - 2*uint64(z))
+}
+
+// hilbertKeyFromSlippy converts slippy coords to a HilbertKey. Coords are assumed to be valid.
+// Based off https://en.wikipedia.org/wiki/Hilbert_curve#Applications_and_mapping_algorithms
+func hilbertKeyFromSlippy(x uint32, y uint32, z byte) HilbertKey {
+	if z == 0 {
+		return HilbertKey(lsbForZoom(0))
+	}
+
+	var d uint64
+	px, py := uint64(x), uint64(y)
+	for s := uint64(1) << (z - 1); s > 0; s >>= 1 {
+		var rx, ry uint64
+		if px&s > 0 {
+			rx = 1
+		}
+		if py&s > 0 {
+			ry = 1
+		}
+		d += s * s * ((3 * rx) ^ ry)
+		px, py = hilbertRotate(s, px, py, rx, ry)
+	}
+	return HilbertKey(d<<(64-2*uint64(z)) | lsbForZoom(z))
+}
+
+// hilbertRotate rotates/flips a quadrant so the sub-curve is in the right orientation
+func hilbertRotate(s, x, y, rx, ry uint64) (uint64, uint64) {
+	if ry == 0 {
+		if rx == 1 {
+			x = s - 1 - x
+			y = s - 1 - y
+		}
+		x, y = y, x
+	}
+	return x, y
+}
@@ -0,0 +1,93 @@
+package quadmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHilbertKeyConversion(t *testing.T) {
+	for _, qk := range allQuadKeysToZoom(5) {
+		hk := qk.HilbertKey()
+		assert.True(t, hk.IsValid())
+		assert.Equal(t, qk.Zoom(), hk.Zoom())
+		assert.Equal(t, qk, hk.QuadKey())
+
+		x, y, z := qk.SlippyCoords()
+		hx, hy, hz := hk.SlippyCoords()
+		assert.Equal(t, []any{x, y, z}, []any{hx, hy, hz})
+
+		if z > 0 {
+			fromSlippy, err := GenerateHilbertKeyFromSlippy(x, y, z)
+			require.NoError(t, err)
+			assert.Equal(t, hk, fromSlippy)
+		}
+	}
+}
+
+func TestGenerateHilbertKeyFromSlippyErrors(t *testing.T) {
+	_, err := GenerateHilbertKeyFromSlippy(0, 0, 0)
+	assert.Error(t, err)
+	_, err = GenerateHilbertKeyFromSlippy(0, 0, MaxZoom+1)
+	assert.Error(t, err)
+	_, err = GenerateHilbertKeyFromSlippy(4, 0, 2)
+	assert.Error(t, err)
+}
+
+func TestHilbertKeyKnownOrder(t *testing.T) {
+	// zoom 1 curve visits (0,0), (0,1), (1,1), (1,0)
+	var keys []HilbertKey
+	for _, c := range [][2]uint32{{0, 0}, {0, 1}, {1, 1}, {1, 0}} {
+		hk, err := GenerateHilbertKeyFromSlippy(c[0], c[1], 1)
+		require.NoError(t, err)
+		keys = append(keys, hk)
+	}
+	assert.Equal(t, []HilbertKey{0x2000000000000000, 0x6000000000000000, 0xa000000000000000, 0xe000000000000000}, keys)
+}
+
+func TestHilbertKeyAdjacency(t *testing.T) {
+	// consecutive tiles along the curve are always neighbours on the map
+	z := byte(6)
+	n := uint64(1) << (2 * z)
+	for d := uint64(0); d < n-1; d++ {
+		a := HilbertKey(d<<(64-2*z) | lsbForZoom(z))
+		b := HilbertKey((d+1)<<(64-2*z) | lsbForZoom(z))
+		ax, ay, _ := a.SlippyCoords()
+		bx, by, _ := b.SlippyCoords()
+		dist := absDiff(ax, bx) + absDiff(ay, by)
+		assert.Equal(t, uint32(1), dist, "index %d", d)
+	}
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+func TestHilbertKeyHierarchy(t *testing.T) {
+	keys := allQuadKeysToZoom(4)
+	for _, qk := range keys {
+		hk := qk.HilbertKey()
+
+		if qk.Zoom() > 0 {
+			qkParent, err := qk.Parent()
+			require.NoError(t, err)
+			hkParent, err := hk.Parent()
+			require.NoError(t, err)
+			assert.Equal(t, qkParent.HilbertKey(), hkParent)
+		}
+
+		var children []QuadKey
+		for _, c := range hk.Children() {
+			children = append(children, c.QuadKey())
+		}
+		assert.ElementsMatch(t, qk.Children(), children)
+
+		for _, d := range keys {
+			assert.Equal(t, qk.IsAncestorOf(d), hk.IsAncestorOf(d.HilbertKey()))
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package quadmap
+
+import (
+	"slices"
+	"sort"
+)
+
+// quadKeyIndex is a sorted secondary index of the quadkeys in a QuadMap, used for range queries.
+// Keys are kept sorted as tiles are added/removed, in blocks of up to 2*indexBlockSize keys so
+// an insert or delete only has to shift a single block rather than every key.
+// Only modified while holding the QuadMap write lock, and only read while holding (at least)
+// the QuadMap read lock.
+type quadKeyIndex struct {
+	blocks [][]QuadKey
+}
+
+// indexBlockSize is the number of keys in each block of a quadKeyIndex, blocks are split when
+// they reach double this.
+const indexBlockSize = 256
+
+// blockFor returns the index of the block that qk belongs in, ie the last block whose first
+// key is <= qk
+func (idx *quadKeyIndex) blockFor(qk QuadKey) int {
+	i := sort.Search(len(idx.blocks), func(i int) bool {
+		return idx.blocks[i][0] > qk
+	})
+	return max(i-1, 0)
+}
+
+// insert adds qk to the index
+func (idx *quadKeyIndex) insert(qk QuadKey) {
+	if len(idx.blocks) == 0 {
+		idx.blocks = [][]QuadKey{{qk}}
+		return
+	}
+
+	bi := idx.blockFor(qk)
+	block := idx.blocks[bi]
+	pos, found := slices.BinarySearch(block, qk)
+	if found {
+		return
+	}
+	block = slices.Insert(block, pos, qk)
+	if len(block) >= 2*indexBlockSize {
+		right := slices.Clone(block[indexBlockSize:])
+		block = block[:indexBlockSize]
+		idx.blocks = slices.Insert(idx.blocks, bi+1, right)
+	}
+	idx.blocks[bi] = block
+}
+
+// remove removes qk from the index
+func (idx *quadKeyIndex) remove(qk QuadKey) {
+	if len(idx.blocks) == 0 {
+		return
+	}
+
+	bi := idx.blockFor(qk)
+	block := idx.blocks[bi]
+	pos, found := slices.BinarySearch(block, qk)
+	if !found {
+		return
+	}
+	block = slices.Delete(block, pos, pos+1)
+	if len(block) == 0 {
+		idx.blocks = slices.Delete(idx.blocks, bi, bi+1)
+		return
+	}
+	idx.blocks[bi] = block
+}
+
+// ascendRange calls f for each key in the index between start and end (inclusive), in order,
+// until f returns false.
+func (idx *quadKeyIndex) ascendRange(start uint64, end uint64, f func(qk QuadKey) bool) {
+	bi := sort.Search(len(idx.blocks), func(i int) bool {
+		block := idx.blocks[i]
+		return uint64(block[len(block)-1]) >= start
+	})
+	for ; bi < len(idx.blocks); bi++ {
+		block := idx.blocks[bi]
+		i := 0
+		if uint64(block[0]) < start {
+			i = sort.Search(len(block), func(i int) bool {
+				return uint64(block[i]) >= start
+			})
+		}
+		for ; i < len(block); i++ {
+			if uint64(block[i]) > end || !f(block[i]) {
+				return
+			}
+		}
+	}
+}
+
+// keys returns a copy of all keys in the index, in order
+func (idx *quadKeyIndex) keys() []QuadKey {
+	var keys []QuadKey
+	for _, block := range idx.blocks {
+		keys = append(keys, block...)
+	}
+	return keys
+}
+
+// TilesInRanges returns all tiles with tileType whose quadkeys fall within ranges (eg. from
+// covering.SearchRanges). Tiles are returned in quadkey order, without duplicates.
+// A range made with QuadKey.Range also contains some lower zoom keys in its 0,0 corner that
+// aren't descendants of the tile it was made from (see QuadKey.Range), these are filtered out
+// so only tiles that are a descendant of (or equal to) a tile in a range, or are a key of a
+// SingleRange, are returned.
+// Evicted tiles in the ranges are reloaded first (see EvictionPolicy).
+func (qm *QuadMap) TilesInRanges(ranges []QuadKeyRange, tileType TileType) ([]*Tile, error) {
+	if err := qm.reloadEvictedTilesInRanges(ranges); err != nil {
+		return nil, err
+	}
+
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+
+	seen := make(map[QuadKey]bool)
+	tiles := []*Tile{}
+	for _, r := range ranges {
+		qm.index.ascendRange(r.Start, r.End, func(qk QuadKey) bool {
+			if seen[qk] || !rangeMatches(r, qk) {
+				return true
+			}
+			t := qm.quadKeyMap[qk]
+			if !t.HasTileType(tileType) {
+				return true
+			}
+			seen[qk] = true
+			tiles = append(tiles, t)
+			return true
+		})
+	}
+
+	sort.Slice(tiles, func(i, j int) bool {
+		return tiles[i].QuadKey < tiles[j].QuadKey
+	})
+	return tiles, nil
+}
+
+// rangeMatches returns true if qk (which is within r) isn't one of the false positives of r.
+// That is, the whole range of qk (itself and its descendants) is within r, so whichever tile
+// r was made from IsAncestorOf qk. Or r is a range of single keys (eg. SingleRange of an
+// ancestor, possibly merged with the ancestors at the next zoom levels which have the same
+// path) and qk is one of them.
+func rangeMatches(r QuadKeyRange, qk QuadKey) bool {
+	qkRange := qk.Range()
+	if qkRange.Start >= r.Start && qkRange.End <= r.End {
+		return true
+	}
+	return r.Start&^zoomMask == r.End&^zoomMask && uint64(qk)&^zoomMask == r.Start&^zoomMask
+}
@@ -0,0 +1,201 @@
+package quadmap
+
+import (
+	"math/rand"
+	"slices"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tileKeys(tiles []*Tile) []QuadKey {
+	keys := []QuadKey{}
+	for _, t := range tiles {
+		keys = append(keys, t.QuadKey)
+	}
+	return keys
+}
+
+func TestTilesInRanges(t *testing.T) {
+	qm := NewQuadMap(10)
+
+	// 4,4,4 with children, plus 2,2,3 which is in 4,4,4's range since it's the ancestor in the
+	// 0,0 corner (see QuadKey.Range), but isn't returned for it
+	for _, c := range []struct {
+		x, y uint32
+		z    byte
+		tt   TileType
+	}{
+		{2, 2, 3, TileTypeVert},
+		{4, 4, 4, TileTypeVert},
+		{8, 8, 5, TileTypeVert},
+		{9, 9, 5, TileTypeVert},
+		{9, 8, 5, TileTypeDSM},
+		{5, 5, 4, TileTypeVert},
+		{0, 0, 1, TileTypeVert},
+	} {
+		_, err := qm.CreateTileAtSlippyCoords(c.x, c.y, c.z, c.tt, false)
+		require.NoError(t, err)
+	}
+
+	for _, tc := range []struct {
+		name     string
+		ranges   []QuadKeyRange
+		tileType TileType
+		expect   []QuadKey
+	}{
+		{
+			name:     "tile and descendants, not the ancestor in the range",
+			ranges:   []QuadKeyRange{mustQuadKey(t, 4, 4, 4).Range()},
+			tileType: TileTypeVert,
+			expect:   []QuadKey{mustQuadKey(t, 4, 4, 4), mustQuadKey(t, 8, 8, 5), mustQuadKey(t, 9, 9, 5)},
+		},
+		{
+			// the range of 4,4,4 through 5,5,4 is the whole range of 2,2,3
+			name:     "range of several tiles",
+			ranges:   []QuadKeyRange{{Start: mustQuadKey(t, 4, 4, 4).Range().Start, End: mustQuadKey(t, 5, 5, 4).Range().End}},
+			tileType: TileTypeVert,
+			expect: []QuadKey{
+				mustQuadKey(t, 2, 2, 3), mustQuadKey(t, 4, 4, 4), mustQuadKey(t, 8, 8, 5), mustQuadKey(t, 9, 9, 5),
+				mustQuadKey(t, 5, 5, 4),
+			},
+		},
+		{
+			name:     "tile type",
+			ranges:   []QuadKeyRange{mustQuadKey(t, 4, 4, 4).Range()},
+			tileType: TileTypeDSM,
+			expect:   []QuadKey{mustQuadKey(t, 9, 8, 5)},
+		},
+		{
+			name: "single ranges",
+			ranges: []QuadKeyRange{
+				mustQuadKey(t, 2, 2, 3).SingleRange(),
+				mustQuadKey(t, 0, 0, 1).SingleRange(),
+			},
+			tileType: TileTypeVert,
+			expect:   []QuadKey{mustQuadKey(t, 0, 0, 1), mustQuadKey(t, 2, 2, 3)},
+		},
+		{
+			name: "overlapping ranges",
+			ranges: []QuadKeyRange{
+				mustQuadKey(t, 0, 0, 1).Range(),
+				mustQuadKey(t, 4, 4, 4).Range(),
+			},
+			tileType: TileTypeVert,
+			expect: []QuadKey{
+				mustQuadKey(t, 0, 0, 1), mustQuadKey(t, 2, 2, 3), mustQuadKey(t, 4, 4, 4), mustQuadKey(t, 8, 8, 5),
+				mustQuadKey(t, 9, 9, 5), mustQuadKey(t, 5, 5, 4),
+			},
+		},
+		{
+			// ancestors of 4,4,4 at consecutive zooms merged into one range, as
+			// covering.SearchRanges does
+			name: "merged ancestors",
+			ranges: []QuadKeyRange{
+				mustQuadKey(t, 4, 4, 4).Range(),
+				{Start: uint64(mustQuadKey(t, 1, 1, 2)), End: uint64(mustQuadKey(t, 2, 2, 3))},
+			},
+			tileType: TileTypeVert,
+			expect:   []QuadKey{mustQuadKey(t, 2, 2, 3), mustQuadKey(t, 4, 4, 4), mustQuadKey(t, 8, 8, 5), mustQuadKey(t, 9, 9, 5)},
+		},
+		{
+			name:     "no ranges",
+			tileType: TileTypeVert,
+			expect:   []QuadKey{},
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tiles, err := qm.TilesInRanges(tc.ranges, tc.tileType)
+			require.NoError(t, err)
+			assert.ElementsMatch(t, tc.expect, tileKeys(tiles))
+		})
+	}
+}
+
+// TestTilesInRangesMatchesIsAncestorOf checks the tiles in a range are exactly the descendants
+func TestTilesInRangesMatchesIsAncestorOf(t *testing.T) {
+	qm := NewQuadMap(1000)
+	for _, qk := range allQuadKeysToZoom(4) {
+		if qk == 0 {
+			continue
+		}
+		require.NoError(t, qm.AddTile(&Tile{QuadKey: qk, Details: uint64(TileTypeVert) << TileTypeOffset}))
+	}
+
+	for _, q := range allQuadKeysToZoom(3) {
+		tiles, err := qm.TilesInRanges([]QuadKeyRange{q.Range()}, TileTypeVert)
+		require.NoError(t, err)
+
+		expect := []QuadKey{}
+		for _, qk := range allQuadKeysToZoom(4) {
+			if qk != 0 && q.IsAncestorOf(qk) {
+				expect = append(expect, qk)
+			}
+		}
+		assert.ElementsMatch(t, expect, tileKeys(tiles), "quadkey %x", q)
+	}
+}
+
+func TestTilesInRangesIndexUpdated(t *testing.T) {
+	qm := NewQuadMap(10)
+	r := []QuadKeyRange{mustQuadKey(t, 1, 1, 1).Range()}
+
+	_, err := qm.CreateTileAtSlippyCoords(2, 2, 2, TileTypeVert, false)
+	require.NoError(t, err)
+	tiles, err := qm.TilesInRanges(r, TileTypeVert)
+	require.NoError(t, err)
+	assert.Len(t, tiles, 1)
+
+	_, err = qm.CreateTileAtSlippyCoords(3, 3, 2, TileTypeVert, false)
+	require.NoError(t, err)
+	tiles, err = qm.TilesInRanges(r, TileTypeVert)
+	require.NoError(t, err)
+	assert.Len(t, tiles, 2)
+
+	qm.SetEvictionPolicy(EvictionPolicy{MaxTiles: 1})
+	tiles, err = qm.TilesInRanges(r, TileTypeVert)
+	require.NoError(t, err)
+	assert.Len(t, tiles, 1)
+}
+
+// TestQuadKeyIndex checks the index stays sorted, including across block splits and removals
+func TestQuadKeyIndex(t *testing.T) {
+	var idx quadKeyIndex
+	expect := make(map[QuadKey]bool)
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 5000; i++ {
+		qk := mustQuadKey(t, uint32(rnd.Intn(64)), uint32(rnd.Intn(64)), 6)
+		if rnd.Intn(3) == 0 {
+			idx.remove(qk)
+			delete(expect, qk)
+			continue
+		}
+		idx.insert(qk)
+		expect[qk] = true
+	}
+
+	keys := idx.keys()
+	assert.True(t, slices.IsSorted(keys))
+	assert.Len(t, keys, len(expect))
+	for _, qk := range keys {
+		assert.True(t, expect[qk])
+	}
+
+	for _, q := range allQuadKeysToZoom(2) {
+		r := q.Range()
+		var inRange []QuadKey
+		idx.ascendRange(r.Start, r.End, func(qk QuadKey) bool {
+			inRange = append(inRange, qk)
+			return true
+		})
+
+		var expectInRange []QuadKey
+		for _, qk := range keys {
+			if r.Contains(qk) {
+				expectInRange = append(expectInRange, qk)
+			}
+		}
+		assert.Equal(t, expectInRange, inRange)
+	}
+}
@@ -0,0 +1,131 @@
+package quadmap
+
+import (
+	"encoding/binary"
+	"os"
+	"sort"
+
+	"golang.org/x/exp/mmap"
+)
+
+// MappedQuadMap is a read-only quadmap backed by a memory mapped file in the
+// binary format written by QuadMap.WriteFile (see encoding.go). Tiles in the file are
+// sorted by QuadKey so lookups are a binary search over the mapped file, meaning
+// there is no load time and memory usage is left to the OS page cache.
+// Tile metadata (see TileMetadata) is not read.
+type MappedQuadMap struct {
+	reader *mmap.ReaderAt
+	header *encodingHeader
+}
+
+// WriteFile writes the quadmap to filename in the format read by OpenMappedQuadMap
+func (qm *QuadMap) WriteFile(filename string) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	if err := qm.WriteBinary(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// OpenMappedQuadMap memory maps filename and reads the header.
+// The checksum is NOT verified since that requires reading the entire file, call Verify
+// if that is required.
+func OpenMappedQuadMap(filename string) (*MappedQuadMap, error) {
+	reader, err := mmap.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	header, err := decodeHeader(reader, int64(reader.Len()))
+	if err != nil {
+		reader.Close()
+		return nil, err
+	}
+
+	return &MappedQuadMap{reader: reader, header: header}, nil
+}
+
+// Close unmaps the underlying file. The MappedQuadMap cannot be used afterwards.
+func (m *MappedQuadMap) Close() error {
+	return m.reader.Close()
+}
+
+// Verify checks the checksum of the entire mapped file
+func (m *MappedQuadMap) Verify() error {
+	return verifyChecksum(m.reader, int64(m.reader.Len()))
+}
+
+// NumberOfTiles returns number of tiles in the mapped quadmap
+func (m *MappedQuadMap) NumberOfTiles() int {
+	return int(m.header.numTiles)
+}
+
+// GetExactTileForSlippy returns tile for slippy co-ord match. Does NOT traverse up the ancestry
+func (m *MappedQuadMap) GetExactTileForSlippy(x uint32, y uint32, z byte) (*Tile, error) {
+	quadKey, err := GenerateQuadKeyIndexFromSlippy(x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	return m.GetExactTileForQuadKey(quadKey)
+}
+
+// GetExactTileForQuadKey returns tile for quadkey match. Does NOT traverse up the ancestry
+// The returned tile is a copy, modifying it has no effect on the mapped quadmap.
+func (m *MappedQuadMap) GetExactTileForQuadKey(quadKey QuadKey) (*Tile, error) {
+	return m.lookupTile(quadKey)
+}
+
+// GetAllChildrenForQuadKeyAndZoom returns all quadkeys for a given zoom level including situations where a parent
+// is marked as full.
+func (m *MappedQuadMap) GetAllChildrenForQuadKeyAndZoom(qk QuadKey, tileType TileType, zoom byte) ([]QuadKey, error) {
+	return getAllChildrenForQuadKeyAndZoom(m.lookupTile, qk, tileType, zoom)
+}
+
+// IsTileCoveredForSlippyCoordsAndTileTypeTopDown takes slippy coord, gets all ancestors to see if tile should exist
+// (by checking ancestors + full flag)
+// Also returns the quadkey that covers the co-ord... whether its the actual QK for the co-ordinates
+// or an ancestor that is full
+func (m *MappedQuadMap) IsTileCoveredForSlippyCoordsAndTileTypeTopDown(x uint32, y uint32, z byte, tileType TileType) (bool, QuadKey, error) {
+	return isTileCoveredForSlippyCoordsAndTileTypeTopDown(m.lookupTile, x, y, z, tileType)
+}
+
+// lookupTile binary searches the mapped tiles for quadKey
+func (m *MappedQuadMap) lookupTile(quadKey QuadKey) (*Tile, error) {
+	n := int(m.header.numTiles)
+	i := sort.Search(n, func(i int) bool {
+		return m.quadKeyAt(i) >= quadKey
+	})
+	if i >= n || m.quadKeyAt(i) != quadKey {
+		return nil, TileNotFoundError
+	}
+
+	var b [8]byte
+	if _, err := m.reader.ReadAt(b[:], m.offsetOf(i)+8); err != nil {
+		return nil, err
+	}
+	details, err := m.header.remapDetails(binary.LittleEndian.Uint64(b[:]))
+	if err != nil {
+		return nil, err
+	}
+	return &Tile{QuadKey: quadKey, Details: details}, nil
+}
+
+// quadKeyAt returns the QuadKey of the i'th tile in the file
+func (m *MappedQuadMap) quadKeyAt(i int) QuadKey {
+	var b [8]byte
+
+	// Header validation guarantees all tiles are within the mapped file so
+	// ReadAt cannot fail here.
+	m.reader.ReadAt(b[:], m.offsetOf(i))
+	return QuadKey(binary.LittleEndian.Uint64(b[:]))
+}
+
+// offsetOf returns the offset of the i'th tile in the file
+func (m *MappedQuadMap) offsetOf(i int) int64 {
+	return int64(m.header.size) + int64(i)*encodedTileSize
+}
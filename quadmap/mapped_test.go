@@ -0,0 +1,63 @@
+package quadmap
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestMappedQuadMapMatchesQuadMap confirms lookups against the mapped quadmap match the source quadmap
+func TestMappedQuadMapMatchesQuadMap(t *testing.T) {
+	qm := populatedQuadMap(t)
+	_, err := qm.CreateTileAtSlippyCoords(2, 2, 2, TileTypeDSM, false)
+	assert.NoError(t, err, "Should not have error when adding tile")
+	_, err = qm.CreateTileAtSlippyCoords(4, 5, 3, TileTypeDSM, true)
+	assert.NoError(t, err, "Should not have error when adding tile")
+
+	filename := filepath.Join(t.TempDir(), "quadmap.bin")
+	err = qm.WriteFile(filename)
+	assert.NoError(t, err, "Should not have error writing file")
+
+	m, err := OpenMappedQuadMap(filename)
+	assert.NoError(t, err, "Should not have error opening mapped file")
+	defer m.Close()
+
+	assert.NoError(t, m.Verify(), "Checksum should be valid")
+	assert.Equal(t, qm.NumberOfTiles(), m.NumberOfTiles(), "Number of tiles should match")
+
+	tiles, _ := qm.GetAllTiles(false)
+	for _, tile := range tiles {
+		mappedTile, err := m.GetExactTileForQuadKey(tile.QuadKey)
+		assert.NoError(t, err, "Should find tile")
+		assert.Equal(t, *tile, *mappedTile, "Tile should match")
+	}
+
+	_, err = m.GetExactTileForSlippy(0, 0, 10)
+	assert.ErrorIs(t, err, TileNotFoundError)
+
+	for _, tc := range []struct {
+		x, y uint32
+		z    byte
+	}{
+		{x: 36, y: 40, z: 6},
+		{x: 1, y: 1, z: 1},
+		{x: 5, y: 5, z: 5},
+		{x: 0, y: 0, z: 5},
+	} {
+		covered, coveredKey, err := qm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(tc.x, tc.y, tc.z, TileTypeDSM)
+		assert.NoError(t, err)
+		mappedCovered, mappedCoveredKey, err := m.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(tc.x, tc.y, tc.z, TileTypeDSM)
+		assert.NoError(t, err)
+		assert.Equal(t, covered, mappedCovered, "Coverage should match")
+		assert.Equal(t, coveredKey, mappedCoveredKey, "Covering quadkey should match")
+	}
+
+	root, _ := GenerateQuadKeyIndexFromSlippy(1, 1, 1)
+	expected, err := qm.GetAllChildrenForQuadKeyAndZoom(root, TileTypeDSM, 4)
+	assert.NoError(t, err)
+	actual, err := m.GetAllChildrenForQuadKeyAndZoom(root, TileTypeDSM, 4)
+	assert.NoError(t, err)
+	assert.NotEmpty(t, actual)
+	assert.ElementsMatch(t, expected, actual, "Children should match")
+}
@@ -0,0 +1,210 @@
+package quadmap
+
+import (
+	"errors"
+	"slices"
+	"time"
+)
+
+// TileMetadata is an optional payload attached to a tile for a given TileType, so common
+// questions (eg. "newest TrueOrtho covering this point") can be answered without going back
+// to SQLite. A tile may have multiple TileMetadata for the same TileType (eg. multiple surveys).
+type TileMetadata struct {
+	TileType TileType
+
+	// CaptureDate is when the imagery (or whatever the TileType represents) was captured
+	CaptureDate time.Time
+
+	// RetiredDate is when the data was superseded or withdrawn. Zero if still current.
+	// See IsCurrentAt
+	RetiredDate time.Time
+
+	// Scale is the resolution/scale of the data
+	Scale uint16
+
+	// DetailsIDs are the ids of the rows in the SQLite details table for this tile
+	DetailsIDs []int64
+}
+
+// Equal returns true if md and other have the same values
+func (md TileMetadata) Equal(other TileMetadata) bool {
+	return md.TileType == other.TileType &&
+		md.CaptureDate.Equal(other.CaptureDate) &&
+		md.RetiredDate.Equal(other.RetiredDate) &&
+		md.Scale == other.Scale &&
+		slices.Equal(md.DetailsIDs, other.DetailsIDs)
+}
+
+// addMetadata returns metadata with md added, unless it's a duplicate of metadata already there.
+// A new slice is always allocated, see QuadMap.metadata
+func addMetadata(metadata []TileMetadata, md TileMetadata) []TileMetadata {
+	for _, existing := range metadata {
+		if existing.Equal(md) {
+			return metadata
+		}
+	}
+	return append(slices.Clip(metadata), md)
+}
+
+// metadataForTileType returns the entries of metadata for tileType
+func metadataForTileType(metadata []TileMetadata, tileType TileType) []TileMetadata {
+	var filtered []TileMetadata
+	for _, md := range metadata {
+		if md.TileType == tileType {
+			filtered = append(filtered, md)
+		}
+	}
+	return filtered
+}
+
+// hasMetadataForTileTypeLocked returns true if the tile for quadKey has any metadata for tileType.
+// Caller must hold (at least) the read lock.
+func (qm *QuadMap) hasMetadataForTileTypeLocked(quadKey QuadKey, tileType TileType) bool {
+	for _, md := range qm.metadata[quadKey] {
+		if md.TileType == tileType {
+			return true
+		}
+	}
+	return false
+}
+
+// addMetadataLocked adds metadata to the tile for quadKey, ignoring duplicates.
+// Caller must hold the write lock.
+func (qm *QuadMap) addMetadataLocked(quadKey QuadKey, metadata []TileMetadata) {
+	if len(metadata) == 0 {
+		return
+	}
+	existing := qm.metadata[quadKey]
+	for _, md := range metadata {
+		existing = addMetadata(existing, md)
+	}
+	qm.setMetadataLocked(quadKey, existing)
+}
+
+// removeMetadataForTileTypeLocked removes all metadata for tileType from the tile for quadKey.
+// Caller must hold the write lock.
+func (qm *QuadMap) removeMetadataForTileTypeLocked(quadKey QuadKey, tileType TileType) {
+	if !qm.hasMetadataForTileTypeLocked(quadKey, tileType) {
+		return
+	}
+
+	// new slice rather than deleting in place, see QuadMap.metadata
+	var metadata []TileMetadata
+	for _, md := range qm.metadata[quadKey] {
+		if md.TileType != tileType {
+			metadata = append(metadata, md)
+		}
+	}
+	qm.setMetadataLocked(quadKey, metadata)
+}
+
+// setMetadataLocked replaces the metadata for quadKey (removing it if metadata is empty), keeping
+// track of the memory used by metadata for the eviction policy.
+// Caller must hold the write lock.
+func (qm *QuadMap) setMetadataLocked(quadKey QuadKey, metadata []TileMetadata) {
+	if qm.eviction != nil {
+		qm.eviction.metadataBytes += metadataSize(metadata) - metadataSize(qm.metadata[quadKey])
+	}
+	if len(metadata) == 0 {
+		delete(qm.metadata, quadKey)
+		return
+	}
+	if qm.metadata == nil {
+		qm.metadata = make(map[QuadKey][]TileMetadata)
+	}
+	qm.metadata[quadKey] = metadata
+}
+
+// AddTileMetadata adds md to the tile for quadKey.
+// Returns TileWithTileTypeNotFound if the tile doesn't exist or doesn't have md.TileType.
+func (qm *QuadMap) AddTileMetadata(quadKey QuadKey, md TileMetadata) error {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	t, ok := qm.quadKeyMap[quadKey]
+	if !ok || !t.HasTileType(md.TileType) {
+		return TileWithTileTypeNotFound
+	}
+	qm.addMetadataLocked(quadKey, []TileMetadata{md})
+	if qm.eviction != nil {
+		// the metadata counts towards the memory budget, but shouldn't evict its own tile first
+		qm.eviction.touch(t)
+		qm.enforceEvictionPolicyLocked()
+	}
+	return nil
+}
+
+// GetTileMetadata returns the metadata for tileType on the tile for quadKey.
+func (qm *QuadMap) GetTileMetadata(quadKey QuadKey, tileType TileType) ([]TileMetadata, error) {
+	t, err := qm.lookupTile(quadKey)
+	if err != nil {
+		return nil, err
+	}
+	return qm.tileMetadata(t, tileType), nil
+}
+
+// tileMetadata returns the metadata for tileType on t, which must be a tile in the quadmap
+func (qm *QuadMap) tileMetadata(t *Tile, tileType TileType) []TileMetadata {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+	return metadataForTileType(qm.metadata[t.QuadKey], tileType)
+}
+
+// NewestMetadataForSlippy returns the newest metadata for tileType from the tiles that cover the
+// slippy coords, ie the tile at x,y,z itself and any full ancestors (same rules as
+// IsTileCoveredForSlippyCoordsAndTileTypeTopDown). Also returns the quadkey of the tile the
+// metadata came from.
+// Returns TileWithTileTypeNotFound if no covering tile has metadata for tileType.
+func (qm *QuadMap) NewestMetadataForSlippy(x uint32, y uint32, z byte, tileType TileType) (TileMetadata, QuadKey, error) {
+	return newestMetadataForSlippy(qm.lookupTile, qm.tileMetadata, x, y, z, tileType)
+}
+
+func newestMetadataForSlippy(lookup tileLookup, metadata tileMetadataFunc, x uint32, y uint32, z byte, tileType TileType) (TileMetadata, QuadKey, error) {
+	quadKey, err := GenerateQuadKeyIndexFromSlippy(x, y, z)
+	if err != nil {
+		return TileMetadata{}, 0, err
+	}
+	return newestCoveringMetadata(lookup, metadata, quadKey, tileType, nil)
+}
+
+// tileMetadataFunc returns the metadata for tileType on a tile, see QuadMap.tileMetadata
+type tileMetadataFunc func(t *Tile, tileType TileType) []TileMetadata
+
+// newestCoveringMetadata returns the newest metadata for tileType (that matches filter, if not
+// nil) from quadKey and its full ancestors.
+func newestCoveringMetadata(lookup tileLookup, metadata tileMetadataFunc, quadKey QuadKey, tileType TileType, filter func(md TileMetadata) bool) (TileMetadata, QuadKey, error) {
+	var newest TileMetadata
+	var newestKey QuadKey
+	found := false
+	for qk := quadKey; ; {
+		t, err := lookup(qk)
+		if err != nil && !errors.Is(err, TileNotFoundError) {
+			return TileMetadata{}, 0, err
+		}
+		if err == nil {
+			hasTileType, isFull := t.HasTileTypeAndFull(tileType)
+			if hasTileType && (isFull || qk == quadKey) {
+				for _, md := range metadata(t, tileType) {
+					if filter != nil && !filter(md) {
+						continue
+					}
+					if !found || md.CaptureDate.After(newest.CaptureDate) {
+						newest = md
+						newestKey = qk
+						found = true
+					}
+				}
+			}
+		}
+
+		qk, err = qk.Parent()
+		if err != nil {
+			break
+		}
+	}
+
+	if !found {
+		return TileMetadata{}, 0, TileWithTileTypeNotFound
+	}
+	return newest, newestKey, nil
+}
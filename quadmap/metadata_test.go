@@ -0,0 +1,113 @@
+package quadmap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTileMetadata(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(3, 3, 3, TileTypeTrueOrtho, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(3, 3, 3, TileTypeDSM, true)
+	require.NoError(t, err)
+	qk := mustQuadKey(t, 3, 3, 3)
+
+	older := TileMetadata{TileType: TileTypeTrueOrtho, CaptureDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC), Scale: 7, DetailsIDs: []int64{1}}
+	newer := TileMetadata{TileType: TileTypeTrueOrtho, CaptureDate: time.Date(2023, 6, 1, 0, 0, 0, 0, time.UTC), Scale: 5, DetailsIDs: []int64{2, 3}}
+	dsm := TileMetadata{TileType: TileTypeDSM, CaptureDate: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+
+	require.NoError(t, qm.AddTileMetadata(qk, newer))
+	require.NoError(t, qm.AddTileMetadata(qk, older))
+	require.NoError(t, qm.AddTileMetadata(qk, older))
+	require.NoError(t, qm.AddTileMetadata(qk, dsm))
+	md, err := qm.GetTileMetadata(qk, TileTypeTrueOrtho)
+	require.NoError(t, err)
+	assert.Equal(t, []TileMetadata{newer, older}, md, "Duplicate metadata should be ignored")
+
+	md, err = qm.GetTileMetadata(qk, TileTypeVert)
+	require.NoError(t, err)
+	assert.Empty(t, md)
+
+	require.NoError(t, qm.RemoveTileTypeForSubtree(qk, TileTypeTrueOrtho))
+	md, err = qm.GetTileMetadata(qk, TileTypeTrueOrtho)
+	require.NoError(t, err)
+	assert.Empty(t, md, "Removing tiletype should remove its metadata")
+	md, err = qm.GetTileMetadata(qk, TileTypeDSM)
+	require.NoError(t, err)
+	assert.Equal(t, []TileMetadata{dsm}, md)
+
+	require.NoError(t, qm.RemoveTile(qk))
+	assert.Empty(t, qm.metadata, "Removing the tile should remove its metadata")
+}
+
+func TestNewestMetadataForSlippy(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 2, TileTypeTrueOrtho, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(4, 4, 4, TileTypeTrueOrtho, false)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(2, 2, 3, TileTypeTrueOrtho, false)
+	require.NoError(t, err)
+
+	ancestorMD := TileMetadata{TileType: TileTypeTrueOrtho, CaptureDate: time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC), DetailsIDs: []int64{10}}
+	tileMD := TileMetadata{TileType: TileTypeTrueOrtho, CaptureDate: time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC), DetailsIDs: []int64{11}}
+	partialMD := TileMetadata{TileType: TileTypeTrueOrtho, CaptureDate: time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), DetailsIDs: []int64{12}}
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 2), ancestorMD))
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 4, 4, 4), tileMD))
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 2, 2, 3), partialMD))
+
+	assert.ErrorIs(t, qm.AddTileMetadata(mustQuadKey(t, 4, 4, 4), TileMetadata{TileType: TileTypeDSM}), TileWithTileTypeNotFound)
+	assert.ErrorIs(t, qm.AddTileMetadata(mustQuadKey(t, 0, 0, 4), ancestorMD), TileWithTileTypeNotFound)
+
+	// the tile itself is newer than the full ancestor. 2,2,3 is newer again but isn't full so
+	// doesn't cover 4,4,4
+	md, qk, err := qm.NewestMetadataForSlippy(4, 4, 4, TileTypeTrueOrtho)
+	assert.NoError(t, err)
+	assert.Equal(t, tileMD, md)
+	assert.Equal(t, mustQuadKey(t, 4, 4, 4), qk)
+
+	// only covered by the full ancestor
+	md, qk, err = qm.NewestMetadataForSlippy(5, 5, 4, TileTypeTrueOrtho)
+	assert.NoError(t, err)
+	assert.Equal(t, ancestorMD, md)
+	assert.Equal(t, mustQuadKey(t, 1, 1, 2), qk)
+
+	_, _, err = qm.NewestMetadataForSlippy(0, 0, 4, TileTypeTrueOrtho)
+	assert.ErrorIs(t, err, TileWithTileTypeNotFound)
+}
+
+func TestCompactKeepsMetadata(t *testing.T) {
+	qm := NewQuadMap(10)
+	for _, c := range [][2]uint32{{2, 2}, {3, 2}, {2, 3}, {3, 3}} {
+		_, err := qm.CreateTileAtSlippyCoords(c[0], c[1], 3, TileTypeVert, true)
+		require.NoError(t, err)
+	}
+	md := TileMetadata{TileType: TileTypeVert, Scale: 3}
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 3, 3, 3), md))
+
+	assert.Equal(t, 0, qm.Compact(TileTypeVert), "Siblings with metadata should not be collapsed")
+
+	found, err := qm.GetTileMetadata(mustQuadKey(t, 3, 3, 3), TileTypeVert)
+	require.NoError(t, err)
+	assert.Equal(t, []TileMetadata{md}, found)
+}
+
+func TestRemoveTileTypeForSubtreeCopiesMetadata(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 3, TileTypeVert, true)
+	require.NoError(t, err)
+	md := TileMetadata{TileType: TileTypeVert, DetailsIDs: []int64{42}}
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 3), md))
+
+	require.NoError(t, qm.RemoveTileTypeForSubtree(mustQuadKey(t, 2, 2, 4), TileTypeVert))
+
+	found, _, err := qm.NewestMetadataForSlippy(3, 3, 4, TileTypeVert)
+	assert.NoError(t, err)
+	assert.Equal(t, md, found, "Remaining siblings should still have the metadata")
+	_, _, err = qm.NewestMetadataForSlippy(2, 2, 4, TileTypeVert)
+	assert.ErrorIs(t, err, TileWithTileTypeNotFound)
+}
@@ -89,6 +89,37 @@ func (q QuadKey) Children() []QuadKey {
 	return children
 }
 
+// neighborOffsets are the x,y offsets of the 8 neighbors of a tile, clockwise starting at north
+var neighborOffsets = [8][2]int64{{0, -1}, {1, -1}, {1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}}
+
+// Neighbors returns the (up to 8) quadkeys at the same zoom that share an edge or corner with q,
+// clockwise starting at north.
+// x wraps around the antimeridian, but there are no neighbors past the poles so tiles on the
+// top/bottom rows only have 5 neighbors. At low zooms the wrapping means a tile can be a
+// neighbor on both sides, it is only returned once.
+func (q QuadKey) Neighbors() []QuadKey {
+	x, y, z := q.SlippyCoords()
+	if z < MinZoom {
+		return nil
+	}
+
+	n := int64(1) << z
+	var neighbors []QuadKey
+	for _, offset := range neighborOffsets {
+		ny := int64(y) + offset[1]
+		if ny < 0 || ny >= n {
+			continue
+		}
+		nx := (int64(x) + offset[0] + n) % n
+		neighbor, _ := GenerateQuadKeyIndexFromSlippy(uint32(nx), uint32(ny), z)
+		if neighbor == q || slices.Contains(neighbors, neighbor) {
+			continue
+		}
+		neighbors = append(neighbors, neighbor)
+	}
+	return neighbors
+}
+
 // GenerateQuadKeyIndexFromSlippy generates the quadkey index from slippy coords
 // If zoom level is < MinZoomLevel or > MaxZoomLevel return error.
 // Only generate/care about bits 63 -> 32..
@@ -250,3 +281,61 @@ func (q QuadKey) GetAllChildrenAtZoom(maxZoom byte) []QuadKey {
 
 	return allQuadKeys
 }
+
+// InvalidQuadKeyStringError is returned when parsing a string that isn't a Bing style quadkey
+var InvalidQuadKeyStringError = errors.New("invalid quadkey string")
+
+// String returns the quadkey in the Bing Maps format, ie a digit (0-3) per zoom level, see
+// https://learn.microsoft.com/en-us/bingmaps/articles/bing-maps-tile-system
+// Each 2 bits of the QuadKey is already the Bing digit for that zoom level (y bit then x bit).
+// The root tile (zoom 0) is the empty string.
+func (q QuadKey) String() string {
+	z := q.Zoom()
+	digits := make([]byte, z)
+	for i := range digits {
+		digits[i] = '0' + byte(q>>(62-2*i)&0b11)
+	}
+	return string(digits)
+}
+
+// ParseQuadKeyString parses a Bing Maps format quadkey (eg. "0231") into a QuadKey.
+// The empty string is the root tile (zoom 0).
+func ParseQuadKeyString(s string) (QuadKey, error) {
+	if len(s) > MaxZoom {
+		return 0, fmt.Errorf("%w: %q is deeper than maximum zoom %d", InvalidQuadKeyStringError, s, MaxZoom)
+	}
+
+	var q QuadKey
+	for i := 0; i < len(s); i++ {
+		digit := s[i] - '0'
+		if digit > 3 {
+			return 0, fmt.Errorf("%w: %q", InvalidQuadKeyStringError, s)
+		}
+		q |= QuadKey(digit) << (62 - 2*i)
+	}
+	q |= QuadKey(len(s))
+	return q, nil
+}
+
+// TMSCoords returns the TMS coords of the quadkey. TMS is the same as slippy coords except y
+// is flipped, ie 0 is the southern most row.
+func (q QuadKey) TMSCoords() (uint32, uint32, byte) {
+	x, y, z := q.SlippyCoords()
+	return x, flipY(y, z), z
+}
+
+// GenerateQuadKeyIndexFromTMS generates the quadkey from TMS coords (see TMSCoords)
+func GenerateQuadKeyIndexFromTMS(x uint32, y uint32, zoomLevel byte) (QuadKey, error) {
+	if zoomLevel < MinZoom || zoomLevel > MaxZoom {
+		return 0, errors.New("invalid zoom level")
+	}
+	if y >= uint32(1)<<zoomLevel {
+		return 0, errors.New("invalid TMS coords")
+	}
+	return GenerateQuadKeyIndexFromSlippy(x, flipY(y, zoomLevel), zoomLevel)
+}
+
+// flipY converts y between slippy and TMS coords at zoom z
+func flipY(y uint32, z byte) uint32 {
+	return uint32(1)<<z - 1 - y
+}
@@ -138,6 +138,40 @@ func TestGetChildQuadKeyForPos(t *testing.T) {
 
 }
 
+// TestNeighbors checks neighbors including wrapping around the antimeridian and clamping at the poles
+func TestNeighbors(t *testing.T) {
+	slippy := func(qks []QuadKey) [][2]uint32 {
+		var coords [][2]uint32
+		for _, qk := range qks {
+			x, y, _ := qk.SlippyCoords()
+			coords = append(coords, [2]uint32{x, y})
+		}
+		return coords
+	}
+
+	qk, err := GenerateQuadKeyIndexFromSlippy(10, 20, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]uint32{{10, 19}, {11, 19}, {11, 20}, {11, 21}, {10, 21}, {9, 21}, {9, 20}, {9, 19}}, slippy(qk.Neighbors()))
+	for _, n := range qk.Neighbors() {
+		assert.Equal(t, byte(6), n.Zoom())
+	}
+
+	// west edge wraps around to the east
+	qk, err = GenerateQuadKeyIndexFromSlippy(0, 20, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]uint32{{0, 19}, {1, 19}, {1, 20}, {1, 21}, {0, 21}, {63, 21}, {63, 20}, {63, 19}}, slippy(qk.Neighbors()))
+
+	// nothing north of the top row
+	qk, err = GenerateQuadKeyIndexFromSlippy(63, 0, 6)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]uint32{{0, 0}, {0, 1}, {63, 1}, {62, 1}, {62, 0}}, slippy(qk.Neighbors()))
+
+	// at zoom 1 east and west are the same tile
+	qk, err = GenerateQuadKeyIndexFromSlippy(0, 0, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, [][2]uint32{{1, 0}, {1, 1}, {0, 1}}, slippy(qk.Neighbors()))
+}
+
 // TestGetMinMaxEquivForZoomLevel confirms that min/max (top left, bottom right) quadkeys are generated
 // based off an original quadkey and zoom target
 func TestGetMinMaxEquivForZoomLevel(t *testing.T) {
@@ -181,3 +215,52 @@ func TestGetMinMaxEquivForZoomLevel(t *testing.T) {
 //		})
 //	}
 //}
+
+// TestQuadKeyString checks conversion to/from Bing style quadkey strings
+// Example from https://learn.microsoft.com/en-us/bingmaps/articles/bing-maps-tile-system
+func TestQuadKeyString(t *testing.T) {
+	qk, err := GenerateQuadKeyIndexFromSlippy(3, 5, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, "213", qk.String())
+
+	parsed, err := ParseQuadKeyString("213")
+	assert.NoError(t, err)
+	assert.Equal(t, qk, parsed)
+
+	// round trip for the quadkeys used in the other tests
+	for _, qk := range []QuadKey{quadKey, Child0, Child1, Child2, Child3, MinChildZoom21, MaxChildZoom21, parent} {
+		parsed, err := ParseQuadKeyString(qk.String())
+		assert.NoError(t, err)
+		assert.Equal(t, qk, parsed)
+		assert.Len(t, qk.String(), int(qk.Zoom()))
+	}
+
+	root, err := ParseQuadKeyString("")
+	assert.NoError(t, err)
+	assert.Equal(t, QuadKey(0), root)
+	assert.Equal(t, "", root.String())
+
+	for _, s := range []string{"0124", "12a", "0000000000000000000000000"} {
+		_, err = ParseQuadKeyString(s)
+		assert.ErrorIs(t, err, InvalidQuadKeyStringError, s)
+	}
+}
+
+// TestTMSCoords checks conversion to/from TMS coords (y flipped)
+func TestTMSCoords(t *testing.T) {
+	qk, err := GenerateQuadKeyIndexFromSlippy(3, 5, 3)
+	assert.NoError(t, err)
+	x, y, z := qk.TMSCoords()
+	assert.Equal(t, uint32(3), x)
+	assert.Equal(t, uint32(2), y)
+	assert.Equal(t, byte(3), z)
+
+	tms, err := GenerateQuadKeyIndexFromTMS(x, y, z)
+	assert.NoError(t, err)
+	assert.Equal(t, qk, tms)
+
+	_, err = GenerateQuadKeyIndexFromTMS(0, 8, 3)
+	assert.Error(t, err)
+	_, err = GenerateQuadKeyIndexFromTMS(0, 0, MaxZoom+1)
+	assert.Error(t, err)
+}
@@ -3,9 +3,10 @@ package quadmap
 import (
 	"errors"
 	"fmt"
-	"math"
-	"sync"
+	"slices"
 	"sort"
+	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -28,14 +29,38 @@ type QuadMap struct {
 	// function able to take byte slices and populate Quadmap.
 	dataReader DataReader
 
+	// eviction state, nil if no eviction policy has been set.
+	eviction *evictionState
+
+	// sorted index of quadkeys, used for range queries.
+	index quadKeyIndex
+
+	// compact when creating full tiles, see SetCompactOnInsert
+	compactOnInsert bool
+
+	// counters for tiles per zoom/tiletype etc.
+	stats tileStats
+
+	// optional metadata for tiles (see TileMetadata), only tiles with metadata have an entry.
+	// Kept out of Tile since most tiles have none. The slices are replaced (never modified in
+	// place) so they can be returned to callers.
+	metadata map[QuadKey][]TileMetadata
+
+	// unique id, used to order taking the locks of multiple quadmaps (see rlockPair)
+	id uint64
+
 	lock sync.RWMutex
 }
 
+// nextQuadMapID is the id of the last QuadMap created
+var nextQuadMapID atomic.Uint64
+
 // NewQuadMap create a new quadmap
 // Should provide a large initialCapacity when dealing with large quadmap structures
 func NewQuadMap(initialCapacity int) *QuadMap {
 	return &QuadMap{
 		quadKeyMap: make(map[QuadKey]*Tile, initialCapacity),
+		id:         nextQuadMapID.Add(1),
 	}
 }
 
@@ -45,14 +70,17 @@ func (qm *QuadMap) SetDataReader(dr DataReader) {
 	qm.dataReader = dr
 }
 
+// ReadData populates the quadmap from data using the DataReader set with SetDataReader
+func (qm *QuadMap) ReadData(data *[]byte, tileType TileType) error {
+	if qm.dataReader == nil {
+		return errors.New("no data reader set")
+	}
+	return qm.dataReader(qm, data, tileType)
+}
+
 func (qm *QuadMap) GetAllTiles(sorted bool) ([]*Tile, error) {
 
-	allTiles := make([]*Tile, len(qm.quadKeyMap))
-	i := 0
-	for _, tile := range qm.quadKeyMap {
-		allTiles[i] = tile
-		i++
-	}
+	allTiles := qm.snapshotTiles()
 
 	// will this kill perf?
 	if sorted {
@@ -71,14 +99,11 @@ func (qm *QuadMap) GetParentTile(t *Tile) (*Tile, error) {
 		return nil, err
 	}
 
-	qm.lock.RLock()
-	parentTile, ok := qm.quadKeyMap[parentKey]
-	qm.lock.RUnlock()
-
-	if !ok {
+	parentTile, err := qm.lookupTile(parentKey)
+	if errors.Is(err, TileNotFoundError) {
 		return nil, errors.New("parent tile not found")
 	}
-	return parentTile, nil
+	return parentTile, err
 }
 
 // GetChildInPos returns child tile of passed in tile t which is in position pos
@@ -88,14 +113,59 @@ func (qm *QuadMap) GetChildInPos(t *Tile, pos int) (*Tile, error) {
 	if err != nil {
 		return nil, err
 	}
-	qm.lock.RLock()
-	childTile, ok := qm.quadKeyMap[childKey]
-	qm.lock.RUnlock()
-
-	if !ok {
+	childTile, err := qm.lookupTile(childKey)
+	if errors.Is(err, TileNotFoundError) {
 		return nil, errors.New(fmt.Sprintf("child tile in pos %d not found", pos))
 	}
-	return childTile, nil
+	return childTile, err
+}
+
+// GetNeighborTiles returns the tiles with tileType that cover the neighbors of tile t (see
+// QuadKey.Neighbors). A neighbor is covered by the tile at the same zoom if it has tileType,
+// otherwise by a full ancestor, so the returned tiles may be at a lower zoom than t. Neighbors
+// that aren't covered are skipped, and a tile covering multiple neighbors is only returned once.
+// Useful for finding the seams between surveys.
+func (qm *QuadMap) GetNeighborTiles(t *Tile, tileType TileType) ([]*Tile, error) {
+	return getNeighborTiles(qm.lookupTile, t, tileType)
+}
+
+func getNeighborTiles(lookup tileLookup, t *Tile, tileType TileType) ([]*Tile, error) {
+	var tiles []*Tile
+	for _, neighbor := range t.QuadKey.Neighbors() {
+		tile, err := coveringTile(lookup, neighbor, tileType)
+		if errors.Is(err, TileNotFoundError) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(tiles, tile) {
+			tiles = append(tiles, tile)
+		}
+	}
+	return tiles, nil
+}
+
+// coveringTile returns the tile for qk if it has tileType, otherwise the closest ancestor
+// that has tileType and is full. Returns TileNotFoundError if qk isn't covered.
+func coveringTile(lookup tileLookup, qk QuadKey, tileType TileType) (*Tile, error) {
+	for quadKey := qk; ; {
+		t, err := lookup(quadKey)
+		if err != nil && !errors.Is(err, TileNotFoundError) {
+			return nil, err
+		}
+		if err == nil {
+			hasTileType, isFull := t.HasTileTypeAndFull(tileType)
+			if hasTileType && (isFull || quadKey == qk) {
+				return t, nil
+			}
+		}
+
+		quadKey, err = quadKey.Parent()
+		if err != nil {
+			return nil, TileNotFoundError
+		}
+	}
 }
 
 // GetExactTileForSlippy returns tile for slippy co-ord match. Does NOT traverse up the ancestry
@@ -126,62 +196,134 @@ func (qm *QuadMap) GetTileForSlippyAndTileType(x uint32, y uint32, z byte, tt Ti
 }
 
 // GetExactTileForQuadKey returns tile for quadkey match. Does NOT traverse up the ancestry
+// If the tile has been evicted it will be reloaded (see SetEvictionPolicy)
 func (qm *QuadMap) GetExactTileForQuadKey(quadKey QuadKey) (*Tile, error) {
-
-	qm.lock.RLock()
-	defer qm.lock.RUnlock()
-
-	// if actual quadkey exists, return tile.
-	if t, ok := qm.quadKeyMap[quadKey]; ok {
-		return t, nil
-	}
-	return nil, TileNotFoundError
+	return qm.lookupTile(quadKey)
 }
 
 // NumberOfTilesForZoom returns number of tiles for a given zoom level.
 // It will NOT include parents that may be used when querying (and the parents
 // are marked as full)
-// Given we don't keep track of zoom levels separately, we need to traverse the
-// entire quadmap. If this is a common operation we'll need to track/cache this
-// information somewhere. Although for the limited test cases so far it's pretty much instant
+// The number of tiles per zoom level is tracked as tiles are added/removed, so this doesn't
+// need to traverse the quadmap.
 func (qm *QuadMap) NumberOfTilesForZoom(zoom byte) int {
-	count := 0
-	for _, t := range qm.quadKeyMap {
-		if t.QuadKey.Zoom() == zoom {
-			count++
-		}
+	if zoom > MaxZoom {
+		return 0
 	}
-	return count
+
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+	return qm.stats.zoomCounts[zoom]
 }
 
 // GetTilesForTypeAndZoom gets tiles for a given tile type and zoom level
+// The tiles at zoom are found from the sorted quadkey index, and checked for tt as they are now
+// (so changes made directly to tiles, eg. Tile.AddTileType, are included).
 func (qm *QuadMap) GetTilesForTypeAndZoom(tt TileType, zoom byte) []*Tile {
 	tiles := []*Tile{}
 
 	qm.lock.RLock()
 	defer qm.lock.RUnlock()
-	for _, t := range qm.quadKeyMap {
-		if t.QuadKey.Zoom() == zoom && t.HasTileType(tt) {
+
+	qm.ascendZoomLocked(zoom, func(t *Tile) {
+		if t.HasTileType(tt) {
 			tiles = append(tiles, t)
 		}
-	}
+	})
 	return tiles
 }
 
 // NumberOfTiles returns number of tiles in quadmap
 func (qm *QuadMap) NumberOfTiles() int {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
 	return len(qm.quadKeyMap)
 }
 
+// Range calls f for each tile in the quadmap (in no particular order) until f returns false.
+// The tiles are snapshotted first so the lock isn't held while f is called, meaning f can
+// modify the quadmap. Tiles added during the walk won't be seen, and tiles removed during the
+// walk may still be passed to f.
+func (qm *QuadMap) Range(f func(t *Tile) bool) {
+	for _, t := range qm.snapshotTiles() {
+		if !f(t) {
+			return
+		}
+	}
+}
+
+// snapshotTiles returns all tiles currently in the quadmap
+func (qm *QuadMap) snapshotTiles() []*Tile {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+
+	tiles := make([]*Tile, 0, len(qm.quadKeyMap))
+	for _, t := range qm.quadKeyMap {
+		tiles = append(tiles, t)
+	}
+	return tiles
+}
+
 // AddTile adds a pre-generated tile (which has its quadkey already)
 func (qm *QuadMap) AddTile(t *Tile) error {
 	qm.lock.Lock()
 	defer qm.lock.Unlock()
-	qm.quadKeyMap[t.QuadKey] = t
+	qm.putTileLocked(t)
+	qm.enforceEvictionPolicyLocked()
 	return nil
 }
 
+// mergeTile adds tile t (with metadata) to the quadmap. If a tile already exists for the quadkey
+// then the tile types, full flags and metadata of t are added to the existing tile.
+func (qm *QuadMap) mergeTile(t *Tile, metadata []TileMetadata) {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+	if existing, ok := qm.quadKeyMap[t.QuadKey]; ok {
+		old := existing.LoadDetails()
+		existing.mergeDetails(t.LoadDetails())
+		qm.stats.detailsChanged(existing, old, existing.LoadDetails())
+		qm.addMetadataLocked(t.QuadKey, metadata)
+		return
+	}
+	qm.putTileLocked(t)
+	qm.addMetadataLocked(t.QuadKey, metadata)
+	qm.enforceEvictionPolicyLocked()
+}
+
+// putTileLocked stores tile t in the quadmap, replacing any existing tile (and its metadata)
+// with the same quadkey.
+// Caller must hold the write lock.
+func (qm *QuadMap) putTileLocked(t *Tile) {
+	if existing, ok := qm.quadKeyMap[t.QuadKey]; ok {
+		qm.stats.tileRemoved(existing)
+		qm.setMetadataLocked(t.QuadKey, nil)
+	} else {
+		qm.index.insert(t.QuadKey)
+	}
+	qm.quadKeyMap[t.QuadKey] = t
+	qm.stats.tileAdded(t)
+	if qm.eviction != nil {
+		qm.eviction.added(t)
+	}
+}
+
+// deleteTileLocked removes the tile for qk from the quadmap.
+// Caller must hold the write lock.
+func (qm *QuadMap) deleteTileLocked(qk QuadKey) {
+	t, ok := qm.quadKeyMap[qk]
+	if !ok {
+		return
+	}
+	delete(qm.quadKeyMap, qk)
+	qm.setMetadataLocked(qk, nil)
+	qm.stats.tileRemoved(t)
+	qm.index.remove(qk)
+}
+
 // CreateTileAtSlippyCoords creates a tile to the quadmap at slippy coords
+// If compact on insert is enabled (see SetCompactOnInsert) and the new full tile completes a
+// set of four full siblings, they are collapsed into their parent. The returned tile is then
+// the (full) ancestor that now covers x,y,z, so its QuadKey isn't the QuadKey for x,y,z.
 func (qm *QuadMap) CreateTileAtSlippyCoords(x uint32, y uint32, z byte, tileType TileType, full bool) (*Tile, error) {
 
 	// x,y,z are already child coords...  so no need to take pos into account
@@ -189,21 +331,30 @@ func (qm *QuadMap) CreateTileAtSlippyCoords(x uint32, y uint32, z byte, tileType
 	if err != nil {
 		return nil, err
 	}
+	return qm.createTile(quadKey, tileType, full)
+}
+
+// createTile implements CreateTileAtSlippyCoords for the tile at quadKey
+func (qm *QuadMap) createTile(quadKey QuadKey, tileType TileType, full bool) (*Tile, error) {
 	qm.lock.Lock()
 	defer qm.lock.Unlock()
 
 	// check if child exists.
 	if tile, ok := qm.quadKeyMap[quadKey]; ok {
-		tile.AddTileType(tileType, full)
+		qm.addTileTypeLocked(tile, tileType, full)
+		if full && qm.compactOnInsert {
+			tile = qm.quadKeyMap[qm.compactFullTileLocked(quadKey, tileType)]
+		}
 		return tile, nil
 	}
 
-	t, err := NewTileWithTileTypeAndFull(x, y, z, tileType, full)
-	if err != nil {
-		return nil, err
+	t := NewTileWithQuadKey(quadKey)
+	t.AddTileType(tileType, full)
+	qm.putTileLocked(t)
+	if full && qm.compactOnInsert {
+		t = qm.quadKeyMap[qm.compactFullTileLocked(quadKey, tileType)]
 	}
-
-	qm.quadKeyMap[t.QuadKey] = t
+	qm.enforceEvictionPolicyLocked()
 	return t, nil
 }
 
@@ -218,62 +369,86 @@ func createChildForPos(childQuadKey QuadKey, pos int) (*Tile, error) {
 
 // GetSlippyBoundsForTileTypeAndZoom returns minX, minY, maxX, maxY slippy coords for a given tiletype and
 // zoom level
+// Bounds are tracked per tiletype and zoom level as tiles are added, so this doesn't need to
+// traverse the quadmap.
 func (qm *QuadMap) GetSlippyBoundsForTileTypeAndZoom(tileType TileType, zoom byte) (uint32, uint32, uint32, uint32, error) {
+	if zoom > MaxZoom {
+		return 0, 0, 0, 0, errors.New("invalid zoom level")
+	}
 
-	var minX uint32 = math.MaxUint32
-	var minY uint32 = math.MaxUint32
-	var maxX uint32 = 0
-	var maxY uint32 = 0
-
-	for quadKey, v := range qm.quadKeyMap {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
 
-		if quadKey == 0 {
-			continue // should this be in the quadMap at all?
-		}
+	qm.stats.boundsLock.Lock()
+	defer qm.stats.boundsLock.Unlock()
+
+	// zoom 0 (the root tile) is skipped, should it be in the quadMap at all?
+	// Statistics are per tiletype, so if multiple tiletypes have been combined the bounds for
+	// each are combined.
+	bounds := emptySlippyBounds()
+	for types := uint32(tileType); types != 0; types &= types - 1 {
+		tt := TileType(types & -types)
+		for z := byte(1); z <= zoom; z++ {
+			zs := qm.stats.zoomStats(tt, z)
+			if zs == nil || zs.tiles == 0 {
+				continue
+			}
 
-		z := quadKey.Zoom()
-		if z > zoom {
-			continue
+			// only include tiles at the precise zoom level OR tiles that are considered full.
+			all, full := qm.boundsForZoomLocked(zs, tt, z)
+			if z == zoom {
+				bounds.union(all)
+			} else {
+				bounds.union(full.atZoom(z, zoom))
+			}
 		}
+	}
 
-		hasTileType, isFull := v.HasTileTypeAndFull(tileType)
-
-		if !hasTileType {
-			continue
-		}
+	return bounds.minX, bounds.minY, bounds.maxX, bounds.maxY, nil
+}
 
-		// only continue if precise zoom level OR this tile is considered full.
-		if z == zoom || isFull {
-			minChild, maxChild, err := quadKey.GetMinMaxEquivForZoomLevel(zoom)
-			if err != nil {
-				return 0, 0, 0, 0, err
-			}
+// GetAllChildrenForQuadKeyAndZoom returns all quadkeys for a given zoom level including situations where a parent
+// is marked as full.
+func (qm *QuadMap) GetAllChildrenForQuadKeyAndZoom(qk QuadKey, tileType TileType, zoom byte) ([]QuadKey, error) {
+	return getAllChildrenForQuadKeyAndZoom(qm.lookupTile, qk, tileType, zoom)
+}
 
-			x, y, _ := minChild.SlippyCoords()
-			if x < minX {
-				minX = x
-			}
-			if y < minY {
-				minY = y
-			}
+// IsTileCoveredForSlippyCoordsAndTileTypeTopDown takes slippy coord, gets all ancestors to see if tile should exist
+// (by checking ancestors + full flag)
+// Also returns the quadkey that covers the co-ord... whether its the actual QK for the co-ordinates
+// or an ancestor that is full
+func (qm *QuadMap) IsTileCoveredForSlippyCoordsAndTileTypeTopDown(x uint32, y uint32, z byte, tileType TileType) (bool, QuadKey, error) {
+	return isTileCoveredForSlippyCoordsAndTileTypeTopDown(qm.lookupTile, x, y, z, tileType)
+}
 
-			x, y, _ = maxChild.SlippyCoords()
-			if x > maxX {
-				maxX = x
-			}
+// lookupTile returns the tile for the quadkey or TileNotFoundError.
+// If an eviction policy is set, the tile is marked as recently used and evicted tiles are
+// reloaded.
+func (qm *QuadMap) lookupTile(qk QuadKey) (*Tile, error) {
+	qm.lock.RLock()
+	t, ok := qm.quadKeyMap[qk]
+	e := qm.eviction
+	qm.lock.RUnlock()
 
-			if y > maxY {
-				maxY = y
-			}
+	if e == nil {
+		if !ok {
+			return nil, TileNotFoundError
 		}
+		return t, nil
 	}
 
-	return minX, minY, maxX, maxY, nil
+	if ok {
+		e.touch(t)
+		return t, nil
+	}
+	return qm.reloadEvictedTile(e, qk)
 }
 
-// GetAllChildrenForQuadKeyAndZoom returns all quadkeys for a given zoom level including situations where a parent
-// is marked as full.
-func (qm *QuadMap) GetAllChildrenForQuadKeyAndZoom(qk QuadKey, tileType TileType, zoom byte) ([]QuadKey, error) {
+// tileLookup returns the tile for the quadkey or TileNotFoundError. Used so the traversal
+// logic can be shared between QuadMap, ShardedQuadMap and MappedQuadMap
+type tileLookup func(qk QuadKey) (*Tile, error)
+
+func getAllChildrenForQuadKeyAndZoom(lookup tileLookup, qk QuadKey, tileType TileType, zoom byte) ([]QuadKey, error) {
 
 	if qk.Zoom() == zoom {
 		return []QuadKey{qk}, nil
@@ -281,10 +456,13 @@ func (qm *QuadMap) GetAllChildrenForQuadKeyAndZoom(qk QuadKey, tileType TileType
 
 	allKeys := []QuadKey{}
 	for _, child := range qk.Children() {
-		childData, err := qm.GetExactTileForQuadKey(child)
+		childData, err := lookup(child)
 		if errors.Is(err, TileNotFoundError) {
 			continue
 		}
+		if err != nil {
+			return nil, err
+		}
 		hasTileType, isFull := childData.HasTileTypeAndFull(tileType)
 		if hasTileType {
 			if isFull {
@@ -295,7 +473,7 @@ func (qm *QuadMap) GetAllChildrenForQuadKeyAndZoom(qk QuadKey, tileType TileType
 			}
 
 			// check children of this child
-			childKeys, err := qm.GetAllChildrenForQuadKeyAndZoom(child, tileType, zoom)
+			childKeys, err := getAllChildrenForQuadKeyAndZoom(lookup, child, tileType, zoom)
 			if err != nil {
 				return nil, err
 			}
@@ -305,25 +483,20 @@ func (qm *QuadMap) GetAllChildrenForQuadKeyAndZoom(qk QuadKey, tileType TileType
 	return allKeys, nil
 }
 
-// IsTileCoveredForSlippyCoordsAndTileTypeTopDown takes slippy coord, gets all ancestors to see if tile should exist
-// (by checking ancestors + full flag)
-// Also returns the quadkey that covers the co-ord... whether its the actual QK for the co-ordinates
-// or an ancestor that is full
-func (qm *QuadMap) IsTileCoveredForSlippyCoordsAndTileTypeTopDown(x uint32, y uint32, z byte, tileType TileType) (bool, QuadKey, error) {
+func isTileCoveredForSlippyCoordsAndTileTypeTopDown(lookup tileLookup, x uint32, y uint32, z byte, tileType TileType) (bool, QuadKey, error) {
 
 	quadKey, err := GenerateQuadKeyIndexFromSlippy(x, y, z)
 	if err != nil {
 		return false, 0, err
 	}
 
-	//allAncestors := quadKey.GetAllAncestorsAndSelf()
-
 	qk := quadKey
 	for {
-		qm.lock.RLock()
-		t, ok := qm.quadKeyMap[qk]
-		qm.lock.RUnlock()
-		if ok {
+		t, err := lookup(qk)
+		if err != nil && !errors.Is(err, TileNotFoundError) {
+			return false, 0, err
+		}
+		if err == nil {
 
 			// if at target zoom level and match... then true
 			if t.QuadKey.Zoom() == z {
@@ -342,25 +515,5 @@ func (qm *QuadMap) IsTileCoveredForSlippyCoordsAndTileTypeTopDown(x uint32, y ui
 		}
 	}
 
-	//for _, qk := range allAncestors {
-	//	qm.lock.RLock()
-	//	t, ok := qm.quadKeyMap[qk]
-	//	qm.lock.RUnlock()
-	//	if ok {
-	//
-	//		// if at target zoom level and match... then true
-	//		if t.QuadKey.Zoom() == z {
-	//			// have match... return true
-	//			return true, nil
-	//		}
-	//
-	//		hasTileType, isFull := t.HasTileTypeAndFull(tileType)
-	//		if hasTileType && isFull {
-	//			return true, nil
-	//		}
-	//	}
-	//
-	//}
-
 	return false, 0, nil
 }
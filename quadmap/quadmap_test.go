@@ -63,3 +63,38 @@ func TestGetTileForSlippyAndTileType(t *testing.T) {
 	assert.EqualValues(t, z, 5)
 
 }
+
+// TestGetNeighborTiles checks neighbors are resolved to tiles at the same zoom or full ancestors
+func TestGetNeighborTiles(t *testing.T) {
+	qm := NewQuadMap(10)
+	tile, err := qm.CreateTileAtSlippyCoords(10, 10, 6, TileTypeDSM, false)
+	assert.NoError(t, err)
+
+	// same zoom, with and without the tiletype
+	east, err := qm.CreateTileAtSlippyCoords(11, 10, 6, TileTypeDSM, false)
+	assert.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(10, 11, 6, TileTypeVert, true)
+	assert.NoError(t, err)
+
+	neighbors, err := qm.GetNeighborTiles(tile, TileTypeDSM)
+	assert.NoError(t, err)
+	assert.Equal(t, []*Tile{east}, neighbors)
+
+	// full tile at zoom 5 covers the west and south west neighbors, but is only returned once
+	west, err := qm.CreateTileAtSlippyCoords(4, 5, 5, TileTypeDSM, true)
+	assert.NoError(t, err)
+	neighbors, err = qm.GetNeighborTiles(tile, TileTypeDSM)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*Tile{east, west}, neighbors)
+
+	// tiles that aren't full don't cover their descendants
+	_, err = qm.CreateTileAtSlippyCoords(2, 2, 4, TileTypeDSM, false)
+	assert.NoError(t, err)
+	neighbors, err = qm.GetNeighborTiles(tile, TileTypeDSM)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []*Tile{east, west}, neighbors)
+
+	neighbors, err = qm.GetNeighborTiles(tile, TileTypeTrueOrtho)
+	assert.NoError(t, err)
+	assert.Empty(t, neighbors)
+}
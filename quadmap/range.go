@@ -14,8 +14,8 @@ func (q QuadKey) Range() (r QuadKeyRange) {
 	// isn't too bad, it won't be many and we just need to double check
 	// intersections with QuadKey.IsAncestorOf.
 	//
-	// TODO: find a way to avoid this. Keeping the zoom level in the lower bits
-	// might work, although that prevents combining adjacent ranges.
+	// SentinelKey keeps the zoom level in the lower bits (as a sentinel bit) which
+	// avoids this, use SentinelKey.Range if exact ranges are needed.
 	z := q.Zoom()
 	mask := ^uint64(0) >> (z * 2)
 	r.Start = uint64(q) & ^mask
@@ -31,3 +31,52 @@ func (q QuadKey) SingleRange() QuadKeyRange {
 func (r QuadKeyRange) Contains(q QuadKey) bool {
 	return r.Start <= uint64(q) && uint64(q) <= r.End
 }
+
+// SentinelKeyRange is a range containing SentinelKeys.
+type SentinelKeyRange struct {
+	// Start and endpoints of the range, both inclusive.
+	// Note: Start and End aren't, in general, valid SentinelKeys themselves.
+	Start, End SentinelKey
+}
+
+// Range returns the range of SentinelKeys that contains s and all of its descendants, and no
+// other valid SentinelKeys.
+func (s SentinelKey) Range() SentinelKeyRange {
+	lsb := s.lsb()
+	return SentinelKeyRange{
+		Start: SentinelKey(uint64(s) - lsb + 1),
+		End:   SentinelKey(uint64(s) + lsb - 1),
+	}
+}
+
+// SingleRange returns a range that only contains this SentinelKey.
+func (s SentinelKey) SingleRange() SentinelKeyRange {
+	return SentinelKeyRange{s, s}
+}
+
+func (r SentinelKeyRange) Contains(s SentinelKey) bool {
+	return r.Start <= s && s <= r.End
+}
+
+// HilbertKeyRange is a range containing HilbertKeys.
+type HilbertKeyRange struct {
+	// Start and endpoints of the range, both inclusive.
+	// Note: Start and End aren't, in general, valid HilbertKeys themselves.
+	Start, End HilbertKey
+}
+
+// Range returns the range of HilbertKeys that contains h and all of its descendants, and no
+// other valid HilbertKeys.
+func (h HilbertKey) Range() HilbertKeyRange {
+	r := SentinelKey(h).Range()
+	return HilbertKeyRange{Start: HilbertKey(r.Start), End: HilbertKey(r.End)}
+}
+
+// SingleRange returns a range that only contains this HilbertKey.
+func (h HilbertKey) SingleRange() HilbertKeyRange {
+	return HilbertKeyRange{h, h}
+}
+
+func (r HilbertKeyRange) Contains(h HilbertKey) bool {
+	return r.Start <= h && h <= r.End
+}
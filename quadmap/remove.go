@@ -0,0 +1,203 @@
+package quadmap
+
+import (
+	"slices"
+)
+
+// RemoveTile removes the tile for quadKey from the quadmap. Ancestors and descendants are left
+// untouched, see RemoveTileTypeForSubtree to retract a tiletype from an area.
+// Returns TileNotFoundError if the tile isn't in the quadmap.
+func (qm *QuadMap) RemoveTile(quadKey QuadKey) error {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	if _, ok := qm.quadKeyMap[quadKey]; !ok {
+		return TileNotFoundError
+	}
+	qm.removeTileLocked(quadKey)
+	return nil
+}
+
+// RemoveTileTypeForSubtree removes tileType from the tile for quadKey and all of its descendants
+// (eg. when a survey has been withdrawn). Tiles left without any tiletypes are removed.
+// Ancestors are fixed up so the quadmap still describes the same coverage outside of quadKey:
+//   - if an ancestor was full, it is no longer full. Instead the siblings along the path down to
+//     quadKey are marked as full, so the rest of the ancestor's area is still covered.
+//   - otherwise, ancestors that no longer have any descendants with tileType have tileType removed.
+//
+// Returns TileWithTileTypeNotFound if neither the subtree nor a full ancestor had tileType.
+// Evicted tiles are not reloaded, it's assumed the backing data has also been updated.
+func (qm *QuadMap) RemoveTileTypeForSubtree(quadKey QuadKey, tileType TileType) error {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	err := removeTileTypeForSubtreeLocked(func(QuadKey) *QuadMap { return qm }, []*QuadMap{qm}, quadKey, tileType)
+	qm.enforceEvictionPolicyLocked()
+	return err
+}
+
+// shardLookup returns the QuadMap that holds (or would hold) the tile for the quadkey. Used so
+// changes spanning multiple tiles can be shared between QuadMap and ShardedQuadMap
+type shardLookup func(qk QuadKey) *QuadMap
+
+// removeTileTypeForSubtreeLocked implements RemoveTileTypeForSubtree. subtree is every QuadMap
+// that may hold descendants of quadKey.
+// Caller must hold the write lock of every QuadMap involved.
+func removeTileTypeForSubtreeLocked(shardFor shardLookup, subtree []*QuadMap, quadKey QuadKey, tileType TileType) error {
+	removed := false
+	for _, qm := range subtree {
+		if qm.removeTileTypeFromSubtreeLocked(quadKey, tileType) {
+			removed = true
+		}
+	}
+
+	ancestors := ancestorsOf(quadKey)
+
+	// topmost full ancestor (if any) provides the coverage of quadKey that is being removed.
+	for i, a := range ancestors {
+		t, ok := shardFor(a).quadKeyMap[a]
+		if !ok {
+			continue
+		}
+		if hasTileType, isFull := t.HasTileTypeAndFull(tileType); hasTileType && isFull {
+			splitFullAncestorLocked(shardFor, ancestors[i:], quadKey, tileType)
+			return nil
+		}
+	}
+
+	if !removed {
+		return TileWithTileTypeNotFound
+	}
+
+	// no full ancestors, so remove tileType from ancestors that no longer need it (bottom up)
+	for i := len(ancestors) - 1; i >= 0; i-- {
+		qm := shardFor(ancestors[i])
+		t, ok := qm.quadKeyMap[ancestors[i]]
+		if !ok || !t.HasTileType(tileType) {
+			continue
+		}
+		if hasChildWithTileTypeLocked(shardFor, ancestors[i], tileType) {
+			break
+		}
+		qm.removeTileTypeLocked(t, tileType)
+	}
+	return nil
+}
+
+// removeTileTypeFromSubtreeLocked removes tileType from quadKey and its descendants.
+// Returns true if any tile had tileType.
+// Caller must hold the write lock.
+func (qm *QuadMap) removeTileTypeFromSubtreeLocked(quadKey QuadKey, tileType TileType) bool {
+	subtree := qm.subtreeTilesLocked(quadKey, tileType)
+	for _, t := range subtree {
+		qm.removeTileTypeLocked(t, tileType)
+	}
+	return len(subtree) > 0
+}
+
+// subtreeTilesLocked returns the tiles with tileType for quadKey and all its descendants.
+// Only the part of the index covering quadKey's range is visited.
+// Caller must hold (at least) the read lock.
+func (qm *QuadMap) subtreeTilesLocked(quadKey QuadKey, tileType TileType) []*Tile {
+	r := quadKey.Range()
+	var subtree []*Tile
+	qm.index.ascendRange(r.Start, r.End, func(qk QuadKey) bool {
+		if !quadKey.IsAncestorOf(qk) {
+			return true
+		}
+		if t := qm.quadKeyMap[qk]; t.HasTileType(tileType) {
+			subtree = append(subtree, t)
+		}
+		return true
+	})
+	return subtree
+}
+
+// splitFullAncestorLocked clears the full flag on the ancestors (ordered from the full ancestor
+// down to quadKey's parent) and marks the siblings of each step along the path to quadKey as full.
+// The siblings are given the full ancestor's metadata for tileType, since it still applies to them.
+// Caller must hold the write lock of every QuadMap involved.
+func splitFullAncestorLocked(shardFor shardLookup, ancestors []QuadKey, quadKey QuadKey, tileType TileType) {
+	// path[i] is the child of ancestors[i] that is on the way down to quadKey
+	path := make([]QuadKey, len(ancestors))
+	copy(path, ancestors[1:])
+	path[len(path)-1] = quadKey
+
+	metadata := metadataForTileType(shardFor(ancestors[0]).metadata[ancestors[0]], tileType)
+	markFull := func(qm *QuadMap, c *Tile) {
+		qm.addTileTypeLocked(c, tileType, true)
+		qm.addMetadataLocked(c.QuadKey, metadata)
+	}
+
+	for i, a := range ancestors {
+		qm := shardFor(a)
+		t, ok := qm.quadKeyMap[a]
+		if !ok {
+			t = NewTileWithQuadKey(a)
+			qm.putTileLocked(t)
+		}
+		qm.addTileTypeLocked(t, tileType, false)
+
+		for _, child := range a.Children() {
+			if child == path[i] {
+				continue
+			}
+			childQM := shardFor(child)
+			if c, ok := childQM.quadKeyMap[child]; ok {
+				markFull(childQM, c)
+				continue
+			}
+			c := NewTileWithQuadKey(child)
+			childQM.putTileLocked(c)
+			markFull(childQM, c)
+		}
+	}
+}
+
+// ancestorsOf returns the ancestors of quadKey ordered from the root down to quadKey's parent
+func ancestorsOf(quadKey QuadKey) []QuadKey {
+	var ancestors []QuadKey
+	for {
+		parent, err := quadKey.Parent()
+		if err != nil {
+			break
+		}
+		ancestors = append(ancestors, parent)
+		quadKey = parent
+	}
+	slices.Reverse(ancestors)
+	return ancestors
+}
+
+// hasChildWithTileTypeLocked returns true if any of the children of quadKey have tileType.
+// Caller must hold (at least) the read lock of every QuadMap involved.
+func hasChildWithTileTypeLocked(shardFor shardLookup, quadKey QuadKey, tileType TileType) bool {
+	if quadKey.Zoom() >= MaxZoom {
+		return false
+	}
+	for _, child := range quadKey.Children() {
+		if t, ok := shardFor(child).quadKeyMap[child]; ok && t.HasTileType(tileType) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeTileTypeLocked removes tileType from t, and removes t from the quadmap if it has no
+// tiletypes left.
+// Caller must hold the write lock.
+func (qm *QuadMap) removeTileTypeLocked(t *Tile, tileType TileType) {
+	old := t.LoadDetails()
+	t.RemoveTileType(tileType)
+	qm.stats.detailsChanged(t, old, t.LoadDetails())
+	qm.removeMetadataForTileTypeLocked(t.QuadKey, tileType)
+	if t.hasNoTileTypes() {
+		qm.removeTileLocked(t.QuadKey)
+	}
+}
+
+// removeTileLocked removes the tile for quadKey from the quadmap (as opposed to evicting it).
+// Caller must hold the write lock.
+func (qm *QuadMap) removeTileLocked(quadKey QuadKey) {
+	qm.deleteTileLocked(quadKey)
+}
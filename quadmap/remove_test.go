@@ -0,0 +1,122 @@
+package quadmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRemoveTile(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 3, TileTypeVert, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(2, 2, 4, TileTypeVert, true)
+	require.NoError(t, err)
+
+	qk := mustQuadKey(t, 1, 1, 3)
+	assert.NoError(t, qm.RemoveTile(qk))
+	assert.ErrorIs(t, qm.RemoveTile(qk), TileNotFoundError)
+	assert.Equal(t, 1, qm.NumberOfTiles())
+
+	_, err = qm.GetExactTileForQuadKey(qk)
+	assert.ErrorIs(t, err, TileNotFoundError)
+	_, err = qm.GetExactTileForSlippy(2, 2, 4)
+	assert.NoError(t, err)
+}
+
+func TestRemoveTileTypeForSubtreeSplitsFullAncestor(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 3, TileTypeVert, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(1, 1, 3, TileTypeDSM, true)
+	require.NoError(t, err)
+
+	// 4,5,5 is a grandchild of 1,1,3 (via 2,2,4)
+	err = qm.RemoveTileTypeForSubtree(mustQuadKey(t, 4, 5, 5), TileTypeVert)
+	require.NoError(t, err)
+
+	for _, tc := range []struct {
+		x, y    uint32
+		z       byte
+		covered bool
+	}{
+		{4, 5, 5, false},
+		{8, 10, 6, false},
+		{5, 5, 5, true},
+		{4, 4, 5, true},
+		{5, 4, 5, true},
+		{6, 6, 5, true},
+		{3, 3, 4, true},
+		{0, 0, 4, false},
+	} {
+		covered, _, err := qm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(tc.x, tc.y, tc.z, TileTypeVert)
+		require.NoError(t, err)
+		assert.Equal(t, tc.covered, covered, "%d,%d,%d", tc.x, tc.y, tc.z)
+	}
+
+	// ancestors are no longer full for Vert, but DSM is unchanged
+	for _, qk := range []QuadKey{mustQuadKey(t, 1, 1, 3), mustQuadKey(t, 2, 2, 4)} {
+		tile, err := qm.GetExactTileForQuadKey(qk)
+		require.NoError(t, err)
+		hasTileType, isFull := tile.HasTileTypeAndFull(TileTypeVert)
+		assert.True(t, hasTileType)
+		assert.False(t, isFull)
+	}
+	tile, err := qm.GetExactTileForSlippy(1, 1, 3)
+	require.NoError(t, err)
+	_, isFull := tile.HasTileTypeAndFull(TileTypeDSM)
+	assert.True(t, isFull)
+	covered, _, err := qm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(8, 10, 6, TileTypeDSM)
+	require.NoError(t, err)
+	assert.True(t, covered)
+}
+
+func TestRemoveTileTypeForSubtreeCleansUpAncestors(t *testing.T) {
+	qm := NewQuadMap(10)
+	for _, c := range []struct {
+		x, y uint32
+		z    byte
+		full bool
+	}{
+		{2, 2, 3, false},
+		{4, 4, 4, false},
+		{8, 8, 5, true},
+		{9, 9, 5, false},
+		{18, 18, 6, true},
+	} {
+		_, err := qm.CreateTileAtSlippyCoords(c.x, c.y, c.z, TileTypeVert, c.full)
+		require.NoError(t, err)
+	}
+	_, err := qm.CreateTileAtSlippyCoords(2, 2, 3, TileTypeDSM, false)
+	require.NoError(t, err)
+
+	// 4,4,4 still has 9,9,5 so nothing changes above 8,8,5
+	require.NoError(t, qm.RemoveTileTypeForSubtree(mustQuadKey(t, 8, 8, 5), TileTypeVert))
+	assert.Equal(t, 4, qm.NumberOfTiles())
+	_, err = qm.GetExactTileForSlippy(8, 8, 5)
+	assert.ErrorIs(t, err, TileNotFoundError)
+
+	// removing 9,9,5 (and 18,18,6) leaves nothing under 4,4,4 or 2,2,3 for Vert.
+	// 2,2,3 still has DSM so is kept.
+	require.NoError(t, qm.RemoveTileTypeForSubtree(mustQuadKey(t, 9, 9, 5), TileTypeVert))
+	assert.Equal(t, 1, qm.NumberOfTiles())
+	tile, err := qm.GetExactTileForSlippy(2, 2, 3)
+	require.NoError(t, err)
+	assert.False(t, tile.HasTileType(TileTypeVert))
+	assert.True(t, tile.HasTileType(TileTypeDSM))
+
+	tiles, err := qm.TilesInRanges([]QuadKeyRange{QuadKey(0).Range()}, TileTypeVert)
+	require.NoError(t, err)
+	assert.Empty(t, tiles)
+}
+
+func TestRemoveTileTypeForSubtreeNotFound(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(2, 2, 3, TileTypeDSM, true)
+	require.NoError(t, err)
+
+	err = qm.RemoveTileTypeForSubtree(mustQuadKey(t, 4, 4, 4), TileTypeVert)
+	assert.ErrorIs(t, err, TileWithTileTypeNotFound)
+	assert.Equal(t, 1, qm.NumberOfTiles())
+}
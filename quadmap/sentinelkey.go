@@ -0,0 +1,84 @@
+package quadmap
+
+import (
+	"errors"
+	"math/bits"
+)
+
+// SentinelKey is an alternative encoding of a QuadKey where the zoom level is implied by the
+// position of a sentinel bit, rather than being stored in the lower bits.
+//
+//	|63-----------------------(64-2z)|(63-2z)|(62-2z)-----------0|
+//	| Identify Tile (2 bits per zoom)|   1   |        0          |
+//
+// (Same idea as S2 CellIDs.) The tile identifying bits are identical to QuadKey, so SentinelKeys
+// sort in the same Z-order, but every descendant of a tile sorts within a contiguous range that
+// contains no other valid keys. This means SentinelKey.Range is exact, unlike QuadKey.Range which
+// includes some lower zoom keys that need filtering out with QuadKey.IsAncestorOf.
+type SentinelKey uint64
+
+// SentinelKey converts QuadKey to a SentinelKey
+func (q QuadKey) SentinelKey() SentinelKey {
+	z := q.Zoom()
+	pathMask := ^(^uint64(0) >> (z * 2))
+	return SentinelKey((uint64(q) & pathMask) | lsbForZoom(z))
+}
+
+// QuadKey converts SentinelKey back to a QuadKey
+func (s SentinelKey) QuadKey() QuadKey {
+	lsb := s.lsb()
+	path := uint64(s) &^ (lsb | (lsb - 1))
+	return QuadKey(path | uint64(s.Zoom()))
+}
+
+// Zoom returns the zoom level of the SentinelKey
+func (s SentinelKey) Zoom() byte {
+	return byte((63 - bits.TrailingZeros64(uint64(s))) / 2)
+}
+
+// IsValid returns true if s is a valid SentinelKey (eg. not just any value within a
+// SentinelKeyRange)
+func (s SentinelKey) IsValid() bool {
+	if s == 0 {
+		return false
+	}
+	tz := bits.TrailingZeros64(uint64(s))
+	return (63-tz)%2 == 0 && (63-tz)/2 <= MaxZoom
+}
+
+// Parent returns the parent SentinelKey
+func (s SentinelKey) Parent() (SentinelKey, error) {
+	z := s.Zoom()
+	if z == 0 {
+		return 0, errors.New("no parent")
+	}
+	parentLsb := lsbForZoom(z - 1)
+	return SentinelKey((uint64(s) & -parentLsb) | parentLsb), nil
+}
+
+// ChildAtPos where pos is 0-3 (same positions as QuadKey.ChildAtPos)
+func (s SentinelKey) ChildAtPos(pos int) (SentinelKey, error) {
+	if s.Zoom() >= MaxZoom {
+		return 0, errors.New("maximum zoom reached")
+	}
+	if pos < 0 || pos > 3 {
+		return 0, errors.New("invalid pos")
+	}
+	lsb := s.lsb()
+	return SentinelKey(uint64(s) - lsb + (lsb>>2)*uint64(2*pos+1)), nil
+}
+
+// IsAncestorOf checks whether a SentinelKey is an ancestor of (or equal to) another SentinelKey.
+func (s SentinelKey) IsAncestorOf(desc SentinelKey) bool {
+	return s.Range().Contains(desc)
+}
+
+// lsb returns the sentinel bit
+func (s SentinelKey) lsb() uint64 {
+	return uint64(s) & -uint64(s)
+}
+
+// lsbForZoom returns the sentinel bit for a zoom level
+func lsbForZoom(z byte) uint64 {
+	return uint64(1) << (63 - 2*uint64(z))
+}
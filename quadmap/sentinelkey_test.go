@@ -0,0 +1,93 @@
+package quadmap
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// allQuadKeysToZoom returns every QuadKey from the root down to (and including) zoom
+func allQuadKeysToZoom(zoom byte) []QuadKey {
+	keys := []QuadKey{0}
+	for i := 0; i < len(keys); i++ {
+		if keys[i].Zoom() < zoom {
+			keys = append(keys, keys[i].Children()...)
+		}
+	}
+	return keys
+}
+
+func TestSentinelKeyConversion(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		qk   QuadKey
+		sk   SentinelKey
+	}{
+		{name: "root", qk: 0, sk: 0x8000000000000000},
+		{name: "quadKey", qk: quadKey, sk: 0b1101110110111000000000000000000000000000000000000000000000000000},
+		{name: "parent", qk: parent, sk: 0b1101110110100000000000000000000000000000000000000000000000000000},
+		{name: "child3", qk: Child3, sk: 0b1101110110111110000000000000000000000000000000000000000000000000},
+		{name: "zoom 21", qk: MaxChildZoom21, sk: 0xddbfffffffe00000},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			sk := tc.qk.SentinelKey()
+			assert.Equal(t, tc.sk, sk)
+			assert.True(t, sk.IsValid())
+			assert.Equal(t, tc.qk.Zoom(), sk.Zoom())
+			assert.Equal(t, tc.qk, sk.QuadKey())
+		})
+	}
+}
+
+func TestSentinelKeyIsValid(t *testing.T) {
+	assert.False(t, SentinelKey(0).IsValid())
+	assert.True(t, SentinelKey(0x8000000000000000).IsValid())
+	assert.False(t, SentinelKey(0x4000000000000000).IsValid())
+	assert.False(t, SentinelKey(1).IsValid())
+}
+
+func TestSentinelKeyParentAndChildren(t *testing.T) {
+	for _, qk := range allQuadKeysToZoom(4) {
+		sk := qk.SentinelKey()
+
+		qkParent, qkErr := qk.Parent()
+		skParent, skErr := sk.Parent()
+		if qk.Zoom() == 0 {
+			assert.Error(t, qkErr)
+			assert.Error(t, skErr)
+			continue
+		}
+		require.NoError(t, skErr)
+		assert.Equal(t, qkParent.SentinelKey(), skParent)
+
+		for pos := 0; pos < 4; pos++ {
+			qkChild, err := qk.ChildAtPos(pos)
+			require.NoError(t, err)
+			skChild, err := sk.ChildAtPos(pos)
+			require.NoError(t, err)
+			assert.Equal(t, qkChild.SentinelKey(), skChild)
+		}
+	}
+}
+
+func TestSentinelKeyRangeIsExact(t *testing.T) {
+	keys := allQuadKeysToZoom(4)
+
+	falsePositives := 0
+	for _, a := range keys {
+		sr := a.SentinelKey().Range()
+		qr := a.Range()
+		for _, d := range keys {
+			expected := a.IsAncestorOf(d)
+			assert.Equal(t, expected, sr.Contains(d.SentinelKey()), "ancestor %x desc %x", a, d)
+			assert.Equal(t, expected, a.SentinelKey().IsAncestorOf(d.SentinelKey()))
+			if qr.Contains(d) && !expected {
+				falsePositives++
+			}
+		}
+	}
+
+	// QuadKey.Range has false positives for the same keys, SentinelKey.Range doesn't.
+	assert.Greater(t, falsePositives, 0)
+}
@@ -0,0 +1,237 @@
+package quadmap
+
+// SetOperation is an operation used to combine the coverage of two quadmaps, see Combine
+type SetOperation int
+
+const (
+	SetUnion SetOperation = iota
+	SetIntersection
+	SetDifference
+)
+
+// nodeKind describes the coverage of a tiletype at a quadkey
+type nodeKind int
+
+const (
+	// no coverage at, or below, the quadkey
+	nodeNone nodeKind = iota
+
+	// covered by a full tile (or full ancestor)
+	nodeFull
+
+	// tile is present but not full, and has no descendants so the coverage within it is unknown
+	nodePartialLeaf
+
+	// tile (may or may not be present) isn't full and has descendants with the tiletype
+	nodePartialInner
+)
+
+// Union returns a new quadmap with the areas covered by tileType in either qm or other
+func (qm *QuadMap) Union(other *QuadMap, tileType TileType) *QuadMap {
+	return Combine(qm, tileType, other, tileType, SetUnion, tileType)
+}
+
+// Intersection returns a new quadmap with the areas covered by tileType in both qm and other
+func (qm *QuadMap) Intersection(other *QuadMap, tileType TileType) *QuadMap {
+	return Combine(qm, tileType, other, tileType, SetIntersection, tileType)
+}
+
+// Difference returns a new quadmap with the areas covered by tileType in qm but not in other.
+// eg. the coverage added between snapshot other and snapshot qm.
+func (qm *QuadMap) Difference(other *QuadMap, tileType TileType) *QuadMap {
+	return Combine(qm, tileType, other, tileType, SetDifference, tileType)
+}
+
+// Combine returns a new quadmap containing the result of op on the coverage of aType in a and
+// bType in b, stored as resultType. a and b can be the same quadmap, eg. to find the areas that
+// have TrueOrtho but not DSM:
+//
+//	Combine(qm, TileTypeTrueOrtho, qm, TileTypeDSM, SetDifference, TileTypeTrueOrtho)
+//
+// Full flags (including those inherited from ancestors) are respected. Tiles that aren't full
+// and have no descendants only say that "some" of the tile is covered, so combining them gives
+// a tile that isn't full either (eg. a full tile minus a partial tile is partial).
+// The result is compacted (see Compact) and every tile has its ancestors present.
+// Evicted tiles are not reloaded.
+func Combine(a *QuadMap, aType TileType, b *QuadMap, bType TileType, op SetOperation, resultType TileType) *QuadMap {
+	unlock := rlockPair(a, b)
+	defer unlock()
+
+	res := NewQuadMap(0)
+	c := &combiner{
+		a:          operand{qm: a, tileType: aType},
+		b:          operand{qm: b, tileType: bType},
+		op:         op,
+		res:        res,
+		resultType: resultType,
+	}
+
+	root := QuadKey(0)
+	c.combine(root, c.a.kind(root), c.b.kind(root))
+
+	res.Compact(resultType)
+	return res
+}
+
+// rlockPair read locks a and b (once if they're the same quadmap) and returns a function to
+// unlock them. The locks are always taken in QuadMap.id order, otherwise Combine(a, b) and
+// Combine(b, a) running at the same time as writers to a and b could deadlock (a waiting
+// writer blocks new readers).
+func rlockPair(a *QuadMap, b *QuadMap) func() {
+	if a == b {
+		a.lock.RLock()
+		return a.lock.RUnlock
+	}
+	if b.id < a.id {
+		a, b = b, a
+	}
+	a.lock.RLock()
+	b.lock.RLock()
+	return func() {
+		b.lock.RUnlock()
+		a.lock.RUnlock()
+	}
+}
+
+// operand is one side of a Combine. Caller must hold the quadmap's read lock.
+type operand struct {
+	qm       *QuadMap
+	tileType TileType
+}
+
+// kind returns the coverage of quadKey, ignoring ancestors.
+func (o operand) kind(quadKey QuadKey) nodeKind {
+	t, ok := o.qm.quadKeyMap[quadKey]
+	if ok {
+		if hasTileType, isFull := t.HasTileTypeAndFull(o.tileType); hasTileType {
+			if isFull {
+				return nodeFull
+			}
+			if o.hasDescendants(quadKey) {
+				return nodePartialInner
+			}
+			return nodePartialLeaf
+		}
+	}
+
+	if o.hasDescendants(quadKey) {
+		return nodePartialInner
+	}
+	return nodeNone
+}
+
+// hasDescendants returns true if any descendant of quadKey has the tiletype
+func (o operand) hasDescendants(quadKey QuadKey) bool {
+	r := quadKey.Range()
+	found := false
+	o.qm.index.ascendRange(r.Start, r.End, func(qk QuadKey) bool {
+		if qk == quadKey || !quadKey.IsAncestorOf(qk) {
+			return true
+		}
+		found = o.qm.quadKeyMap[qk].HasTileType(o.tileType)
+		return !found
+	})
+	return found
+}
+
+type combiner struct {
+	a, b       operand
+	op         SetOperation
+	res        *QuadMap
+	resultType TileType
+}
+
+// combine writes the result for quadKey (and its descendants) to the result quadmap.
+// Returns true if there is any coverage at or below quadKey.
+func (c *combiner) combine(quadKey QuadKey, a nodeKind, b nodeKind) bool {
+	switch c.op {
+	case SetUnion:
+		switch {
+		case a == nodeFull || b == nodeFull:
+			return c.emit(quadKey, true)
+		case a == nodeNone && b == nodeNone:
+			return false
+		case a == nodePartialLeaf && b == nodePartialInner:
+			c.recurse(quadKey, nodeNone, b)
+			return c.emit(quadKey, false)
+		case a == nodePartialInner && b == nodePartialLeaf:
+			c.recurse(quadKey, a, nodeNone)
+			return c.emit(quadKey, false)
+		case a == nodePartialLeaf || b == nodePartialLeaf:
+			return c.emit(quadKey, false)
+		}
+
+	case SetIntersection:
+		switch {
+		case a == nodeNone || b == nodeNone:
+			return false
+		case a == nodeFull && b == nodeFull:
+			return c.emit(quadKey, true)
+		case a == nodePartialLeaf || b == nodePartialLeaf:
+			return c.emit(quadKey, false)
+		}
+
+	case SetDifference:
+		switch {
+		case a == nodeNone || b == nodeFull:
+			return false
+		case b == nodeNone && a == nodeFull:
+			return c.emit(quadKey, true)
+		case a == nodePartialLeaf || (a == nodeFull && b == nodePartialLeaf):
+			return c.emit(quadKey, false)
+		case b == nodePartialLeaf:
+			// don't know what the partial tile covers, so keep what a has.
+			b = nodeNone
+		}
+	}
+
+	if !c.recurse(quadKey, a, b) {
+		return false
+	}
+	return c.emit(quadKey, false)
+}
+
+// recurse combines the children of quadKey. Returns true if any child has coverage.
+func (c *combiner) recurse(quadKey QuadKey, a nodeKind, b nodeKind) bool {
+	if quadKey.Zoom() >= MaxZoom {
+		return false
+	}
+
+	covered := false
+	for _, child := range quadKey.Children() {
+		childA := c.childKind(c.a, child, a)
+		childB := c.childKind(c.b, child, b)
+		if c.combine(child, childA, childB) {
+			covered = true
+		}
+	}
+	return covered
+}
+
+// childKind returns the coverage of child given the coverage of its parent
+func (c *combiner) childKind(o operand, child QuadKey, parent nodeKind) nodeKind {
+	switch parent {
+	case nodeFull:
+		return nodeFull
+	case nodePartialInner:
+		return o.kind(child)
+	}
+	return nodeNone
+}
+
+// emit adds resultType to the tile for quadKey in the result. Always returns true (ie there
+// is coverage at quadKey).
+func (c *combiner) emit(quadKey QuadKey, full bool) bool {
+	// only store the root tile if the whole map is covered
+	if quadKey == 0 && !full {
+		return true
+	}
+
+	t, ok := c.res.quadKeyMap[quadKey]
+	if !ok {
+		t = NewTileWithQuadKey(quadKey)
+		c.res.putTileLocked(t)
+	}
+	c.res.addTileTypeLocked(t, c.resultType, full)
+	return true
+}
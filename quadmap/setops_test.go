@@ -0,0 +1,194 @@
+package quadmap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// addTileWithAncestors creates the tile and non-full ancestors (down to zoom 1) for tileType
+func addTileWithAncestors(t *testing.T, qm *QuadMap, x uint32, y uint32, z byte, tileType TileType, full bool) {
+	qk := mustQuadKey(t, x, y, z)
+	for _, a := range ancestorsOf(qk) {
+		if a.Zoom() == 0 {
+			continue
+		}
+		ax, ay, az := a.SlippyCoords()
+		tile, err := qm.GetExactTileForSlippy(ax, ay, az)
+		if err == nil && tile.HasTileType(tileType) {
+			continue
+		}
+		_, err = qm.CreateTileAtSlippyCoords(ax, ay, az, tileType, false)
+		require.NoError(t, err)
+	}
+	_, err := qm.CreateTileAtSlippyCoords(x, y, z, tileType, full)
+	require.NoError(t, err)
+}
+
+// fullCoverageAtZoom returns which tiles at zoom are fully covered for tileType
+func fullCoverageAtZoom(t *testing.T, qm *QuadMap, tileType TileType, zoom byte) map[QuadKey]bool {
+	covered := make(map[QuadKey]bool)
+	for _, qk := range QuadKey(0).GetAllPossibleChildrenAtZoom(zoom) {
+		_, isFull, err := fullAncestorOrSelf(qm.lookupTile, qk, tileType)
+		require.NoError(t, err)
+		covered[qk] = isFull
+	}
+	return covered
+}
+
+func TestSetOperations(t *testing.T) {
+	a := NewQuadMap(10)
+	addTileWithAncestors(t, a, 1, 1, 2, TileTypeVert, true)
+	addTileWithAncestors(t, a, 8, 8, 4, TileTypeVert, true)
+	addTileWithAncestors(t, a, 20, 20, 5, TileTypeVert, true)
+
+	b := NewQuadMap(10)
+	addTileWithAncestors(t, b, 2, 2, 3, TileTypeVert, true)
+	addTileWithAncestors(t, b, 9, 8, 4, TileTypeVert, true)
+	addTileWithAncestors(t, b, 16, 16, 5, TileTypeVert, true)
+	addTileWithAncestors(t, b, 40, 40, 6, TileTypeVert, true)
+
+	zoom := byte(6)
+	coverageA := fullCoverageAtZoom(t, a, TileTypeVert, zoom)
+	coverageB := fullCoverageAtZoom(t, b, TileTypeVert, zoom)
+
+	for _, tc := range []struct {
+		name   string
+		result *QuadMap
+		expect func(a, b bool) bool
+	}{
+		{"union", a.Union(b, TileTypeVert), func(a, b bool) bool { return a || b }},
+		{"intersection", a.Intersection(b, TileTypeVert), func(a, b bool) bool { return a && b }},
+		{"difference", a.Difference(b, TileTypeVert), func(a, b bool) bool { return a && !b }},
+		{"reverse difference", b.Difference(a, TileTypeVert), func(a, b bool) bool { return b && !a }},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			coverage := fullCoverageAtZoom(t, tc.result, TileTypeVert, zoom)
+			for qk, covered := range coverage {
+				assert.Equal(t, tc.expect(coverageA[qk], coverageB[qk]), covered, "quadkey %x", qk)
+			}
+
+			// normalized, so compacting again does nothing
+			assert.Equal(t, 0, tc.result.Compact(TileTypeVert))
+
+			// all tiles have their ancestors
+			tiles, err := tc.result.GetAllTiles(false)
+			require.NoError(t, err)
+			for _, tile := range tiles {
+				for _, ancestor := range ancestorsOf(tile.QuadKey) {
+					if ancestor.Zoom() == 0 {
+						continue
+					}
+					at, err := tc.result.GetExactTileForQuadKey(ancestor)
+					require.NoError(t, err)
+					assert.True(t, at.HasTileType(TileTypeVert))
+				}
+			}
+		})
+	}
+
+	// the union of 1,1,2 and 2,2,3 is just 1,1,2
+	union := a.Union(b, TileTypeVert)
+	_, err := union.GetExactTileForSlippy(2, 2, 3)
+	assert.ErrorIs(t, err, TileNotFoundError)
+
+	// 1,1,2 minus 2,2,3 is split into the other 3 children of 1,1,2
+	diff := a.Difference(b, TileTypeVert)
+	for _, c := range [][2]uint32{{3, 2}, {2, 3}, {3, 3}} {
+		tile, err := diff.GetExactTileForSlippy(c[0], c[1], 3)
+		require.NoError(t, err)
+		_, isFull := tile.HasTileTypeAndFull(TileTypeVert)
+		assert.True(t, isFull)
+	}
+}
+
+func TestSetOperationsPartialTiles(t *testing.T) {
+	a := NewQuadMap(10)
+	addTileWithAncestors(t, a, 4, 4, 4, TileTypeVert, false)
+	addTileWithAncestors(t, a, 12, 12, 4, TileTypeVert, true)
+
+	b := NewQuadMap(10)
+	addTileWithAncestors(t, b, 2, 2, 3, TileTypeVert, true)
+	addTileWithAncestors(t, b, 24, 24, 5, TileTypeVert, false)
+
+	isPartial := func(qm *QuadMap, x, y uint32, z byte) bool {
+		tile, err := qm.GetExactTileForSlippy(x, y, z)
+		if err != nil {
+			return false
+		}
+		hasTileType, isFull := tile.HasTileTypeAndFull(TileTypeVert)
+		return hasTileType && !isFull
+	}
+
+	// partial tile within a full tile is still partial for intersection
+	intersection := a.Intersection(b, TileTypeVert)
+	assert.True(t, isPartial(intersection, 4, 4, 4))
+	assert.True(t, isPartial(intersection, 24, 24, 5))
+	assert.Equal(t, 4+5, intersection.NumberOfTiles())
+
+	// partial tile removed by full tile, full tile minus partial tile is partial
+	diff := a.Difference(b, TileTypeVert)
+	_, err := diff.GetExactTileForSlippy(4, 4, 4)
+	assert.ErrorIs(t, err, TileNotFoundError)
+	assert.True(t, isPartial(diff, 12, 12, 4))
+	assert.True(t, isPartial(diff, 24, 24, 5))
+	for _, c := range [][2]uint32{{25, 24}, {24, 25}, {25, 25}} {
+		tile, err := diff.GetExactTileForSlippy(c[0], c[1], 5)
+		require.NoError(t, err)
+		_, isFull := tile.HasTileTypeAndFull(TileTypeVert)
+		assert.True(t, isFull)
+	}
+
+	union := a.Union(b, TileTypeVert)
+	_, isFull, err := fullAncestorOrSelf(union.lookupTile, mustQuadKey(t, 4, 4, 4), TileTypeVert)
+	require.NoError(t, err)
+	assert.True(t, isFull)
+	_, isFull, err = fullAncestorOrSelf(union.lookupTile, mustQuadKey(t, 24, 24, 5), TileTypeVert)
+	require.NoError(t, err)
+	assert.True(t, isFull)
+}
+
+func TestCombineTileTypes(t *testing.T) {
+	qm := NewQuadMap(10)
+	addTileWithAncestors(t, qm, 1, 1, 2, TileTypeTrueOrtho, true)
+	addTileWithAncestors(t, qm, 2, 2, 3, TileTypeDSM, true)
+
+	result := Combine(qm, TileTypeTrueOrtho, qm, TileTypeDSM, SetDifference, TileTypeTrueOrtho)
+	tiles := result.GetTilesForTypeAndZoom(TileTypeTrueOrtho, 3)
+	assert.Len(t, tiles, 3)
+	assert.Empty(t, result.GetTilesForTypeAndZoom(TileTypeDSM, 3))
+
+	covered, _, err := fullAncestorOrSelf(result.lookupTile, mustQuadKey(t, 2, 2, 3), TileTypeTrueOrtho)
+	require.NoError(t, err)
+	assert.Equal(t, QuadKey(0), covered)
+}
+
+// TestCombineLockOrder checks Combine read locks its operands in the same order (by id)
+// whichever way round they're passed, so Combine(a, b) and Combine(b, a) running at the same
+// time as writers can't deadlock
+func TestCombineLockOrder(t *testing.T) {
+	a := NewQuadMap(10)
+	b := NewQuadMap(10)
+	require.Less(t, a.id, b.id)
+
+	for _, operands := range [][2]*QuadMap{{a, b}, {b, a}} {
+		// with b's lock held, Combine should block while holding a's read lock
+		b.lock.Lock()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			Combine(operands[0], TileTypeVert, operands[1], TileTypeVert, SetUnion, TileTypeVert)
+		}()
+		assert.Eventually(t, func() bool {
+			if a.lock.TryLock() {
+				a.lock.Unlock()
+				return false
+			}
+			return true
+		}, 5*time.Second, time.Millisecond, "Combine should read lock a first")
+		b.lock.Unlock()
+		<-done
+	}
+}
@@ -0,0 +1,430 @@
+package quadmap
+
+import (
+	"errors"
+	"io"
+	"maps"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/peterstace/simplefeatures/geom"
+)
+
+const (
+	// MaxShardZoom is the maximum zoom level a ShardedQuadMap can be sharded at (4^6 = 4096 shards)
+	MaxShardZoom = 6
+)
+
+var InvalidShardZoomError = errors.New("invalid shard zoom level")
+
+// ShardedQuadMap is a QuadMap split into shards, one per tile at shardZoom (tiles are stored in
+// the shard of their ancestor at shardZoom). Each shard has its own lock, so goroutines adding
+// tiles to different regions don't contend with each other (eg. when bulk loading).
+// Tiles above shardZoom (ie larger tiles) are kept in a separate shard.
+//
+// Supports the same adding/lookup/coverage/removal API as QuadMap. Compaction, set operations
+// and eviction aren't supported, use ToQuadMap once loading is complete if those are needed.
+type ShardedQuadMap struct {
+	shardZoom byte
+
+	// shards indexed by the path bits of the ancestor at shardZoom
+	shards []*QuadMap
+
+	// tiles with a zoom lower than shardZoom
+	top *QuadMap
+
+	// function able to take byte slices and populate the quadmap.
+	dataReader DataReader
+}
+
+// NewShardedQuadMap creates a new ShardedQuadMap, sharded by the tiles at shardZoom.
+// initialCapacity is the total expected number of tiles (split evenly between the shards).
+// Returns InvalidShardZoomError if shardZoom isn't between MinZoom and MaxShardZoom.
+func NewShardedQuadMap(shardZoom byte, initialCapacity int) (*ShardedQuadMap, error) {
+	if shardZoom < MinZoom || shardZoom > MaxShardZoom {
+		return nil, InvalidShardZoomError
+	}
+
+	numShards := 1 << (2 * shardZoom)
+	sqm := &ShardedQuadMap{
+		shardZoom: shardZoom,
+		shards:    make([]*QuadMap, numShards),
+		top:       NewQuadMap(0),
+	}
+	for i := range sqm.shards {
+		sqm.shards[i] = NewQuadMap(initialCapacity / numShards)
+	}
+	return sqm, nil
+}
+
+// ShardZoom returns the zoom level the quadmap is sharded at
+func (sqm *ShardedQuadMap) ShardZoom() byte {
+	return sqm.shardZoom
+}
+
+// shardFor returns the shard that holds (or would hold) the tile for quadKey
+func (sqm *ShardedQuadMap) shardFor(quadKey QuadKey) *QuadMap {
+	if quadKey.Zoom() < sqm.shardZoom {
+		return sqm.top
+	}
+	return sqm.shards[uint64(quadKey)>>(64-2*uint64(sqm.shardZoom))]
+}
+
+// allShards returns every shard, including the shard for tiles above shardZoom
+func (sqm *ShardedQuadMap) allShards() []*QuadMap {
+	return append([]*QuadMap{sqm.top}, sqm.shards...)
+}
+
+// lookupTile returns the tile for the quadkey or TileNotFoundError
+func (sqm *ShardedQuadMap) lookupTile(quadKey QuadKey) (*Tile, error) {
+	return sqm.shardFor(quadKey).lookupTile(quadKey)
+}
+
+// tileMetadata returns the metadata for tileType on t, see QuadMap.tileMetadata
+func (sqm *ShardedQuadMap) tileMetadata(t *Tile, tileType TileType) []TileMetadata {
+	return sqm.shardFor(t.QuadKey).tileMetadata(t, tileType)
+}
+
+// SetDataReader sets the data reader for the quadmap
+func (sqm *ShardedQuadMap) SetDataReader(dr DataReader) {
+	sqm.dataReader = dr
+}
+
+// ReadData populates the quadmap from data using the DataReader set with SetDataReader.
+// The DataReader populates a QuadMap, so the data is read into a temporary QuadMap first and
+// its tiles (and metadata) are then added to the shards.
+func (sqm *ShardedQuadMap) ReadData(data *[]byte, tileType TileType) error {
+	if sqm.dataReader == nil {
+		return errors.New("no data reader set")
+	}
+	qm := NewQuadMap(0)
+	if err := sqm.dataReader(qm, data, tileType); err != nil {
+		return err
+	}
+	for qk, t := range qm.quadKeyMap {
+		sqm.shardFor(qk).mergeTile(t, qm.metadata[qk])
+	}
+	return nil
+}
+
+// AddTile adds a pre-generated tile (which has its quadkey already)
+func (sqm *ShardedQuadMap) AddTile(t *Tile) error {
+	return sqm.shardFor(t.QuadKey).AddTile(t)
+}
+
+// CreateTileAtSlippyCoords creates a tile to the quadmap at slippy coords
+func (sqm *ShardedQuadMap) CreateTileAtSlippyCoords(x uint32, y uint32, z byte, tileType TileType, full bool) (*Tile, error) {
+	quadKey, err := GenerateQuadKeyIndexFromSlippy(x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	return sqm.shardFor(quadKey).createTile(quadKey, tileType, full)
+}
+
+// AddTileMetadata adds md to the tile for quadKey, see QuadMap.AddTileMetadata
+func (sqm *ShardedQuadMap) AddTileMetadata(quadKey QuadKey, md TileMetadata) error {
+	return sqm.shardFor(quadKey).AddTileMetadata(quadKey, md)
+}
+
+// GetTileMetadata returns the metadata for tileType on the tile for quadKey
+func (sqm *ShardedQuadMap) GetTileMetadata(quadKey QuadKey, tileType TileType) ([]TileMetadata, error) {
+	return sqm.shardFor(quadKey).GetTileMetadata(quadKey, tileType)
+}
+
+// RemoveTile removes the tile for quadKey, see QuadMap.RemoveTile
+func (sqm *ShardedQuadMap) RemoveTile(quadKey QuadKey) error {
+	return sqm.shardFor(quadKey).RemoveTile(quadKey)
+}
+
+// RemoveTileTypeForSubtree removes tileType from the tile for quadKey and all of its descendants,
+// see QuadMap.RemoveTileTypeForSubtree. The fix ups can touch tiles in several shards, so every
+// shard is locked while the tiles are removed.
+func (sqm *ShardedQuadMap) RemoveTileTypeForSubtree(quadKey QuadKey, tileType TileType) error {
+	shards := sqm.allShards()
+	for _, shard := range shards {
+		shard.lock.Lock()
+	}
+	defer func() {
+		for _, shard := range shards {
+			shard.lock.Unlock()
+		}
+	}()
+
+	subtree := []*QuadMap{sqm.shardFor(quadKey)}
+	if quadKey.Zoom() < sqm.shardZoom {
+		subtree = shards
+	}
+	return removeTileTypeForSubtreeLocked(sqm.shardFor, subtree, quadKey, tileType)
+}
+
+// ReadBinary adds the tiles serialised by EncodeTiles/MarshalBinary, see BinaryDataReader
+func (sqm *ShardedQuadMap) ReadBinary(data *[]byte, tileType TileType) error {
+	tiles, metadata, err := decodeTilesForTileType(data, tileType)
+	if err != nil {
+		return err
+	}
+	for _, t := range tiles {
+		sqm.shardFor(t.QuadKey).mergeTile(t, metadata[t.QuadKey])
+	}
+	return nil
+}
+
+// WriteBinary writes all tiles in the quadmap (and their metadata) to w, in the same format as
+// QuadMap.WriteBinary. Each shard is snapshotted in turn.
+func (sqm *ShardedQuadMap) WriteBinary(w io.Writer) error {
+	var tiles []*Tile
+	metadata := make(map[QuadKey][]TileMetadata)
+	for _, shard := range sqm.allShards() {
+		shardTiles, shardMetadata := shard.snapshotWithMetadata()
+		tiles = append(tiles, shardTiles...)
+		maps.Copy(metadata, shardMetadata)
+	}
+	return encodeTiles(w, tiles, metadata)
+}
+
+// GetExactTileForSlippy returns tile for slippy co-ord match. Does NOT traverse up the ancestry
+func (sqm *ShardedQuadMap) GetExactTileForSlippy(x uint32, y uint32, z byte) (*Tile, error) {
+	quadKey, err := GenerateQuadKeyIndexFromSlippy(x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	return sqm.GetExactTileForQuadKey(quadKey)
+}
+
+// GetExactTileForQuadKey returns tile for quadkey match. Does NOT traverse up the ancestry
+func (sqm *ShardedQuadMap) GetExactTileForQuadKey(quadKey QuadKey) (*Tile, error) {
+	return sqm.lookupTile(quadKey)
+}
+
+// GetTileForSlippyAndTileType returns the tile for the slippy coords if it has tileType
+func (sqm *ShardedQuadMap) GetTileForSlippyAndTileType(x uint32, y uint32, z byte, tt TileType) (*Tile, error) {
+	quadKey, err := GenerateQuadKeyIndexFromSlippy(x, y, z)
+	if err != nil {
+		return nil, err
+	}
+	return sqm.shardFor(quadKey).GetTileForSlippyAndTileType(x, y, z, tt)
+}
+
+// GetParentTile returns parent tile of passed in tile t
+func (sqm *ShardedQuadMap) GetParentTile(t *Tile) (*Tile, error) {
+	parentKey, err := t.QuadKey.Parent()
+	if err != nil {
+		return nil, err
+	}
+	return sqm.shardFor(parentKey).GetParentTile(t)
+}
+
+// GetChildInPos returns child tile of passed in tile t which is in position pos, see
+// QuadMap.GetChildInPos
+func (sqm *ShardedQuadMap) GetChildInPos(t *Tile, pos int) (*Tile, error) {
+	childKey, err := t.QuadKey.ChildAtPos(pos)
+	if err != nil {
+		return nil, err
+	}
+	return sqm.shardFor(childKey).GetChildInPos(t, pos)
+}
+
+// GetAllChildrenForQuadKeyAndZoom returns all quadkeys for a given zoom level including situations where a parent
+// is marked as full.
+func (sqm *ShardedQuadMap) GetAllChildrenForQuadKeyAndZoom(qk QuadKey, tileType TileType, zoom byte) ([]QuadKey, error) {
+	return getAllChildrenForQuadKeyAndZoom(sqm.lookupTile, qk, tileType, zoom)
+}
+
+// IsTileCoveredForSlippyCoordsAndTileTypeTopDown takes slippy coord, gets all ancestors to see if tile should exist
+// (by checking ancestors + full flag)
+// Also returns the quadkey that covers the co-ord... whether its the actual QK for the co-ordinates
+// or an ancestor that is full
+func (sqm *ShardedQuadMap) IsTileCoveredForSlippyCoordsAndTileTypeTopDown(x uint32, y uint32, z byte, tileType TileType) (bool, QuadKey, error) {
+	return isTileCoveredForSlippyCoordsAndTileTypeTopDown(sqm.lookupTile, x, y, z, tileType)
+}
+
+// CoverageForQuadKeys determines how much of the area represented by quadKeys is covered by
+// tiles of tileType, see QuadMap.CoverageForQuadKeys
+func (sqm *ShardedQuadMap) CoverageForQuadKeys(quadKeys []QuadKey, tileType TileType) (Coverage, []QuadKey, error) {
+	return coverageForQuadKeys(sqm.lookupTile, quadKeys, tileType)
+}
+
+// GetNeighborTiles returns the tiles with tileType that cover the neighbors of tile t, see
+// QuadMap.GetNeighborTiles
+func (sqm *ShardedQuadMap) GetNeighborTiles(t *Tile, tileType TileType) ([]*Tile, error) {
+	return getNeighborTiles(sqm.lookupTile, t, tileType)
+}
+
+// NewestMetadataForSlippy returns the newest metadata for tileType from the tiles that cover the
+// slippy coords, see QuadMap.NewestMetadataForSlippy
+func (sqm *ShardedQuadMap) NewestMetadataForSlippy(x uint32, y uint32, z byte, tileType TileType) (TileMetadata, QuadKey, error) {
+	return newestMetadataForSlippy(sqm.lookupTile, sqm.tileMetadata, x, y, z, tileType)
+}
+
+// NewestMetadataForSlippyInWindow is NewestMetadataForSlippy, only considering metadata that was
+// current during the window
+func (sqm *ShardedQuadMap) NewestMetadataForSlippyInWindow(x uint32, y uint32, z byte, tileType TileType, window TimeWindow) (TileMetadata, QuadKey, error) {
+	return newestMetadataForSlippyInWindow(sqm.lookupTile, sqm.tileMetadata, x, y, z, tileType, window)
+}
+
+// IsTileCoveredAtTime is IsTileCoveredForSlippyCoordsAndTileTypeTopDown as of a given time, see
+// QuadMap.IsTileCoveredAtTime
+func (sqm *ShardedQuadMap) IsTileCoveredAtTime(x uint32, y uint32, z byte, tileType TileType, at time.Time) (bool, QuadKey, error) {
+	return isTileCoveredAtTime(sqm.lookupTile, sqm.tileMetadata, x, y, z, tileType, at)
+}
+
+// CoveredArea returns the total area covered by tileType using the given metric, see
+// QuadMap.CoveredArea
+func (sqm *ShardedQuadMap) CoveredArea(tileType TileType, metric AreaMetric) float64 {
+	return coveredArea(sqm.coveredAreaQuadKeys(tileType), metric)
+}
+
+// CoveredAreaInAOI is CoveredArea but only counts the area within aoi, see
+// QuadMap.CoveredAreaInAOI
+func (sqm *ShardedQuadMap) CoveredAreaInAOI(tileType TileType, metric AreaMetric, aoi geom.Geometry) (float64, error) {
+	return coveredAreaInAOI(sqm.coveredAreaQuadKeys(tileType), metric, aoi)
+}
+
+// coveredAreaQuadKeys returns the quadkeys of the tiles that make up the area covered by
+// tileType. A full tile in one shard can cover tiles in another, so all shards are checked
+// together.
+func (sqm *ShardedQuadMap) coveredAreaQuadKeys(tileType TileType) []QuadKey {
+	tiles := make(map[QuadKey]*Tile, sqm.NumberOfTiles())
+	sqm.Range(func(t *Tile) bool {
+		tiles[t.QuadKey] = t
+		return true
+	})
+	return coveredAreaQuadKeys(tiles, tileType)
+}
+
+// TilesInRanges returns all tiles with tileType whose quadkeys fall within ranges, see
+// QuadMap.TilesInRanges
+func (sqm *ShardedQuadMap) TilesInRanges(ranges []QuadKeyRange, tileType TileType) ([]*Tile, error) {
+	tiles := []*Tile{}
+	for i, shard := range sqm.allShards() {
+		shardRanges := ranges
+		if i > 0 {
+			shardRanges = sqm.overlappingRanges(i-1, ranges)
+		}
+		if len(shardRanges) == 0 {
+			continue
+		}
+		shardTiles, err := shard.TilesInRanges(shardRanges, tileType)
+		if err != nil {
+			return nil, err
+		}
+		tiles = append(tiles, shardTiles...)
+	}
+
+	sort.Slice(tiles, func(i, j int) bool {
+		return tiles[i].QuadKey < tiles[j].QuadKey
+	})
+	return tiles, nil
+}
+
+// overlappingRanges returns the ranges that overlap the shard at index i
+func (sqm *ShardedQuadMap) overlappingRanges(i int, ranges []QuadKeyRange) []QuadKeyRange {
+	shardKey := QuadKey(uint64(i)<<(64-2*uint64(sqm.shardZoom)) | uint64(sqm.shardZoom))
+	shardRange := shardKey.Range()
+
+	var overlapping []QuadKeyRange
+	for _, r := range ranges {
+		if r.End >= shardRange.Start && r.Start <= shardRange.End {
+			overlapping = append(overlapping, r)
+		}
+	}
+	return overlapping
+}
+
+// GetAllTiles returns all tiles in the quadmap, optionally sorted by quadkey
+func (sqm *ShardedQuadMap) GetAllTiles(sorted bool) ([]*Tile, error) {
+	var allTiles []*Tile
+	for _, shard := range sqm.allShards() {
+		allTiles = append(allTiles, shard.snapshotTiles()...)
+	}
+
+	if sorted {
+		sort.Slice(allTiles, func(i, j int) bool {
+			return allTiles[i].QuadKey < allTiles[j].QuadKey
+		})
+	}
+	return allTiles, nil
+}
+
+// Range calls f for each tile in the quadmap (in no particular order) until f returns false.
+// Each shard is snapshotted in turn, see QuadMap.Range
+func (sqm *ShardedQuadMap) Range(f func(t *Tile) bool) {
+	for _, shard := range sqm.allShards() {
+		for _, t := range shard.snapshotTiles() {
+			if !f(t) {
+				return
+			}
+		}
+	}
+}
+
+// NumberOfTiles returns number of tiles in quadmap
+func (sqm *ShardedQuadMap) NumberOfTiles() int {
+	count := 0
+	for _, shard := range sqm.allShards() {
+		count += shard.NumberOfTiles()
+	}
+	return count
+}
+
+// NumberOfTilesForZoom returns number of tiles for a given zoom level
+func (sqm *ShardedQuadMap) NumberOfTilesForZoom(zoom byte) int {
+	if zoom < sqm.shardZoom {
+		return sqm.top.NumberOfTilesForZoom(zoom)
+	}
+
+	count := 0
+	for _, shard := range sqm.shards {
+		count += shard.NumberOfTilesForZoom(zoom)
+	}
+	return count
+}
+
+// GetTilesForTypeAndZoom gets tiles for a given tile type and zoom level
+func (sqm *ShardedQuadMap) GetTilesForTypeAndZoom(tt TileType, zoom byte) []*Tile {
+	if zoom < sqm.shardZoom {
+		return sqm.top.GetTilesForTypeAndZoom(tt, zoom)
+	}
+
+	tiles := []*Tile{}
+	for _, shard := range sqm.shards {
+		tiles = append(tiles, shard.GetTilesForTypeAndZoom(tt, zoom)...)
+	}
+	return tiles
+}
+
+// GetSlippyBoundsForTileTypeAndZoom returns minX, minY, maxX, maxY slippy coords for a given tiletype and
+// zoom level
+func (sqm *ShardedQuadMap) GetSlippyBoundsForTileTypeAndZoom(tileType TileType, zoom byte) (uint32, uint32, uint32, uint32, error) {
+	var minX uint32 = math.MaxUint32
+	var minY uint32 = math.MaxUint32
+	var maxX uint32 = 0
+	var maxY uint32 = 0
+
+	for _, shard := range sqm.allShards() {
+		shardMinX, shardMinY, shardMaxX, shardMaxY, err := shard.GetSlippyBoundsForTileTypeAndZoom(tileType, zoom)
+		if err != nil {
+			return 0, 0, 0, 0, err
+		}
+		minX = min(minX, shardMinX)
+		minY = min(minY, shardMinY)
+		maxX = max(maxX, shardMaxX)
+		maxY = max(maxY, shardMaxY)
+	}
+	return minX, minY, maxX, maxY, nil
+}
+
+// ToQuadMap returns a QuadMap containing copies of all tiles (and their metadata) in the sharded
+// quadmap, so changes made through either quadmap afterwards don't affect the other.
+func (sqm *ShardedQuadMap) ToQuadMap() *QuadMap {
+	qm := NewQuadMap(sqm.NumberOfTiles())
+	for _, shard := range sqm.allShards() {
+		tiles, metadata := shard.snapshotWithMetadata()
+		for _, t := range tiles {
+			qm.putTileLocked(t)
+			qm.addMetadataLocked(t.QuadKey, metadata[t.QuadKey])
+		}
+	}
+	return qm
+}
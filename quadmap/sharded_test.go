@@ -0,0 +1,267 @@
+package quadmap
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewShardedQuadMap(t *testing.T) {
+	_, err := NewShardedQuadMap(0, 10)
+	assert.ErrorIs(t, err, InvalidShardZoomError)
+	_, err = NewShardedQuadMap(MaxShardZoom+1, 10)
+	assert.ErrorIs(t, err, InvalidShardZoomError)
+
+	sqm, err := NewShardedQuadMap(3, 10)
+	require.NoError(t, err)
+	assert.Equal(t, byte(3), sqm.ShardZoom())
+	assert.Len(t, sqm.shards, 64)
+}
+
+// TestShardedQuadMapMatchesQuadMap confirms the sharded quadmap gives the same results as a QuadMap
+// with the same tiles, including for queries that cross shards.
+func TestShardedQuadMapMatchesQuadMap(t *testing.T) {
+	qm := populatedQuadMap(t)
+	_, err := qm.CreateTileAtSlippyCoords(2, 2, 2, TileTypeDSM, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(4, 5, 3, TileTypeDSM, false)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(9, 10, 4, TileTypeDSM, true)
+	require.NoError(t, err)
+
+	data, err := qm.MarshalBinary()
+	require.NoError(t, err)
+	sqm, err := NewShardedQuadMap(3, 10)
+	require.NoError(t, err)
+	require.NoError(t, sqm.ReadBinary(&data, 0))
+
+	assert.Equal(t, qm.NumberOfTiles(), sqm.NumberOfTiles())
+	for z := byte(1); z <= 16; z++ {
+		assert.Equal(t, qm.NumberOfTilesForZoom(z), sqm.NumberOfTilesForZoom(z), "zoom %d", z)
+		assert.ElementsMatch(t, qm.GetTilesForTypeAndZoom(TileTypeDSM, z), sqm.GetTilesForTypeAndZoom(TileTypeDSM, z), "zoom %d", z)
+	}
+
+	expected, _ := qm.GetAllTiles(true)
+	actual, _ := sqm.GetAllTiles(true)
+	assert.Equal(t, expected, actual)
+
+	for _, tc := range []struct {
+		x, y uint32
+		z    byte
+	}{
+		{x: 36, y: 40, z: 6},
+		{x: 1, y: 1, z: 1},
+		{x: 5, y: 5, z: 5},
+		{x: 0, y: 0, z: 5},
+		{x: 19, y: 21, z: 5},
+	} {
+		covered, coveredKey, err := qm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(tc.x, tc.y, tc.z, TileTypeDSM)
+		assert.NoError(t, err)
+		shardedCovered, shardedCoveredKey, err := sqm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(tc.x, tc.y, tc.z, TileTypeDSM)
+		assert.NoError(t, err)
+		assert.Equal(t, covered, shardedCovered)
+		assert.Equal(t, coveredKey, shardedCoveredKey)
+
+		children, err := qm.GetAllChildrenForQuadKeyAndZoom(mustQuadKey(t, 1, 1, 1), TileTypeDSM, 5)
+		assert.NoError(t, err)
+		shardedChildren, err := sqm.GetAllChildrenForQuadKeyAndZoom(mustQuadKey(t, 1, 1, 1), TileTypeDSM, 5)
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, children, shardedChildren)
+	}
+
+	ranges := []QuadKeyRange{mustQuadKey(t, 1, 1, 1).Range()}
+	tiles, err := qm.TilesInRanges(ranges, TileTypeDSM)
+	assert.NoError(t, err)
+	shardedTiles, err := sqm.TilesInRanges(ranges, TileTypeDSM)
+	assert.NoError(t, err)
+	assert.Equal(t, tiles, shardedTiles)
+
+	minX, minY, maxX, maxY, err := qm.GetSlippyBoundsForTileTypeAndZoom(TileTypeDSM, 5)
+	assert.NoError(t, err)
+	sMinX, sMinY, sMaxX, sMaxY, err := sqm.GetSlippyBoundsForTileTypeAndZoom(TileTypeDSM, 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []uint32{minX, minY, maxX, maxY}, []uint32{sMinX, sMinY, sMaxX, sMaxY})
+
+	tile, err := sqm.GetExactTileForSlippy(9, 10, 4)
+	require.NoError(t, err)
+	parent, err := sqm.GetParentTile(tile)
+	assert.NoError(t, err)
+	assert.Equal(t, mustQuadKey(t, 4, 5, 3), parent.QuadKey, "Parent is in a different shard")
+	child, err := sqm.GetChildInPos(parent, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, tile, child)
+
+	merged, _ := sqm.ToQuadMap().GetAllTiles(true)
+	assert.Equal(t, expected, merged)
+}
+
+func TestShardedQuadMapConcurrentAdd(t *testing.T) {
+	sqm, err := NewShardedQuadMap(2, 1000)
+	require.NoError(t, err)
+
+	var wg sync.WaitGroup
+	for w := uint32(0); w < 8; w++ {
+		wg.Add(1)
+		go func(w uint32) {
+			defer wg.Done()
+			for i := uint32(0); i < 100; i++ {
+				_, err := sqm.CreateTileAtSlippyCoords(w*100+i, i, 10, TileTypeVert, true)
+				assert.NoError(t, err)
+				sqm.IsTileCoveredForSlippyCoordsAndTileTypeTopDown(i, w*100+i, 10, TileTypeVert)
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	assert.Equal(t, 800, sqm.NumberOfTiles())
+	count := 0
+	sqm.Range(func(tile *Tile) bool {
+		count++
+		return true
+	})
+	assert.Equal(t, 800, count)
+}
+
+// shardedCopy returns a ShardedQuadMap (sharded at zoom 3) with the same tiles as qm
+func shardedCopy(t *testing.T, qm *QuadMap) *ShardedQuadMap {
+	data, err := qm.MarshalBinary()
+	require.NoError(t, err)
+	sqm, err := NewShardedQuadMap(3, 10)
+	require.NoError(t, err)
+	sqm.SetDataReader(BinaryDataReader)
+	require.NoError(t, sqm.ReadData(&data, 0))
+	return sqm
+}
+
+// TestShardedQuadMapMatchesQuadMapAcrossShards checks the queries that follow full ancestors and
+// neighbors into other shards, and removals that have to fix up tiles in several shards.
+func TestShardedQuadMapMatchesQuadMapAcrossShards(t *testing.T) {
+	captured := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 2, TileTypeVert, true)
+	require.NoError(t, err)
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 2), TileMetadata{TileType: TileTypeVert, CaptureDate: captured}))
+	_, err = qm.CreateTileAtSlippyCoords(1, 1, 1, TileTypeVert, false)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(16, 15, 5, TileTypeVert, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(40, 40, 6, TileTypeVert, false)
+	require.NoError(t, err)
+	sqm := shardedCopy(t, qm)
+
+	tile, err := sqm.GetExactTileForSlippy(16, 15, 5)
+	require.NoError(t, err)
+	neighbors, err := qm.GetNeighborTiles(tile, TileTypeVert)
+	require.NoError(t, err)
+	shardedNeighbors, err := sqm.GetNeighborTiles(tile, TileTypeVert)
+	require.NoError(t, err)
+	assert.Equal(t, neighbors, shardedNeighbors)
+
+	for _, qk := range []QuadKey{mustQuadKey(t, 12, 12, 5), mustQuadKey(t, 1, 1, 1), mustQuadKey(t, 15, 15, 5)} {
+		coverage, keys, err := qm.CoverageForQuadKeys([]QuadKey{qk}, TileTypeVert)
+		require.NoError(t, err)
+		shardedCoverage, shardedKeys, err := sqm.CoverageForQuadKeys([]QuadKey{qk}, TileTypeVert)
+		require.NoError(t, err)
+		assert.Equal(t, coverage, shardedCoverage, qk.String())
+		assert.ElementsMatch(t, keys, shardedKeys, qk.String())
+	}
+
+	md, mdKey, err := sqm.NewestMetadataForSlippy(12, 12, 5, TileTypeVert)
+	require.NoError(t, err)
+	assert.Equal(t, captured, md.CaptureDate)
+	assert.Equal(t, mustQuadKey(t, 1, 1, 2), mdKey)
+	covered, coveredKey, err := sqm.IsTileCoveredAtTime(12, 12, 5, TileTypeVert, captured.AddDate(1, 0, 0))
+	require.NoError(t, err)
+	assert.True(t, covered)
+	assert.Equal(t, mustQuadKey(t, 1, 1, 2), coveredKey)
+	covered, _, err = sqm.IsTileCoveredAtTime(12, 12, 5, TileTypeVert, captured.AddDate(-1, 0, 0))
+	require.NoError(t, err)
+	assert.False(t, covered)
+
+	assert.InDelta(t, qm.CoveredArea(TileTypeVert, AreaWebMercator), sqm.CoveredArea(TileTypeVert, AreaWebMercator), 1)
+
+	// removing part of the full tile at 1,1,2 marks siblings in other shards as full, and removing
+	// 1,1,1 has to clear tiles in several shards
+	for _, qk := range []QuadKey{mustQuadKey(t, 12, 12, 5), mustQuadKey(t, 1, 1, 1)} {
+		assert.NoError(t, qm.RemoveTileTypeForSubtree(qk, TileTypeVert))
+		assert.NoError(t, sqm.RemoveTileTypeForSubtree(qk, TileTypeVert))
+
+		expected, _ := qm.GetAllTiles(true)
+		actual, _ := sqm.GetAllTiles(true)
+		assert.Equal(t, expected, actual, qk.String())
+		for z := byte(1); z <= 6; z++ {
+			assert.ElementsMatch(t, qm.GetTilesForTypeAndZoom(TileTypeVert, z), sqm.GetTilesForTypeAndZoom(TileTypeVert, z), "zoom %d", z)
+		}
+	}
+	assert.ErrorIs(t, sqm.RemoveTileTypeForSubtree(mustQuadKey(t, 1, 1, 1), TileTypeVert), TileWithTileTypeNotFound)
+}
+
+func TestShardedQuadMapToQuadMapCopiesTiles(t *testing.T) {
+	sqm, err := NewShardedQuadMap(2, 10)
+	require.NoError(t, err)
+	_, err = sqm.CreateTileAtSlippyCoords(20, 30, 6, TileTypeVert, true)
+	require.NoError(t, err)
+	md := TileMetadata{TileType: TileTypeVert, Scale: 4}
+	require.NoError(t, sqm.AddTileMetadata(mustQuadKey(t, 20, 30, 6), md))
+
+	qm := sqm.ToQuadMap()
+	_, err = sqm.CreateTileAtSlippyCoords(20, 30, 6, TileTypeDSM, true)
+	require.NoError(t, err)
+
+	assert.Len(t, qm.GetTilesForTypeAndZoom(TileTypeDSM, 6), 0)
+	tile, err := qm.GetExactTileForSlippy(20, 30, 6)
+	require.NoError(t, err)
+	assert.False(t, tile.HasTileType(TileTypeDSM))
+	assert.Len(t, qm.GetTilesForTypeAndZoom(TileTypeVert, 6), 1)
+	found, err := qm.GetTileMetadata(mustQuadKey(t, 20, 30, 6), TileTypeVert)
+	require.NoError(t, err)
+	assert.Equal(t, []TileMetadata{md}, found)
+}
+
+// benchmarkParallelCreateTiles creates tiles from parallel goroutines, each writing to its own
+// region of the map. Regions are the tiles at benchmarkShardZoom, so with a ShardedQuadMap each
+// goroutine has its own shard while with a QuadMap they all contend for the same lock.
+func benchmarkParallelCreateTiles(b *testing.B, create func(x, y uint32, z byte) error) {
+	const z = 16
+	const regionBits = z - benchmarkShardZoom
+	var nextRegion atomic.Uint32
+	b.RunParallel(func(pb *testing.PB) {
+		region := (nextRegion.Add(1) - 1) % (1 << (2 * benchmarkShardZoom))
+		rx, ry := region%(1<<benchmarkShardZoom), region>>benchmarkShardZoom
+		i := uint32(0)
+		for pb.Next() {
+			x := rx<<regionBits | i%(1<<regionBits)
+			y := ry<<regionBits | (i>>regionBits)%(1<<regionBits)
+			if err := create(x, y, z); err != nil {
+				b.Fatal(err)
+			}
+			i++
+		}
+	})
+}
+
+const benchmarkShardZoom = 2
+
+// Neither map is given an initial capacity, since the tiles aren't spread evenly over the shards
+func BenchmarkParallelCreateTilesQuadMap(b *testing.B) {
+	qm := NewQuadMap(0)
+	benchmarkParallelCreateTiles(b, func(x, y uint32, z byte) error {
+		_, err := qm.CreateTileAtSlippyCoords(x, y, z, TileTypeVert, true)
+		return err
+	})
+}
+
+func BenchmarkParallelCreateTilesShardedQuadMap(b *testing.B) {
+	sqm, err := NewShardedQuadMap(benchmarkShardZoom, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkParallelCreateTiles(b, func(x, y uint32, z byte) error {
+		_, err := sqm.CreateTileAtSlippyCoords(x, y, z, TileTypeVert, true)
+		return err
+	})
+}
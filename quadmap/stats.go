@@ -0,0 +1,256 @@
+package quadmap
+
+import (
+	"math"
+	"math/bits"
+	"sync"
+)
+
+// tileStats are counters maintained as tiles are added, modified and removed, so common
+// statistics don't need to scan the whole quadmap.
+// Counters are only modified while holding the QuadMap write lock, and only read while holding
+// (at least) the QuadMap read lock.
+// Only counts and bounds are kept, the tiles themselves are found through the quadmap's index.
+// Changes made directly to a Tile in the quadmap (rather than through QuadMap methods) aren't
+// tracked, see QuadMap.RebuildStatistics.
+type tileStats struct {
+	// number of tiles per zoom level
+	zoomCounts [MaxZoom + 1]int
+
+	// per TileType statistics, indexed by TileType bit. nil until the TileType is used.
+	tileTypes [MaxTileTypes]*tileTypeStats
+
+	// guards recalculating bounds, since that happens under the QuadMap read lock
+	boundsLock sync.Mutex
+}
+
+// tileTypeStats are statistics for a single TileType, per zoom level
+type tileTypeStats struct {
+	zooms [MaxZoom + 1]tileTypeZoomStats
+}
+
+// tileTypeZoomStats are statistics for a single TileType and zoom level
+type tileTypeZoomStats struct {
+	// number of tiles with the TileType
+	tiles int
+
+	// number of tiles that are full for the TileType
+	full int
+
+	// slippy bounds of all tiles with the TileType, and of just the full tiles
+	bounds     slippyBounds
+	fullBounds slippyBounds
+
+	// bounds only ever grow as tiles are added. When a tile is removed (or is no longer full)
+	// they're recalculated on next use.
+	boundsDirty bool
+}
+
+// slippyBounds is an inclusive bounding box of slippy coords. Empty if minX > maxX
+type slippyBounds struct {
+	minX, minY, maxX, maxY uint32
+}
+
+func emptySlippyBounds() slippyBounds {
+	return slippyBounds{minX: math.MaxUint32, minY: math.MaxUint32}
+}
+
+func (b *slippyBounds) isEmpty() bool {
+	return b.minX > b.maxX
+}
+
+// extend grows the bounds to include x,y
+func (b *slippyBounds) extend(x, y uint32) {
+	b.minX = min(b.minX, x)
+	b.minY = min(b.minY, y)
+	b.maxX = max(b.maxX, x)
+	b.maxY = max(b.maxY, y)
+}
+
+// union grows the bounds to include other
+func (b *slippyBounds) union(other slippyBounds) {
+	if other.isEmpty() {
+		return
+	}
+	b.extend(other.minX, other.minY)
+	b.extend(other.maxX, other.maxY)
+}
+
+// atZoom converts bounds at zoom level from to (larger) zoom level to
+func (b slippyBounds) atZoom(from, to byte) slippyBounds {
+	if b.isEmpty() {
+		return b
+	}
+	d := to - from
+	return slippyBounds{
+		minX: b.minX << d,
+		minY: b.minY << d,
+		maxX: b.maxX<<d | (1<<d - 1),
+		maxY: b.maxY<<d | (1<<d - 1),
+	}
+}
+
+// tileAdded records a tile being added to the quadmap
+func (s *tileStats) tileAdded(t *Tile) {
+	s.zoomCounts[t.QuadKey.Zoom()]++
+	s.detailsChanged(t, 0, t.LoadDetails())
+}
+
+// tileRemoved records a tile being removed from the quadmap
+func (s *tileStats) tileRemoved(t *Tile) {
+	s.zoomCounts[t.QuadKey.Zoom()]--
+	s.detailsChanged(t, t.LoadDetails(), 0)
+}
+
+// detailsChanged records the details of a tile in the quadmap changing from old to new
+func (s *tileStats) detailsChanged(t *Tile, old uint64, new uint64) {
+	if old == new {
+		return
+	}
+
+	z := t.QuadKey.Zoom()
+	for types := (old | new) >> TileTypeOffset; types != 0; types &= types - 1 {
+		bit := bits.TrailingZeros64(types)
+		tt := uint64(1) << bit
+		hadType := old&(tt<<TileTypeOffset) != 0
+		hasType := new&(tt<<TileTypeOffset) != 0
+		wasFull := hadType && old&tt != 0
+		isFull := hasType && new&tt != 0
+		if hadType == hasType && wasFull == isFull {
+			continue
+		}
+
+		if s.tileTypes[bit] == nil {
+			s.tileTypes[bit] = newTileTypeStats()
+		}
+		zs := &s.tileTypes[bit].zooms[z]
+
+		x, y, _ := t.QuadKey.SlippyCoords()
+		switch {
+		case !hadType && hasType:
+			zs.tiles++
+			zs.bounds.extend(x, y)
+		case hadType && !hasType:
+			zs.tiles--
+			zs.boundsDirty = true
+		}
+		switch {
+		case !wasFull && isFull:
+			zs.full++
+			zs.fullBounds.extend(x, y)
+		case wasFull && !isFull:
+			zs.full--
+			zs.boundsDirty = true
+		}
+	}
+}
+
+func newTileTypeStats() *tileTypeStats {
+	ts := &tileTypeStats{}
+	for z := range ts.zooms {
+		ts.zooms[z].bounds = emptySlippyBounds()
+		ts.zooms[z].fullBounds = emptySlippyBounds()
+	}
+	return ts
+}
+
+// zoomStats returns the statistics for tileType at zoom, or nil if there are none
+func (s *tileStats) zoomStats(tileType TileType, zoom byte) *tileTypeZoomStats {
+	if !tileType.IsValid() || zoom > MaxZoom {
+		return nil
+	}
+	ts := s.tileTypes[bits.TrailingZeros32(uint32(tileType))]
+	if ts == nil {
+		return nil
+	}
+	return &ts.zooms[zoom]
+}
+
+// boundsForZoomLocked returns the bounds (of all tiles, and of full tiles) for zs, the statistics
+// for tileType at zoom, recalculating them if required.
+// Caller must hold (at least) the read lock and qm.stats.boundsLock
+func (qm *QuadMap) boundsForZoomLocked(zs *tileTypeZoomStats, tileType TileType, zoom byte) (slippyBounds, slippyBounds) {
+	if zs.boundsDirty {
+		zs.bounds = emptySlippyBounds()
+		zs.fullBounds = emptySlippyBounds()
+		qm.ascendZoomLocked(zoom, func(t *Tile) {
+			hasTileType, isFull := t.HasTileTypeAndFull(tileType)
+			if !hasTileType {
+				return
+			}
+			x, y, _ := t.QuadKey.SlippyCoords()
+			zs.bounds.extend(x, y)
+			if isFull {
+				zs.fullBounds.extend(x, y)
+			}
+		})
+		zs.boundsDirty = false
+	}
+	return zs.bounds, zs.fullBounds
+}
+
+// ascendZoomLocked calls f for each tile at zoom, in quadkey order.
+// Caller must hold (at least) the read lock
+func (qm *QuadMap) ascendZoomLocked(zoom byte, f func(t *Tile)) {
+	if zoom > MaxZoom || qm.stats.zoomCounts[zoom] == 0 {
+		return
+	}
+	for _, block := range qm.index.blocks {
+		for _, qk := range block {
+			if qk.Zoom() == zoom {
+				f(qm.quadKeyMap[qk])
+			}
+		}
+	}
+}
+
+// NumberOfTilesForTypeAndZoom returns the number of tiles with tileType at a zoom level, and how
+// many of those are full.
+func (qm *QuadMap) NumberOfTilesForTypeAndZoom(tileType TileType, zoom byte) (int, int) {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+
+	zs := qm.stats.zoomStats(tileType, zoom)
+	if zs == nil {
+		return 0, 0
+	}
+	return zs.tiles, zs.full
+}
+
+// NumberOfTilesForType returns the number of tiles with tileType (at any zoom level), and how
+// many of those are full.
+func (qm *QuadMap) NumberOfTilesForType(tileType TileType) (int, int) {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+
+	tiles, full := 0, 0
+	for z := byte(0); z <= MaxZoom; z++ {
+		if zs := qm.stats.zoomStats(tileType, z); zs != nil {
+			tiles += zs.tiles
+			full += zs.full
+		}
+	}
+	return tiles, full
+}
+
+// RebuildStatistics recalculates the statistics used by NumberOfTilesForTypeAndZoom,
+// NumberOfTilesForType and GetSlippyBoundsForTileTypeAndZoom. Only required if tiles in the
+// quadmap have been modified directly (eg. Tile.AddTileType) rather than via QuadMap methods.
+func (qm *QuadMap) RebuildStatistics() {
+	qm.lock.Lock()
+	defer qm.lock.Unlock()
+
+	qm.stats.zoomCounts = [MaxZoom + 1]int{}
+	qm.stats.tileTypes = [MaxTileTypes]*tileTypeStats{}
+	for _, t := range qm.quadKeyMap {
+		qm.stats.tileAdded(t)
+	}
+}
+
+// addTileTypeLocked adds tileType to a tile already in the quadmap, see Tile.AddTileType.
+// Caller must hold the write lock.
+func (qm *QuadMap) addTileTypeLocked(t *Tile, tileType TileType, full bool) {
+	old := t.LoadDetails()
+	t.AddTileType(tileType, full)
+	qm.stats.detailsChanged(t, old, t.LoadDetails())
+}
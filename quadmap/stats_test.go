@@ -0,0 +1,170 @@
+package quadmap
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checkStats compares the statistics of qm against a full scan of the tiles
+func checkStats(t *testing.T, qm *QuadMap) {
+	t.Helper()
+	tiles, err := qm.GetAllTiles(false)
+	require.NoError(t, err)
+
+	for _, tt := range []TileType{TileTypeVert, TileTypeDSM} {
+		for z := byte(1); z <= 12; z++ {
+			expectedTiles, expectedFull := 0, 0
+			zoomTiles := 0
+			minX, minY, maxX, maxY := uint32(math.MaxUint32), uint32(math.MaxUint32), uint32(0), uint32(0)
+			for _, tile := range tiles {
+				tz := tile.QuadKey.Zoom()
+				if tz == z {
+					zoomTiles++
+				}
+				hasTileType, isFull := tile.HasTileTypeAndFull(tt)
+				if !hasTileType {
+					continue
+				}
+				if tz == z {
+					expectedTiles++
+					if isFull {
+						expectedFull++
+					}
+				}
+				if tz == z || (tz < z && isFull) {
+					x, y, _ := tile.QuadKey.SlippyCoords()
+					scale := z - tz
+					minX = min(minX, x<<scale)
+					minY = min(minY, y<<scale)
+					maxX = max(maxX, x<<scale|(1<<scale-1))
+					maxY = max(maxY, y<<scale|(1<<scale-1))
+				}
+			}
+
+			assert.Equal(t, zoomTiles, qm.NumberOfTilesForZoom(z), "zoom %d", z)
+			numTiles, numFull := qm.NumberOfTilesForTypeAndZoom(tt, z)
+			assert.Equal(t, expectedTiles, numTiles, "tiletype %s zoom %d", tt, z)
+			assert.Equal(t, expectedFull, numFull, "tiletype %s zoom %d", tt, z)
+			assert.Len(t, qm.GetTilesForTypeAndZoom(tt, z), expectedTiles)
+
+			bMinX, bMinY, bMaxX, bMaxY, err := qm.GetSlippyBoundsForTileTypeAndZoom(tt, z)
+			require.NoError(t, err)
+			assert.Equal(t, []uint32{minX, minY, maxX, maxY}, []uint32{bMinX, bMinY, bMaxX, bMaxY}, "tiletype %s zoom %d", tt, z)
+		}
+	}
+}
+
+func TestStatsAddTiles(t *testing.T) {
+	qm := NewQuadMap(10)
+	checkStats(t, qm)
+
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 2, TileTypeVert, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(20, 30, 6, TileTypeVert, false)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(20, 30, 6, TileTypeDSM, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(1000, 1200, 12, TileTypeDSM, false)
+	require.NoError(t, err)
+	checkStats(t, qm)
+
+	// not full -> full for an existing tile
+	_, err = qm.CreateTileAtSlippyCoords(20, 30, 6, TileTypeVert, true)
+	require.NoError(t, err)
+	checkStats(t, qm)
+
+	tiles, full := qm.NumberOfTilesForType(TileTypeVert)
+	assert.Equal(t, 2, tiles)
+	assert.Equal(t, 2, full)
+	tiles, full = qm.NumberOfTilesForType(TileTypeDSM)
+	assert.Equal(t, 2, tiles)
+	assert.Equal(t, 1, full)
+
+	// replacing a tile shouldn't count it twice
+	tile, err := NewTileWithTileTypeAndFull(20, 30, 6, TileTypeDSM, false)
+	require.NoError(t, err)
+	require.NoError(t, qm.AddTile(tile))
+	assert.Equal(t, 1, qm.NumberOfTilesForZoom(6))
+	checkStats(t, qm)
+}
+
+func TestStatsRemoveTiles(t *testing.T) {
+	qm := NewQuadMap(10)
+	for i := uint32(0); i < 10; i++ {
+		_, err := qm.CreateTileAtSlippyCoords(100+i, 200+i, 10, TileTypeVert, i%2 == 0)
+		require.NoError(t, err)
+	}
+	checkStats(t, qm)
+
+	// removing the tiles on the edge of the bounds means they need recalculating
+	require.NoError(t, qm.RemoveTile(mustQuadKey(t, 100, 200, 10)))
+	require.NoError(t, qm.RemoveTile(mustQuadKey(t, 109, 209, 10)))
+	checkStats(t, qm)
+
+	minX, minY, maxX, maxY, err := qm.GetSlippyBoundsForTileTypeAndZoom(TileTypeVert, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{101, 201, 108, 208}, []uint32{minX, minY, maxX, maxY})
+
+	// removing a tiletype from part of a full tile splits it
+	_, err = qm.CreateTileAtSlippyCoords(3, 3, 4, TileTypeDSM, true)
+	require.NoError(t, err)
+	require.NoError(t, qm.RemoveTileTypeForSubtree(mustQuadKey(t, 6, 6, 5), TileTypeDSM))
+	checkStats(t, qm)
+}
+
+func TestStatsCompact(t *testing.T) {
+	qm := NewQuadMap(10)
+	parent := mustQuadKey(t, 5, 5, 8)
+	for _, child := range parent.Children() {
+		x, y, z := child.SlippyCoords()
+		_, err := qm.CreateTileAtSlippyCoords(x, y, z, TileTypeVert, true)
+		require.NoError(t, err)
+	}
+	checkStats(t, qm)
+
+	assert.Greater(t, qm.Compact(TileTypeVert), 0)
+	checkStats(t, qm)
+	tiles, full := qm.NumberOfTilesForTypeAndZoom(TileTypeVert, 8)
+	assert.Equal(t, 1, tiles)
+	assert.Equal(t, 1, full)
+}
+
+func TestStatsMultipleTileTypes(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 3, TileTypeVert, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(40, 40, 6, TileTypeDSM, false)
+	require.NoError(t, err)
+
+	minX, minY, maxX, maxY, err := qm.GetSlippyBoundsForTileTypeAndZoom(TileTypeVert|TileTypeDSM, 6)
+	require.NoError(t, err)
+	assert.Equal(t, []uint32{8, 8, 40, 40}, []uint32{minX, minY, maxX, maxY})
+
+	assert.Len(t, qm.GetTilesForTypeAndZoom(TileTypeVert|TileTypeDSM, 6), 1)
+}
+
+func TestRebuildStatistics(t *testing.T) {
+	qm := NewQuadMap(10)
+	tile, err := qm.CreateTileAtSlippyCoords(10, 10, 5, TileTypeVert, false)
+	require.NoError(t, err)
+
+	// modifying the tile directly isn't tracked
+	tile.AddTileType(TileTypeDSM, true)
+	tiles, _ := qm.NumberOfTilesForTypeAndZoom(TileTypeDSM, 5)
+	assert.Equal(t, 0, tiles)
+
+	// but the tiles themselves come from the quadmap, so they are
+	assert.Len(t, qm.GetTilesForTypeAndZoom(TileTypeDSM, 5), 1)
+	tile.RemoveTileType(TileTypeVert)
+	assert.Empty(t, qm.GetTilesForTypeAndZoom(TileTypeVert, 5))
+	tile.AddTileType(TileTypeVert, false)
+
+	qm.RebuildStatistics()
+	tiles, full := qm.NumberOfTilesForTypeAndZoom(TileTypeDSM, 5)
+	assert.Equal(t, 1, tiles)
+	assert.Equal(t, 1, full)
+	checkStats(t, qm)
+}
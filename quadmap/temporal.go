@@ -0,0 +1,114 @@
+package quadmap
+
+import (
+	"errors"
+	"time"
+)
+
+// TimeWindow is an inclusive window of time. A zero Start or End means the window is unbounded
+// in that direction, so the zero TimeWindow matches everything.
+type TimeWindow struct {
+	Start time.Time
+	End   time.Time
+}
+
+// TimeWindowAt returns a TimeWindow for a single point in time
+func TimeWindowAt(at time.Time) TimeWindow {
+	return TimeWindow{Start: at, End: at}
+}
+
+// Contains returns true if t is within the window
+func (w TimeWindow) Contains(t time.Time) bool {
+	if !w.Start.IsZero() && t.Before(w.Start) {
+		return false
+	}
+	if !w.End.IsZero() && t.After(w.End) {
+		return false
+	}
+	return true
+}
+
+// IsCurrentAt returns true if the metadata was captured at or before at, and hadn't been
+// retired by then.
+func (md TileMetadata) IsCurrentAt(at time.Time) bool {
+	if md.CaptureDate.After(at) {
+		return false
+	}
+	return md.RetiredDate.IsZero() || md.RetiredDate.After(at)
+}
+
+// IsCurrentDuring returns true if the metadata was current at any point during the window,
+// ie it was captured before the window ended and wasn't retired before the window started.
+func (md TileMetadata) IsCurrentDuring(w TimeWindow) bool {
+	if !w.End.IsZero() && md.CaptureDate.After(w.End) {
+		return false
+	}
+	if !w.Start.IsZero() && !md.RetiredDate.IsZero() && !md.RetiredDate.After(w.Start) {
+		return false
+	}
+	return true
+}
+
+// IsTileCoveredAtTime is IsTileCoveredForSlippyCoordsAndTileTypeTopDown as of a given time. The
+// tile at x,y,z (or a full ancestor) must have metadata for tileType that was current at that
+// time (see TileMetadata.IsCurrentAt). Tiles without metadata have no dates, so never count.
+// Also returns the quadkey that provides the coverage.
+func (qm *QuadMap) IsTileCoveredAtTime(x uint32, y uint32, z byte, tileType TileType, at time.Time) (bool, QuadKey, error) {
+	return isTileCoveredAtTime(qm.lookupTile, qm.tileMetadata, x, y, z, tileType, at)
+}
+
+func isTileCoveredAtTime(lookup tileLookup, metadata tileMetadataFunc, x uint32, y uint32, z byte, tileType TileType, at time.Time) (bool, QuadKey, error) {
+	quadKey, err := GenerateQuadKeyIndexFromSlippy(x, y, z)
+	if err != nil {
+		return false, 0, err
+	}
+
+	_, qk, err := newestCoveringMetadata(lookup, metadata, quadKey, tileType, func(md TileMetadata) bool {
+		return md.IsCurrentAt(at)
+	})
+	if errors.Is(err, TileWithTileTypeNotFound) {
+		return false, 0, nil
+	}
+	if err != nil {
+		return false, 0, err
+	}
+	return true, qk, nil
+}
+
+// NewestMetadataForSlippyInWindow is NewestMetadataForSlippy, only considering metadata that was
+// current during the window (see TileMetadata.IsCurrentDuring).
+func (qm *QuadMap) NewestMetadataForSlippyInWindow(x uint32, y uint32, z byte, tileType TileType, window TimeWindow) (TileMetadata, QuadKey, error) {
+	return newestMetadataForSlippyInWindow(qm.lookupTile, qm.tileMetadata, x, y, z, tileType, window)
+}
+
+func newestMetadataForSlippyInWindow(lookup tileLookup, metadata tileMetadataFunc, x uint32, y uint32, z byte, tileType TileType, window TimeWindow) (TileMetadata, QuadKey, error) {
+	quadKey, err := GenerateQuadKeyIndexFromSlippy(x, y, z)
+	if err != nil {
+		return TileMetadata{}, 0, err
+	}
+	return newestCoveringMetadata(lookup, metadata, quadKey, tileType, func(md TileMetadata) bool {
+		return md.IsCurrentDuring(window)
+	})
+}
+
+// LatestMetadataPerTile returns the most recently captured metadata for tileType on each tile,
+// only considering metadata that was current during the window. Tiles without any matching
+// metadata are not included.
+// Only tiles in memory are checked (see EvictionPolicy).
+func (qm *QuadMap) LatestMetadataPerTile(tileType TileType, window TimeWindow) map[QuadKey]TileMetadata {
+	qm.lock.RLock()
+	defer qm.lock.RUnlock()
+
+	latest := make(map[QuadKey]TileMetadata)
+	for qk, metadata := range qm.metadata {
+		for _, md := range metadata {
+			if md.TileType != tileType || !md.IsCurrentDuring(window) {
+				continue
+			}
+			if existing, ok := latest[qk]; !ok || md.CaptureDate.After(existing.CaptureDate) {
+				latest[qk] = md
+			}
+		}
+	}
+	return latest
+}
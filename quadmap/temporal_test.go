@@ -0,0 +1,97 @@
+package quadmap
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 0, 0, 0, 0, time.UTC)
+}
+
+func TestTimeWindow(t *testing.T) {
+	w := TimeWindow{Start: date(2020, 1, 1), End: date(2021, 1, 1)}
+	assert.True(t, w.Contains(date(2020, 1, 1)), "Start should be inclusive")
+	assert.True(t, w.Contains(date(2021, 1, 1)), "End should be inclusive")
+	assert.False(t, w.Contains(date(2019, 12, 31)))
+	assert.False(t, w.Contains(date(2021, 1, 2)))
+	assert.True(t, TimeWindow{}.Contains(date(1900, 1, 1)), "Zero window should be unbounded")
+
+	md := TileMetadata{CaptureDate: date(2020, 6, 1), RetiredDate: date(2022, 6, 1)}
+	assert.False(t, md.IsCurrentAt(date(2020, 5, 31)))
+	assert.True(t, md.IsCurrentAt(date(2020, 6, 1)))
+	assert.False(t, md.IsCurrentAt(date(2022, 6, 1)), "Should be retired on the retired date")
+	assert.True(t, md.IsCurrentDuring(w))
+	assert.False(t, md.IsCurrentDuring(TimeWindow{Start: date(2023, 1, 1)}))
+	assert.False(t, md.IsCurrentDuring(TimeWindow{End: date(2019, 1, 1)}))
+	assert.True(t, TileMetadata{CaptureDate: date(2020, 6, 1)}.IsCurrentAt(date(2030, 1, 1)), "Unretired metadata should stay current")
+}
+
+func TestIsTileCoveredAtTime(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 2, TileTypeVert, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(4, 4, 4, TileTypeVert, false)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(0, 0, 4, TileTypeVert, false)
+	require.NoError(t, err)
+
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 2), TileMetadata{TileType: TileTypeVert, CaptureDate: date(2020, 1, 1), RetiredDate: date(2023, 1, 1)}))
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 4, 4, 4), TileMetadata{TileType: TileTypeVert, CaptureDate: date(2024, 1, 1)}))
+
+	for _, tc := range []struct {
+		name      string
+		x, y      uint32
+		at        time.Time
+		covered   bool
+		coveredBy QuadKey
+	}{
+		{name: "before capture", x: 5, y: 5, at: date(2019, 1, 1)},
+		{name: "full ancestor current", x: 5, y: 5, at: date(2021, 1, 1), covered: true, coveredBy: mustQuadKey(t, 1, 1, 2)},
+		{name: "full ancestor retired", x: 5, y: 5, at: date(2024, 6, 1)},
+		{name: "tile itself current", x: 4, y: 4, at: date(2024, 6, 1), covered: true, coveredBy: mustQuadKey(t, 4, 4, 4)},
+		{name: "gap between surveys", x: 4, y: 4, at: date(2023, 6, 1)},
+		{name: "no metadata", x: 0, y: 0, at: date(2024, 6, 1)},
+	} {
+		covered, qk, err := qm.IsTileCoveredAtTime(tc.x, tc.y, 4, TileTypeVert, tc.at)
+		assert.NoError(t, err, tc.name)
+		assert.Equal(t, tc.covered, covered, tc.name)
+		assert.Equal(t, tc.coveredBy, qk, tc.name)
+	}
+}
+
+func TestLatestMetadataPerTile(t *testing.T) {
+	qm := NewQuadMap(10)
+	_, err := qm.CreateTileAtSlippyCoords(1, 1, 2, TileTypeVert, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(1, 1, 2, TileTypeDSM, true)
+	require.NoError(t, err)
+	_, err = qm.CreateTileAtSlippyCoords(3, 3, 2, TileTypeVert, true)
+	require.NoError(t, err)
+
+	old := TileMetadata{TileType: TileTypeVert, CaptureDate: date(2018, 1, 1), RetiredDate: date(2021, 1, 1)}
+	current := TileMetadata{TileType: TileTypeVert, CaptureDate: date(2021, 1, 1)}
+	other := TileMetadata{TileType: TileTypeVert, CaptureDate: date(2019, 1, 1)}
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 2), old))
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 2), current))
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 1, 1, 2), TileMetadata{TileType: TileTypeDSM, CaptureDate: date(2025, 1, 1)}))
+	require.NoError(t, qm.AddTileMetadata(mustQuadKey(t, 3, 3, 2), other))
+
+	assert.Equal(t, map[QuadKey]TileMetadata{
+		mustQuadKey(t, 1, 1, 2): current,
+		mustQuadKey(t, 3, 3, 2): other,
+	}, qm.LatestMetadataPerTile(TileTypeVert, TimeWindow{}))
+
+	assert.Equal(t, map[QuadKey]TileMetadata{
+		mustQuadKey(t, 1, 1, 2): old,
+		mustQuadKey(t, 3, 3, 2): other,
+	}, qm.LatestMetadataPerTile(TileTypeVert, TimeWindowAt(date(2020, 1, 1))))
+
+	md, qk, err := qm.NewestMetadataForSlippyInWindow(1, 1, 2, TileTypeVert, TimeWindow{End: date(2020, 1, 1)})
+	assert.NoError(t, err)
+	assert.Equal(t, old, md)
+	assert.Equal(t, mustQuadKey(t, 1, 1, 2), qk)
+}
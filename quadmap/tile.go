@@ -1,33 +1,33 @@
 package quadmap
 
-type TileType uint16
-
-const (
-	TileTypeVert      TileType = 0b000000000001
-	TileTypeEast      TileType = 0b000000000010
-	TileTypeNorth     TileType = 0b000000000100
-	TileTypeSouth     TileType = 0b00000001000
-	TileTypeWest      TileType = 0b0000010000
-	TileTypeTrueOrtho TileType = 0b0000100000
-	TileTypeDSM       TileType = 0b0001000000
-
-	TileTypeOffset = 10
+import (
+	"sync/atomic"
 )
 
 // Tile is a node within a quadmap.
-// Although a Tile instance will only be in the quadmap once (for a given quadkey) it may
-// contain a key used to look up specifics for the quadkey in SQLite.
+// Metadata for the tile (including the ids used to look up specifics for the quadkey in SQLite)
+// is kept by the quadmap, see QuadMap.GetTileMetadata.
 type Tile struct {
 	QuadKey QuadKey
 
 	// Details holds information about tiletypes, full/empty.. and potentially other info.
-	//		|63-----------20|19-----------10|9----------------0|
-	//		|     unused    |    TileType   |  Tiletype full   |
-	//		|               |               |      flags       |
+	//		|63--------------------32|31---------------------0|
+	//		|        TileType        |     Tiletype full      |
+	//		|                        |         flags          |
 	//
-	// Bits 9 -> 0 (10 bits) are used to indicate full for TileType.
-	// Bits 19 -> 19 (10 bits) are used to indicate TileType.
+	// Bits 31 -> 0 (32 bits) are used to indicate full for TileType.
+	// Bits 63 -> 32 (32 bits) are used to indicate TileType.
+	// (Before supporting 32 tile types, bits 9 -> 0 were full flags and 19 -> 10 were TileType.
+	// See ConvertLegacyDetails)
+	//
+	// Details is read and modified atomically by the Tile methods, so a tile returned from a
+	// QuadMap can be queried while other goroutines are adding tiles. Use LoadDetails rather
+	// than reading Details directly in that case.
 	Details uint64
+
+	// lastUsed is when the tile was last used, from the clock of the quadmap's eviction policy.
+	// Accessed atomically.
+	lastUsed uint64
 }
 
 // NewTile creates a new tile at slippy co-ords x,y,z
@@ -68,33 +68,79 @@ func (t *Tile) GetTileZoomLevel() byte {
 	return t.QuadKey.Zoom()
 }
 
+// LoadDetails atomically reads Details
+func (t *Tile) LoadDetails() uint64 {
+	return atomic.LoadUint64(&t.Details)
+}
+
 // AddTileType Adds tiletype and full flag to tile
+// If the tile is in a quadmap, the quadmap's statistics (NumberOfTilesForTypeAndZoom,
+// GetSlippyBoundsForTileTypeAndZoom etc) won't include the change. Use
+// QuadMap.CreateTileAtSlippyCoords instead, or call QuadMap.RebuildStatistics afterwards.
 func (t *Tile) AddTileType(tileType TileType, full bool) {
+	for {
+		old := atomic.LoadUint64(&t.Details)
 
-	// set tiletype
-	t.Details = t.Details | (uint64(tileType) << TileTypeOffset)
+		// set tiletype
+		details := old | (uint64(tileType) << TileTypeOffset)
 
-	if full {
-		// set full flag
-		t.Details = t.Details | uint64(tileType)
-	} else {
+		if full {
+			// set full flag
+			details |= uint64(tileType)
+		} else {
 
-		// clear full flag.
-		t.Details &= ^uint64(tileType)
+			// clear full flag.
+			details &= ^uint64(tileType)
+		}
+
+		if atomic.CompareAndSwapUint64(&t.Details, old, details) {
+			return
+		}
 	}
 }
 
 // HasTileType checks if specific tiletype associated with tile.
 // Returns if tiletype present and if full or not
 func (t *Tile) HasTileTypeAndFull(tileType TileType) (bool, bool) {
+	details := t.LoadDetails()
 	tileTypeShift := uint64(tileType) << TileTypeOffset
-	tileTypePresent := t.Details & tileTypeShift
-	tileTypeFull := t.Details & uint64(tileType)
+	tileTypePresent := details & tileTypeShift
+	tileTypeFull := details & uint64(tileType)
 
 	return tileTypePresent != 0, tileTypeFull != 0
 }
 
 func (t *Tile) HasTileType(tileType TileType) bool {
 	tileTypeShift := uint64(tileType) << TileTypeOffset
-	return (t.Details & tileTypeShift) != 0
+	return (t.LoadDetails() & tileTypeShift) != 0
+}
+
+// RemoveTileType removes tiletype (and its full flag) from the tile
+// As with AddTileType, the statistics (and metadata) of a quadmap containing the tile aren't
+// updated. Use QuadMap.RemoveTileTypeForSubtree instead, or call QuadMap.RebuildStatistics
+// afterwards.
+func (t *Tile) RemoveTileType(tileType TileType) {
+	atomic.AndUint64(&t.Details, ^((uint64(tileType) << TileTypeOffset) | uint64(tileType)))
+}
+
+// ClearFull clears the full flag for tiletype, leaving the tiletype itself on the tile
+// As with AddTileType, the statistics of a quadmap containing the tile aren't updated, call
+// QuadMap.RebuildStatistics afterwards.
+func (t *Tile) ClearFull(tileType TileType) {
+	atomic.AndUint64(&t.Details, ^uint64(tileType))
+}
+
+// mergeDetails adds the tiletypes and full flags in details to the tile
+func (t *Tile) mergeDetails(details uint64) {
+	atomic.OrUint64(&t.Details, details)
+}
+
+// clone returns a copy of the tile
+func (t *Tile) clone() *Tile {
+	return &Tile{QuadKey: t.QuadKey, Details: t.LoadDetails()}
+}
+
+// hasNoTileTypes returns true if the tile has no tiletypes left, ie it can be removed from the quadmap
+func (t *Tile) hasNoTileTypes() bool {
+	return t.LoadDetails()>>TileTypeOffset == 0
 }
@@ -7,10 +7,10 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func setupSuite(t testing.T) func(t testing.T) {
+func setupSuite(t *testing.T) func(t *testing.T) {
 	log.Println("setup suite")
 
-	return func(t testing.T) {
+	return func(t *testing.T) {
 	}
 }
 
@@ -57,3 +57,27 @@ func TestTwoSetTileTypes(t *testing.T) {
 	assert.Equal(t, true, tileTypeExists, "Should not have tileType")
 	assert.Equal(t, true, tileTypeFull, "Should not have tileType")
 }
+
+// TestRemoveTileType tests removing a tiletype leaves other tiletypes alone
+func TestRemoveTileType(t *testing.T) {
+
+	tile, err := NewTile(1, 1, 1)
+	assert.NoError(t, err, "Should not have error")
+	tile.AddTileType(TileTypeVert, true)
+	tile.AddTileType(TileTypeDSM, true)
+
+	tile.ClearFull(TileTypeDSM)
+	tileTypeExists, tileTypeFull := tile.HasTileTypeAndFull(TileTypeDSM)
+	assert.Equal(t, true, tileTypeExists, "Should have tileType")
+	assert.Equal(t, false, tileTypeFull, "Should not be full")
+
+	tile.RemoveTileType(TileTypeVert)
+	tileTypeExists, tileTypeFull = tile.HasTileTypeAndFull(TileTypeVert)
+	assert.Equal(t, false, tileTypeExists, "Should not have tileType")
+	assert.Equal(t, false, tileTypeFull, "Should not be full")
+	assert.Equal(t, true, tile.HasTileType(TileTypeDSM), "Should still have DSM")
+	assert.Equal(t, false, tile.hasNoTileTypes())
+
+	tile.RemoveTileType(TileTypeDSM)
+	assert.Equal(t, true, tile.hasNoTileTypes())
+}
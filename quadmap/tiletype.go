@@ -0,0 +1,167 @@
+package quadmap
+
+import (
+	"errors"
+	"fmt"
+	"math/bits"
+	"sort"
+	"sync"
+)
+
+// TileType is a bitmask, each TileType is a single bit. The first 7 are built in, others can be
+// added with RegisterTileType.
+type TileType uint32
+
+const (
+	TileTypeVert      TileType = 0b000000000001
+	TileTypeEast      TileType = 0b000000000010
+	TileTypeNorth     TileType = 0b000000000100
+	TileTypeSouth     TileType = 0b00000001000
+	TileTypeWest      TileType = 0b0000010000
+	TileTypeTrueOrtho TileType = 0b0000100000
+	TileTypeDSM       TileType = 0b0001000000
+
+	TileTypeOffset = 32
+
+	// MaxTileTypes is the maximum number of TileTypes (built in + registered)
+	MaxTileTypes = 32
+
+	// legacyTileTypeOffset is the TileTypeOffset used before TileType was extended to 32 bits
+	legacyTileTypeOffset = 10
+
+	// legacyTileTypeMask covers the 10 bits of full flags (or TileTypes) in the legacy layout
+	legacyTileTypeMask = 0b1111111111
+)
+
+var (
+	TooManyTileTypesError    = errors.New("too many tile types registered")
+	InvalidTileTypeNameError = errors.New("invalid tile type name")
+)
+
+// tileTypeRegistry gives each TileType a stable name. Names (rather than bit values) are
+// written out when serialising a quadmap, so bits can be reshuffled (or registered in a
+// different order) without breaking previously written data.
+type tileTypeRegistry struct {
+	names  map[TileType]string
+	byName map[string]TileType
+	lock   sync.RWMutex
+}
+
+var tileTypes = newTileTypeRegistry()
+
+// newTileTypeRegistry returns a registry containing the built in TileTypes
+func newTileTypeRegistry() *tileTypeRegistry {
+	r := &tileTypeRegistry{
+		names:  make(map[TileType]string),
+		byName: make(map[string]TileType),
+	}
+	for tt, name := range map[TileType]string{
+		TileTypeVert:      "Vert",
+		TileTypeEast:      "East",
+		TileTypeNorth:     "North",
+		TileTypeSouth:     "South",
+		TileTypeWest:      "West",
+		TileTypeTrueOrtho: "TrueOrtho",
+		TileTypeDSM:       "DSM",
+	} {
+		r.names[tt] = name
+		r.byName[name] = tt
+	}
+	return r
+}
+
+// RegisterTileType registers a new TileType with name, using the next free bit.
+// If name is already registered the existing TileType is returned, so it's safe for
+// multiple packages to register the same name.
+// Names must be 1-255 bytes (they're written out when serialising a quadmap).
+// The bit depends on registration order, so anything persisting TileTypes should store names
+// (storage keeps a name to bit table, so register TileTypes before calling storage.NewStorage).
+func RegisterTileType(name string) (TileType, error) {
+	return tileTypes.register(name)
+}
+
+func (r *tileTypeRegistry) register(name string) (TileType, error) {
+	if len(name) == 0 || len(name) > 255 {
+		return 0, fmt.Errorf("%w: %q", InvalidTileTypeNameError, name)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if tt, ok := r.byName[name]; ok {
+		return tt, nil
+	}
+	if len(r.names) >= MaxTileTypes {
+		return 0, TooManyTileTypesError
+	}
+
+	tt := TileType(1) << len(r.names)
+	r.names[tt] = name
+	r.byName[name] = tt
+	return tt, nil
+}
+
+// MustRegisterTileType is RegisterTileType but panics on error. For use when initialising
+// package level variables.
+func MustRegisterTileType(name string) TileType {
+	tt, err := RegisterTileType(name)
+	if err != nil {
+		panic(err)
+	}
+	return tt
+}
+
+// TileTypeByName returns the TileType registered with name
+func TileTypeByName(name string) (TileType, bool) {
+	tileTypes.lock.RLock()
+	defer tileTypes.lock.RUnlock()
+	tt, ok := tileTypes.byName[name]
+	return tt, ok
+}
+
+// AllTileTypes returns all built in and registered TileTypes, in bit order
+func AllTileTypes() []TileType {
+	tileTypes.lock.RLock()
+	defer tileTypes.lock.RUnlock()
+
+	all := make([]TileType, 0, len(tileTypes.names))
+	for tt := range tileTypes.names {
+		all = append(all, tt)
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i] < all[j] })
+	return all
+}
+
+// String returns the name of the TileType
+func (tt TileType) String() string {
+	tileTypes.lock.RLock()
+	defer tileTypes.lock.RUnlock()
+	if name, ok := tileTypes.names[tt]; ok {
+		return name
+	}
+	return fmt.Sprintf("TileType(%d)", uint32(tt))
+}
+
+// IsValid returns true if tt is a single bit (ie not 0 or multiple TileTypes combined)
+func (tt TileType) IsValid() bool {
+	return bits.OnesCount32(uint32(tt)) == 1
+}
+
+// ConvertLegacyDetails converts Tile.Details from the layout used before TileType was extended
+// to 32 bits (full flags in bits 9 -> 0, TileType in bits 19 -> 10) to the current layout.
+func ConvertLegacyDetails(details uint64) uint64 {
+	full := details & legacyTileTypeMask
+	types := (details >> legacyTileTypeOffset) & legacyTileTypeMask
+	return (types << TileTypeOffset) | full
+}
+
+// LegacyDetails converts Tile.Details to the legacy layout (see ConvertLegacyDetails).
+// Returns false if details contains TileTypes that can't be represented in the legacy layout.
+func LegacyDetails(details uint64) (uint64, bool) {
+	full := details & (1<<TileTypeOffset - 1)
+	types := details >> TileTypeOffset
+	if full > legacyTileTypeMask || types > legacyTileTypeMask {
+		return 0, false
+	}
+	return (types << legacyTileTypeOffset) | full, true
+}
@@ -0,0 +1,96 @@
+package quadmap
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestRegisterTileType confirms registered tile types use the next free bit and are idempotent
+func TestRegisterTileType(t *testing.T) {
+	r := newTileTypeRegistry()
+
+	tt, err := r.register("LiDAR")
+	assert.NoError(t, err, "Should not have error registering tile type")
+	assert.Equal(t, TileType(0b10000000), tt, "Should use the first free bit")
+
+	again, err := r.register("LiDAR")
+	assert.NoError(t, err, "Should not have error re-registering tile type")
+	assert.Equal(t, tt, again, "Re-registering should return the same tile type")
+
+	dsm, err := r.register("DSM")
+	assert.NoError(t, err, "Should not have error registering built in name")
+	assert.Equal(t, TileTypeDSM, dsm, "Should return built in tile type")
+
+	_, err = r.register("")
+	assert.ErrorIs(t, err, InvalidTileTypeNameError)
+}
+
+// TestRegisterTooManyTileTypes confirms registration fails once all 32 bits are used
+func TestRegisterTooManyTileTypes(t *testing.T) {
+	r := newTileTypeRegistry()
+
+	var last TileType
+	for i := len(r.names); i < MaxTileTypes; i++ {
+		tt, err := r.register(fmt.Sprintf("type%d", i))
+		assert.NoError(t, err, "Should not have error registering tile type %d", i)
+		last = tt
+	}
+	assert.Equal(t, TileType(1)<<31, last, "Last tile type should use the top bit")
+
+	_, err := r.register("one too many")
+	assert.ErrorIs(t, err, TooManyTileTypesError)
+}
+
+// TestRegisteredTileTypeNames confirms names can be looked up both ways
+func TestRegisteredTileTypeNames(t *testing.T) {
+	tt := MustRegisterTileType("TestRegisteredTileTypeNames")
+	assert.True(t, tt.IsValid(), "Registered tile type should be valid")
+
+	byName, ok := TileTypeByName("TestRegisteredTileTypeNames")
+	assert.True(t, ok, "Should find tile type by name")
+	assert.Equal(t, tt, byName)
+	assert.Equal(t, "TestRegisteredTileTypeNames", tt.String())
+	assert.Contains(t, AllTileTypes(), tt)
+
+	assert.Equal(t, "DSM", TileTypeDSM.String())
+	assert.False(t, (TileTypeVert | TileTypeEast).IsValid(), "Combined tile types should not be valid")
+}
+
+// TestHighBitTileType confirms a tile type in the top bit doesn't collide with the full flags
+func TestHighBitTileType(t *testing.T) {
+	tt := TileType(1) << 31
+	tile, err := NewTileWithTileTypeAndFull(3, 3, 3, tt, true)
+	assert.NoError(t, err, "Should not have error creating tile")
+	tile.AddTileType(TileTypeVert, false)
+
+	hasTileType, isFull := tile.HasTileTypeAndFull(tt)
+	assert.True(t, hasTileType)
+	assert.True(t, isFull)
+	hasTileType, isFull = tile.HasTileTypeAndFull(TileTypeVert)
+	assert.True(t, hasTileType)
+	assert.False(t, isFull)
+}
+
+// TestLegacyDetails confirms conversion to and from the legacy Details layout
+func TestLegacyDetails(t *testing.T) {
+	// Vert (full) and DSM in the legacy layout
+	legacy := uint64(TileTypeVert|TileTypeDSM)<<legacyTileTypeOffset | uint64(TileTypeVert)
+
+	tile := Tile{Details: ConvertLegacyDetails(legacy)}
+	hasTileType, isFull := tile.HasTileTypeAndFull(TileTypeVert)
+	assert.True(t, hasTileType)
+	assert.True(t, isFull)
+	hasTileType, isFull = tile.HasTileTypeAndFull(TileTypeDSM)
+	assert.True(t, hasTileType)
+	assert.False(t, isFull)
+
+	back, ok := LegacyDetails(tile.Details)
+	assert.True(t, ok, "Should be representable in legacy layout")
+	assert.Equal(t, legacy, back)
+
+	tile.AddTileType(TileType(1)<<20, false)
+	_, ok = LegacyDetails(tile.Details)
+	assert.False(t, ok, "High tile types should not be representable in legacy layout")
+}
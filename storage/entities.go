@@ -1,26 +1,49 @@
 package storage
 
-import "github.com/kpfaulkner/quadmap/quadmap"
+import (
+	"time"
+
+	"github.com/kpfaulkner/quadmap/quadmap"
+)
 
 // Entities:
 // TileEntity has quadkey and details mask. The details mask will indicate if there are
 // QuadKey is NOT the primary key... but will be indexed and will be main column we search on.
 type TileEntity struct {
 	QuadKey     quadmap.QuadKey `db:"quadkey"`
-	TileType    uint16          `db:"tiletype"`
+	TileType    uint32          `db:"tiletype"`
 	Full        bool            `db:"full"`
 	DetailsMask uint64          `db:"details_mask"`
 	DetailsID   int64           `db:"details_id"`
 }
 
+// DetailsEntity DateTime (capture date) and RetiredDateTime are unix seconds.
+// RetiredDateTime is 0 if the details are still current.
 type DetailsEntity struct {
 	Id              uint64 `db:"id"`
 	Border          string `db:"border"`
 	SimpleBorder    string `db:"simple_border"`
 	SimpleBorderWKB []byte `db:"simple_border_wkb"`
-	TileType        uint16 `db:"tiletype"`
+	TileType        uint32 `db:"tiletype"`
 	DateTime        int64  `db:"datetime"`
+	RetiredDateTime int64  `db:"retired_datetime"`
 	Enabled         bool   `db:"enabled"`
 	Identifier      string `db:"identifier"`
 	Scale           uint16 `db:"scale"`
 }
+
+// TileMetadata converts the details to metadata that can be added to tiles in a quadmap
+func (d DetailsEntity) TileMetadata() quadmap.TileMetadata {
+	md := quadmap.TileMetadata{
+		TileType:   quadmap.TileType(d.TileType),
+		Scale:      d.Scale,
+		DetailsIDs: []int64{int64(d.Id)},
+	}
+	if d.DateTime != 0 {
+		md.CaptureDate = time.Unix(d.DateTime, 0).UTC()
+	}
+	if d.RetiredDateTime != 0 {
+		md.RetiredDate = time.Unix(d.RetiredDateTime, 0).UTC()
+	}
+	return md
+}
@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"strings"
 	"sync"
+	"time"
 
 	_ "modernc.org/sqlite"
 
@@ -20,8 +21,14 @@ const (
 type Storage struct {
 	db     *sqlx.DB
 	dbLock sync.Mutex
+
+	// bits used for TileTypes in the database, see tileTypeBits
+	tileTypes *tileTypeBits
 }
 
+// NewStorage opens (or creates) the SQLite database dbName.
+// Any TileTypes added with quadmap.RegisterTileType must be registered before this is called,
+// see tileTypeBits.
 func NewStorage(dbName string) (*Storage, error) {
 
 	db, err := sqlx.Connect("sqlite", dbName)
@@ -32,7 +39,17 @@ func NewStorage(dbName string) (*Storage, error) {
 
 	// table
 	//db.MustExec(`create table if not exists quadmap (id integer primary key, quadkey integer , details_mask integer, details_id integer)`)
-	db.MustExec(`create table if not exists details (id integer primary key, border varchar(500000),simple_border varchar(500000), simple_border_wkb blob, tiletype integer, datetime integer, scale integer, identifier varchar(50), enabled bool)`)
+	db.MustExec(`create table if not exists details (id integer primary key, border varchar(500000),simple_border varchar(500000), simple_border_wkb blob, tiletype integer, datetime integer, retired_datetime integer, scale integer, identifier varchar(50), enabled bool)`)
+
+	// details tables created before retired_datetime was added need the column adding.
+	var count int
+	err = db.Get(&count, `select count(*) from pragma_table_info('details') where name = 'retired_datetime'`)
+	if err != nil {
+		return nil, err
+	}
+	if count == 0 {
+		db.MustExec(`alter table details add column retired_datetime integer`)
+	}
 	db.MustExec(`create table if not exists processed (id integer primary key, identifier varchar(50),  tiletype integer)`)
 	//db.MustExec(`create index if not exists quadmap_index on quadmap(quadkey)`)
 	db.MustExec(`create index if not exists details_index on details(id)`)
@@ -49,22 +66,73 @@ func NewStorage(dbName string) (*Storage, error) {
 		return nil, err
 	}
 
+	tileTypes, err := loadTileTypeBits(db)
+	if err != nil {
+		return nil, err
+	}
+
 	s := &Storage{
-		db: db,
+		db:        db,
+		tileTypes: tileTypes,
 	}
 	return s, nil
 }
 
-func (s *Storage) CreatePartitionTableIfNotExist(txx *sqlx.Tx, tableName string) {
+func (s *Storage) CreatePartitionTableIfNotExist(txx *sqlx.Tx, tableName string) error {
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
-	statement := fmt.Sprintf("create table if not exists %s (id integer primary key, quadkey integer , details_mask integer, details_id integer)", tableName)
+	statement := fmt.Sprintf("create table if not exists %s (id integer primary key, quadkey integer , details_mask integer, details_id integer, hilbertkey integer)", tableName)
 	txx.MustExec(statement)
 
+	// tables created before hilbertkey was added need the column adding.
+	var count int
+	err := txx.Get(&count, fmt.Sprintf("select count(*) from pragma_table_info('%s') where name = 'hilbertkey'", tableName))
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		txx.MustExec(fmt.Sprintf("alter table %s add column hilbertkey integer", tableName))
+	}
+
 	indexName := fmt.Sprintf("%s_index", tableName)
 	statement = fmt.Sprintf("create index if not exists %s on %s(quadkey)", indexName, tableName)
 	//s.db.MustExec(statement)
 	txx.MustExec(statement)
+
+	hilbertIndexName := fmt.Sprintf("%s_hilbert_index", tableName)
+	statement = fmt.Sprintf("create index if not exists %s on %s(hilbertkey)", hilbertIndexName, tableName)
+	txx.MustExec(statement)
+	return nil
+}
+
+// BackfillHilbertKeys populates the hilbertkey column for any rows in tableName that were
+// inserted before the column existed. Returns the number of rows updated.
+func (s *Storage) BackfillHilbertKeys(txx *sqlx.Tx, tableName string) (int, error) {
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	var rows []struct {
+		Id      int64 `db:"id"`
+		QuadKey int64 `db:"quadkey"`
+	}
+	err := txx.Select(&rows, fmt.Sprintf("select id, quadkey from %s where hilbertkey is null", tableName))
+	if err != nil {
+		return 0, err
+	}
+
+	statement := fmt.Sprintf("UPDATE %s set hilbertkey = $1 WHERE id = $2", tableName)
+	for _, r := range rows {
+		hk := quadmap.QuadKey(r.QuadKey).HilbertKey()
+		txx.MustExec(statement, hilbertKeyToDB(hk), r.Id)
+	}
+	return len(rows), nil
+}
+
+// hilbertKeyToDB converts a HilbertKey to the value stored in the hilbertkey column.
+// SQLite integers are signed, so the top bit is flipped to keep the same ordering (so ranges
+// work as expected).
+func hilbertKeyToDB(hk quadmap.HilbertKey) int64 {
+	return int64(uint64(hk) ^ (1 << 63))
 }
 
 // GenerateTableName generates the table name that should be associated with the provided quadkey.
@@ -107,24 +175,37 @@ func (s *Storage) CommitTxx(txx *sqlx.Tx) error {
 func (s *Storage) InsertTileWithTableName(txx *sqlx.Tx, tableName string, tile TileEntity) error {
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
-	statement := fmt.Sprintf("INSERT INTO %s (quadkey, details_mask, details_id ) VALUES ($1,$2,$3)", tableName)
-	txx.MustExec(statement, int64(tile.QuadKey), int64(tile.DetailsMask), tile.DetailsID)
+	detailsMask, err := s.tileTypes.detailsToDB(tile.DetailsMask)
+	if err != nil {
+		return err
+	}
+	statement := fmt.Sprintf("INSERT INTO %s (quadkey, details_mask, details_id, hilbertkey ) VALUES ($1,$2,$3,$4)", tableName)
+	txx.MustExec(statement, int64(tile.QuadKey), int64(detailsMask), tile.DetailsID, hilbertKeyToDB(tile.QuadKey.HilbertKey()))
 	return nil
 }
 
 func (s *Storage) InsertTileWith(txx *sqlx.Tx, tile TileEntity) error {
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
+	detailsMask, err := s.tileTypes.detailsToDB(tile.DetailsMask)
+	if err != nil {
+		return err
+	}
 	tableName := s.GenerateTableName(tile.QuadKey)
-	statement := fmt.Sprintf("INSERT INTO %s (quadkey, details_mask, details_id ) VALUES ($1,$2,$3)", tableName)
-	txx.MustExec(statement, int64(tile.QuadKey), int64(tile.DetailsMask), tile.DetailsID)
+	statement := fmt.Sprintf("INSERT INTO %s (quadkey, details_mask, details_id, hilbertkey ) VALUES ($1,$2,$3,$4)", tableName)
+	txx.MustExec(statement, int64(tile.QuadKey), int64(detailsMask), tile.DetailsID, hilbertKeyToDB(tile.QuadKey.HilbertKey()))
 	return nil
 }
 
 func (s *Storage) InsertDetails(details DetailsEntity) (int64, error) {
+	tileType, err := s.tileTypes.tileTypeToDB(quadmap.TileType(details.TileType))
+	if err != nil {
+		return 0, err
+	}
+
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
-	res := s.db.MustExec(`INSERT INTO details ( border, simple_border, tiletype, datetime, enabled, scale, identifier, simple_border_wkb) VALUES ($1,$2,$3,$4,$5,$6, $7, $8);`, details.Border, details.SimpleBorder, details.TileType, details.DateTime, true, details.Scale, details.Identifier, details.SimpleBorderWKB)
+	res := s.db.MustExec(`INSERT INTO details ( border, simple_border, tiletype, datetime, enabled, scale, identifier, simple_border_wkb, retired_datetime) VALUES ($1,$2,$3,$4,$5,$6, $7, $8, $9);`, details.Border, details.SimpleBorder, uint32(tileType), details.DateTime, true, details.Scale, details.Identifier, details.SimpleBorderWKB, details.RetiredDateTime)
 
 	lastInsertedID, err := res.LastInsertId()
 	if err != nil {
@@ -144,7 +225,10 @@ func (s *Storage) GetDetails(id int) (*DetailsEntity, error) {
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
 	var entity DetailsEntity
-	s.db.Select(&entity, `SELECT id, border, simple_border, tiletype, datetime, scale, identifier, simple_border_wkb FROM details WHERE enabled = true AND id = $1`, fmt.Sprintf("%d", id))
+	s.db.Select(&entity, `SELECT id, border, simple_border, tiletype, datetime, coalesce(retired_datetime, 0) as retired_datetime, scale, identifier, simple_border_wkb FROM details WHERE enabled = true AND id = $1`, fmt.Sprintf("%d", id))
+	if err := s.detailsTileTypeFromDB(&entity); err != nil {
+		return nil, err
+	}
 	return &entity, nil
 }
 
@@ -152,16 +236,45 @@ func (s *Storage) GetAllDetails() ([]DetailsEntity, error) {
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
 	var entities []DetailsEntity
-	s.db.Select(&entities, `SELECT id, border, simple_border, tiletype, datetime, scale, identifier, simple_border_wkb FROM details WHERE enabled = true`)
+	s.db.Select(&entities, `SELECT id, border, simple_border, tiletype, datetime, coalesce(retired_datetime, 0) as retired_datetime, scale, identifier, simple_border_wkb FROM details WHERE enabled = true`)
+	for i := range entities {
+		if err := s.detailsTileTypeFromDB(&entities[i]); err != nil {
+			return nil, err
+		}
+	}
 	return entities, nil
 }
 
+// detailsTileTypeFromDB converts the TileType of details read from the database, see tileTypeBits
+func (s *Storage) detailsTileTypeFromDB(details *DetailsEntity) error {
+	tileType, err := s.tileTypes.tileTypeFromDB(quadmap.TileType(details.TileType))
+	if err != nil {
+		return err
+	}
+	details.TileType = uint32(tileType)
+	return nil
+}
+
+// GetTile returns the (first) tile stored for qk, from its partition table
 func (s *Storage) GetTile(qk quadmap.QuadKey) (*TileEntity, error) {
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
-	var entity TileEntity
-	s.db.Select(&entity, `SELECT quadkey,detail_mask  FROM quadmap WHERE quadkey = $1`, fmt.Sprintf("%d", qk))
-	return &entity, nil
+
+	// quadkey and details_mask are stored as int64
+	var row struct {
+		QuadKey     int64 `db:"quadkey"`
+		DetailsMask int64 `db:"details_mask"`
+		DetailsID   int64 `db:"details_id"`
+	}
+	statement := fmt.Sprintf("SELECT quadkey, details_mask, details_id FROM %s WHERE quadkey = $1 limit 1", s.GenerateTableName(qk))
+	if err := s.db.Get(&row, statement, int64(qk)); err != nil {
+		return nil, err
+	}
+	detailsMask, err := s.tileTypes.detailsFromDB(uint64(row.DetailsMask))
+	if err != nil {
+		return nil, err
+	}
+	return &TileEntity{QuadKey: quadmap.QuadKey(row.QuadKey), DetailsMask: detailsMask, DetailsID: row.DetailsID}, nil
 }
 
 func (s *Storage) SearchDetailsWithinQuadKey(qk quadmap.QuadKey, tileTypes []quadmap.TileType, includeSimpleBorder bool, limit int) ([]DetailsEntity, error) {
@@ -185,7 +298,11 @@ func (s *Storage) SearchDetailsBetweenQuadKeys(qk1 quadmap.QuadKey, qk2 quadmap.
 	var entities []DetailsEntity
 
 	// used to help filter out unwanted tile types.
-	detailsQuery := generateTileTypesQuery(tileTypes)
+	dbTileTypes, err := s.tileTypes.tileTypesToDB(tileTypes)
+	if err != nil {
+		return nil, err
+	}
+	detailsQuery := generateTileTypesQuery(dbTileTypes)
 	tableName := s.GenerateTableName(qk1)
 	var statement string
 	if includeSimpleBorder {
@@ -196,7 +313,7 @@ func (s *Storage) SearchDetailsBetweenQuadKeys(qk1 quadmap.QuadKey, qk2 quadmap.
 
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
-	err := s.db.Select(&entities, statement, qkint64, qk2int64, limit)
+	err = s.db.Select(&entities, statement, qkint64, qk2int64, limit)
 	if err != nil {
 		return nil, err
 	}
@@ -204,16 +321,128 @@ func (s *Storage) SearchDetailsBetweenQuadKeys(qk1 quadmap.QuadKey, qk2 quadmap.
 	return entities, nil
 }
 
+// SearchDetailsInHilbertRanges returns details for any hits within the HilbertKey ranges (eg. from
+// covering.HilbertSearchRanges). Only the partition tables that overlap the ranges are searched.
+func (s *Storage) SearchDetailsInHilbertRanges(ranges []quadmap.HilbertKeyRange, tileTypes []quadmap.TileType, includeSimpleBorder bool, limit int) ([]DetailsEntity, error) {
+	return s.SearchDetailsInHilbertRangesForTimeWindow(ranges, tileTypes, quadmap.TimeWindow{}, includeSimpleBorder, limit)
+}
+
+// SearchDetailsInHilbertRangesForTimeWindow is SearchDetailsInHilbertRanges but only returns details
+// that were current at some point during window, ie captured before the window ends and not retired
+// before it starts. Use quadmap.TimeWindowAt for details that were current at a single point in time.
+func (s *Storage) SearchDetailsInHilbertRangesForTimeWindow(ranges []quadmap.HilbertKeyRange, tileTypes []quadmap.TileType, window quadmap.TimeWindow, includeSimpleBorder bool, limit int) ([]DetailsEntity, error) {
+	if len(ranges) == 0 {
+		return nil, nil
+	}
+	dbTileTypes, err := s.tileTypes.tileTypesToDB(tileTypes)
+	if err != nil {
+		return nil, err
+	}
+
+	s.dbLock.Lock()
+	defer s.dbLock.Unlock()
+
+	var tableNames []string
+	err = s.db.Select(&tableNames, `SELECT name FROM sqlite_master WHERE type = 'table' AND name LIKE 'quadmap_%'`)
+	if err != nil {
+		return nil, err
+	}
+
+	var args []any
+	var subQueries []string
+	detailsQuery := generateTileTypesQuery(dbTileTypes)
+	for _, tableName := range tableNames {
+		var conditions []string
+		for _, r := range partitionHilbertRanges(tableName, ranges) {
+			conditions = append(conditions, fmt.Sprintf("(qm.hilbertkey >= $%d AND qm.hilbertkey <= $%d)", len(args)+1, len(args)+2))
+			args = append(args, hilbertKeyToDB(r.Start), hilbertKeyToDB(r.End))
+		}
+		if len(conditions) == 0 {
+			continue
+		}
+		subQueries = append(subQueries, fmt.Sprintf("select details_id from %s qm where (%s) AND details_mask in (%s)", tableName, strings.Join(conditions, " OR "), detailsQuery))
+	}
+	if len(subQueries) == 0 {
+		return nil, nil
+	}
+
+	var timeConditions string
+	if !window.End.IsZero() {
+		timeConditions += fmt.Sprintf(" AND d.datetime <= $%d", len(args)+1)
+		args = append(args, dateTimeToDB(window.End))
+	}
+	if !window.Start.IsZero() {
+		timeConditions += fmt.Sprintf(" AND (d.retired_datetime is null OR d.retired_datetime = 0 OR d.retired_datetime > $%d)", len(args)+1)
+		args = append(args, dateTimeToDB(window.Start))
+	}
+
+	columns := "d.id,d.scale,d.identifier,d.tiletype,d.datetime,coalesce(d.retired_datetime, 0) as retired_datetime"
+	if includeSimpleBorder {
+		columns += ", d.simple_border_wkb"
+	}
+	statement := fmt.Sprintf("select %s from details d where d.id in (%s)%s limit $%d;", columns, strings.Join(subQueries, " union "), timeConditions, len(args)+1)
+	args = append(args, limit)
+
+	var entities []DetailsEntity
+	err = s.db.Select(&entities, statement, args...)
+	if err != nil {
+		return nil, err
+	}
+	for i := range entities {
+		if err := s.detailsTileTypeFromDB(&entities[i]); err != nil {
+			return nil, err
+		}
+	}
+	return entities, nil
+}
+
+// dateTimeToDB converts t to the value stored in the datetime columns (unix seconds)
+func dateTimeToDB(t time.Time) int64 {
+	return t.Unix()
+}
+
+// partitionHilbertRanges returns the ranges that overlap the partition table, clipped to the
+// partition. quadmap_high can contain any tile below TablePartitionZoomLevel so is never clipped.
+func partitionHilbertRanges(tableName string, ranges []quadmap.HilbertKeyRange) []quadmap.HilbertKeyRange {
+	if tableName == "quadmap_high" {
+		return ranges
+	}
+
+	var partitionKey uint64
+	if _, err := fmt.Sscanf(tableName, "quadmap_%d", &partitionKey); err != nil {
+		return nil
+	}
+	partition := quadmap.QuadKey(partitionKey).HilbertKey().Range()
+
+	var overlapping []quadmap.HilbertKeyRange
+	for _, r := range ranges {
+		if r.End < partition.Start || r.Start > partition.End {
+			continue
+		}
+		overlapping = append(overlapping, quadmap.HilbertKeyRange{
+			Start: max(r.Start, partition.Start),
+			End:   min(r.End, partition.End),
+		})
+	}
+	return overlapping
+}
+
 // generates query string for filtering by tile types.
+// Rows inserted before TileType was extended to 32 bits have details_mask in the legacy
+// layout (see quadmap.ConvertLegacyDetails) so those values are matched as well.
 func generateTileTypesQuery(types []quadmap.TileType) string {
 
 	var conditions []string
 
 	for _, t := range types {
-		v := t << quadmap.TileTypeOffset
-		conditions = append(conditions, fmt.Sprintf("%d", v))
-		v = v | t
-		conditions = append(conditions, fmt.Sprintf("%d", v))
+		v := uint64(t) << quadmap.TileTypeOffset
+		for _, details := range []uint64{v, v | uint64(t)} {
+			// details_mask is stored as int64
+			conditions = append(conditions, fmt.Sprintf("%d", int64(details)))
+			if legacy, ok := quadmap.LegacyDetails(details); ok {
+				conditions = append(conditions, fmt.Sprintf("%d", legacy))
+			}
+		}
 	}
 
 	query := strings.Join(conditions, " , ")
@@ -249,19 +478,29 @@ func (s *Storage) SearchQuadKeysWithinQuadKey(qk quadmap.QuadKey) ([]int64, erro
 }
 
 func (s *Storage) InsertIdentifier(identifier string, tileType quadmap.TileType) error {
+	dbTileType, err := s.tileTypes.tileTypeToDB(tileType)
+	if err != nil {
+		return err
+	}
+
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
-	s.db.MustExec(`INSERT INTO processed ( identifier, tiletype ) VALUES ($1, $2);`, identifier, tileType)
+	s.db.MustExec(`INSERT INTO processed ( identifier, tiletype ) VALUES ($1, $2);`, identifier, dbTileType)
 
 	return nil
 }
 
 func (s *Storage) HasIdentifier(identifier string, tileType quadmap.TileType) bool {
+	dbTileType, err := s.tileTypes.tileTypeToDB(tileType)
+	if err != nil {
+		log.Errorf("error checking for identifier %v", err)
+		return false
+	}
 
 	s.dbLock.Lock()
 	defer s.dbLock.Unlock()
 	var existingIdentifier []string
-	err := s.db.Select(&existingIdentifier, `SELECT identifier  FROM processed WHERE identifier = $1 AND tiletype = $2`, identifier, tileType)
+	err = s.db.Select(&existingIdentifier, `SELECT identifier  FROM processed WHERE identifier = $1 AND tiletype = $2`, identifier, dbTileType)
 	if err != nil {
 		log.Errorf("error checking for identifier %v", err)
 		return false
@@ -0,0 +1,206 @@
+package storage
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kpfaulkner/quadmap/covering"
+	"github.com/kpfaulkner/quadmap/quadmap"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestStorage returns a Storage backed by an in-memory database, private to the test
+func newTestStorage(t *testing.T) *Storage {
+	t.Helper()
+	name := strings.ReplaceAll(t.Name(), "/", "_")
+	s, err := NewStorage(fmt.Sprintf("file:%s?mode=memory&cache=shared", name))
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func mustQuadKey(t *testing.T, x uint32, y uint32, z byte) quadmap.QuadKey {
+	t.Helper()
+	qk, err := quadmap.GenerateQuadKeyIndexFromSlippy(x, y, z)
+	require.NoError(t, err)
+	return qk
+}
+
+// addTile inserts details, and a full tile for qk referencing them (creating the partition
+// table if required). Returns the details id.
+func addTile(t *testing.T, s *Storage, qk quadmap.QuadKey, details DetailsEntity) int64 {
+	t.Helper()
+	id, err := s.InsertDetails(details)
+	require.NoError(t, err)
+
+	txx, err := s.BeginTxx()
+	require.NoError(t, err)
+	require.NoError(t, s.CreatePartitionTableIfNotExist(txx, s.GenerateTableName(qk)))
+	tt := uint64(details.TileType)
+	require.NoError(t, s.InsertTileWith(txx, TileEntity{QuadKey: qk, DetailsMask: tt<<quadmap.TileTypeOffset | tt, DetailsID: id}))
+	require.NoError(t, s.CommitTxx(txx))
+	return id
+}
+
+func detailsIDs(entities []DetailsEntity) []uint64 {
+	var ids []uint64
+	for _, e := range entities {
+		ids = append(ids, e.Id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+func TestSearchDetailsInHilbertRangesForTimeWindow(t *testing.T) {
+	s := newTestStorage(t)
+	qk := mustQuadKey(t, 12000, 9000, 14)
+	date := func(year int) time.Time {
+		return time.Date(year, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	current := addTile(t, s, qk, DetailsEntity{TileType: uint32(quadmap.TileTypeVert), DateTime: date(2020).Unix(), Scale: 3})
+	retired := addTile(t, s, qk, DetailsEntity{TileType: uint32(quadmap.TileTypeVert), DateTime: date(2018).Unix(), RetiredDateTime: date(2021).Unix()})
+	addTile(t, s, qk, DetailsEntity{TileType: uint32(quadmap.TileTypeDSM), DateTime: date(2019).Unix()})
+	// outside the search range
+	addTile(t, s, mustQuadKey(t, 1200, 900, 11), DetailsEntity{TileType: uint32(quadmap.TileTypeVert), DateTime: date(2019).Unix()})
+
+	ranges := []quadmap.HilbertKeyRange{qk.HilbertKey().Range()}
+	for _, tc := range []struct {
+		name     string
+		window   quadmap.TimeWindow
+		expected []int64
+	}{
+		{name: "any time", window: quadmap.TimeWindow{}, expected: []int64{current, retired}},
+		{name: "before current was captured", window: quadmap.TimeWindowAt(date(2019)), expected: []int64{retired}},
+		{name: "after retired", window: quadmap.TimeWindowAt(date(2022)), expected: []int64{current}},
+		{name: "window overlapping both", window: quadmap.TimeWindow{Start: date(2019), End: date(2020)}, expected: []int64{current, retired}},
+		{name: "before anything was captured", window: quadmap.TimeWindow{End: date(2017)}, expected: nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			entities, err := s.SearchDetailsInHilbertRangesForTimeWindow(ranges, []quadmap.TileType{quadmap.TileTypeVert}, tc.window, false, 10)
+			require.NoError(t, err)
+
+			var expected []uint64
+			for _, id := range tc.expected {
+				expected = append(expected, uint64(id))
+			}
+			assert.Equal(t, expected, detailsIDs(entities))
+			for _, e := range entities {
+				md := e.TileMetadata()
+				assert.Equal(t, quadmap.TileTypeVert, md.TileType)
+				if e.Id == uint64(retired) {
+					assert.Equal(t, date(2021), md.RetiredDate)
+				} else {
+					assert.True(t, md.RetiredDate.IsZero())
+					assert.Equal(t, uint16(3), md.Scale)
+				}
+			}
+		})
+	}
+}
+
+// TestGetTileLegacyDetails checks rows written with the legacy details_mask layout (before
+// TileType was extended to 32 bits) are converted when read
+func TestGetTileLegacyDetails(t *testing.T) {
+	s := newTestStorage(t)
+	vert := uint64(quadmap.TileTypeVert)
+	expected := vert<<quadmap.TileTypeOffset | vert
+
+	qk := mustQuadKey(t, 12000, 9000, 14)
+	addTile(t, s, qk, DetailsEntity{TileType: uint32(quadmap.TileTypeVert)})
+	tile, err := s.GetTile(qk)
+	require.NoError(t, err)
+	assert.Equal(t, expected, tile.DetailsMask)
+
+	legacy, ok := quadmap.LegacyDetails(expected)
+	require.True(t, ok)
+	legacyQK := mustQuadKey(t, 12002, 9000, 14)
+	s.db.MustExec(fmt.Sprintf("INSERT INTO %s (quadkey, details_mask, details_id) VALUES ($1, $2, $3)", s.GenerateTableName(legacyQK)), int64(legacyQK), int64(legacy), 1)
+	tile, err = s.GetTile(legacyQK)
+	require.NoError(t, err)
+	assert.Equal(t, legacyQK, tile.QuadKey)
+	assert.Equal(t, expected, tile.DetailsMask)
+}
+
+// TestBackfillHilbertKeys checks partition tables created before the hilbertkey column existed
+// get the column added, and that backfilled rows can be found with a hilbert range search
+func TestBackfillHilbertKeys(t *testing.T) {
+	s := newTestStorage(t)
+	qk := mustQuadKey(t, 12000, 9000, 14)
+	tableName := s.GenerateTableName(qk)
+	id, err := s.InsertDetails(DetailsEntity{TileType: uint32(quadmap.TileTypeVert)})
+	require.NoError(t, err)
+
+	// partition table (and row) as created before hilbertkey was added
+	s.db.MustExec(fmt.Sprintf("create table %s (id integer primary key, quadkey integer , details_mask integer, details_id integer)", tableName))
+	vert := uint64(quadmap.TileTypeVert)
+	s.db.MustExec(fmt.Sprintf("INSERT INTO %s (quadkey, details_mask, details_id) VALUES ($1, $2, $3)", tableName), int64(qk), int64(vert<<quadmap.TileTypeOffset|vert), id)
+
+	ranges := []quadmap.HilbertKeyRange{qk.HilbertKey().Range()}
+	txx, err := s.BeginTxx()
+	require.NoError(t, err)
+	require.NoError(t, s.CreatePartitionTableIfNotExist(txx, tableName))
+	require.NoError(t, s.CommitTxx(txx))
+	entities, err := s.SearchDetailsInHilbertRanges(ranges, []quadmap.TileType{quadmap.TileTypeVert}, false, 10)
+	require.NoError(t, err)
+	assert.Empty(t, entities, "Rows without a hilbertkey can't be found until backfilled")
+
+	txx, err = s.BeginTxx()
+	require.NoError(t, err)
+	updated, err := s.BackfillHilbertKeys(txx, tableName)
+	require.NoError(t, err)
+	require.NoError(t, s.CommitTxx(txx))
+	assert.Equal(t, 1, updated)
+
+	entities, err = s.SearchDetailsInHilbertRanges(ranges, []quadmap.TileType{quadmap.TileTypeVert}, false, 10)
+	require.NoError(t, err)
+	assert.Equal(t, []uint64{uint64(id)}, detailsIDs(entities))
+
+	txx, err = s.BeginTxx()
+	require.NoError(t, err)
+	updated, err = s.BackfillHilbertKeys(txx, tableName)
+	require.NoError(t, err)
+	require.NoError(t, s.CommitTxx(txx))
+	assert.Equal(t, 0, updated, "Already backfilled")
+}
+
+// TestSearchDetailsInHilbertRanges checks tiles are found across partition tables (including
+// quadmap_high) and only within the ranges
+func TestSearchDetailsInHilbertRanges(t *testing.T) {
+	s := newTestStorage(t)
+	vert := DetailsEntity{TileType: uint32(quadmap.TileTypeVert)}
+
+	// searching for the zoom 9 tile, its descendants in two partitions and its ancestors
+	searched := mustQuadKey(t, 300, 200, 9)
+	ancestor := addTile(t, s, mustQuadKey(t, 75, 50, 7), vert)
+	inFirstPartition := addTile(t, s, mustQuadKey(t, 600*16+5, 400*16+7, 14), vert)
+	inSecondPartition := addTile(t, s, mustQuadKey(t, 601*16+2, 401*16+9, 14), vert)
+	addTile(t, s, mustQuadKey(t, 76, 50, 7), vert)
+	addTile(t, s, mustQuadKey(t, 602*16, 400*16, 14), vert)
+	addTile(t, s, mustQuadKey(t, 600*16+5, 400*16+7, 14), DetailsEntity{TileType: uint32(quadmap.TileTypeDSM)})
+
+	ranges, err := covering.HilbertSearchRanges([]quadmap.QuadKey{searched}, 1)
+	require.NoError(t, err)
+	entities, err := s.SearchDetailsInHilbertRanges(ranges, []quadmap.TileType{quadmap.TileTypeVert}, false, 10)
+	require.NoError(t, err)
+	expected := []uint64{uint64(ancestor), uint64(inFirstPartition), uint64(inSecondPartition)}
+	assert.Equal(t, expected, detailsIDs(entities))
+}
+
+func TestPartitionHilbertRanges(t *testing.T) {
+	s := &Storage{}
+	partition := mustQuadKey(t, 600, 400, TablePartitionZoomLevel)
+	partitionRange := partition.HilbertKey().Range()
+	inside := mustQuadKey(t, 600*4+1, 400*4+3, 12).HilbertKey().Range()
+	outside := mustQuadKey(t, 602*4, 400*4, 12).HilbertKey().Range()
+	ancestor := mustQuadKey(t, 150, 100, 8).HilbertKey().Range()
+	ranges := []quadmap.HilbertKeyRange{inside, outside, ancestor}
+
+	assert.Equal(t, []quadmap.HilbertKeyRange{inside, partitionRange}, partitionHilbertRanges(s.GenerateTableName(partition), ranges))
+	assert.Equal(t, ranges, partitionHilbertRanges("quadmap_high", ranges))
+	assert.Empty(t, partitionHilbertRanges("quadmap_1234", []quadmap.HilbertKeyRange{inside}))
+}
@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/kpfaulkner/quadmap/quadmap"
+)
+
+var UnknownTileTypeError = errors.New("tile type not known to storage")
+
+// tileTypeBits maps TileTypes to the bits stored in the database.
+// Registered TileTypes get their bit from the order they're registered in, which can differ
+// between processes using the same database. So the bit used for each TileType name is kept in
+// the tiletypes table and values are remapped when writing and reading (same idea as the
+// tile type registry in the quadmap encoding).
+// Built in TileTypes always use their own bits.
+type tileTypeBits struct {
+	toDB   map[quadmap.TileType]quadmap.TileType
+	fromDB map[quadmap.TileType]quadmap.TileType
+}
+
+// loadTileTypeBits reads the tiletypes table, adding any TileTypes registered in this process
+// that aren't in it yet. A new TileType keeps its current bit if it's free in the database,
+// otherwise it's given the lowest free bit.
+// TileTypes must be registered before NewStorage is called.
+func loadTileTypeBits(db *sqlx.DB) (*tileTypeBits, error) {
+	db.MustExec(`create table if not exists tiletypes (name varchar(255) primary key, bit integer unique)`)
+
+	var rows []struct {
+		Name string `db:"name"`
+		Bit  uint32 `db:"bit"`
+	}
+	if err := db.Select(&rows, `select name, bit from tiletypes`); err != nil {
+		return nil, err
+	}
+
+	b := &tileTypeBits{
+		toDB:   make(map[quadmap.TileType]quadmap.TileType),
+		fromDB: make(map[quadmap.TileType]quadmap.TileType),
+	}
+	used := make(map[quadmap.TileType]bool)
+	dbBits := make(map[string]quadmap.TileType)
+	for _, r := range rows {
+		dbBits[r.Name] = quadmap.TileType(r.Bit)
+		used[quadmap.TileType(r.Bit)] = true
+	}
+
+	for _, tt := range quadmap.AllTileTypes() {
+		name := tt.String()
+		bit, ok := dbBits[name]
+		if !ok {
+			bit = tt
+			for i := 0; used[bit]; i++ {
+				if i >= quadmap.MaxTileTypes {
+					return nil, quadmap.TooManyTileTypesError
+				}
+				bit = quadmap.TileType(1) << i
+			}
+			if _, err := db.Exec(`insert into tiletypes (name, bit) values ($1, $2)`, name, uint32(bit)); err != nil {
+				return nil, err
+			}
+			used[bit] = true
+		}
+		b.toDB[tt] = bit
+		b.fromDB[bit] = tt
+	}
+	return b, nil
+}
+
+// tileTypeToDB converts tt (which may be multiple TileTypes combined) to the database bits
+func (b *tileTypeBits) tileTypeToDB(tt quadmap.TileType) (quadmap.TileType, error) {
+	return remapTileType(b.toDB, tt)
+}
+
+// tileTypeFromDB converts tt read from the database to the TileTypes of this process
+func (b *tileTypeBits) tileTypeFromDB(tt quadmap.TileType) (quadmap.TileType, error) {
+	return remapTileType(b.fromDB, tt)
+}
+
+// tileTypesToDB converts each of types to the database bits
+func (b *tileTypeBits) tileTypesToDB(types []quadmap.TileType) ([]quadmap.TileType, error) {
+	dbTypes := make([]quadmap.TileType, len(types))
+	for i, tt := range types {
+		dbType, err := b.tileTypeToDB(tt)
+		if err != nil {
+			return nil, err
+		}
+		dbTypes[i] = dbType
+	}
+	return dbTypes, nil
+}
+
+// detailsToDB converts Tile.Details (both the TileTypes and the full flags) to the database bits
+func (b *tileTypeBits) detailsToDB(details uint64) (uint64, error) {
+	return remapDetails(b.toDB, details)
+}
+
+// detailsFromDB converts details_mask read from the database to the TileTypes of this process.
+// Rows inserted before TileType was extended to 32 bits have details_mask in the legacy layout
+// (see quadmap.ConvertLegacyDetails), those are converted first. They only ever contain the
+// built in TileTypes, which always use their own bits.
+func (b *tileTypeBits) detailsFromDB(details uint64) (uint64, error) {
+	if isLegacyDetails(details) {
+		details = quadmap.ConvertLegacyDetails(details)
+	}
+	return remapDetails(b.fromDB, details)
+}
+
+// isLegacyDetails returns true if details is in the legacy layout. Any tile has at least one
+// TileType, which in the current layout is always above TileTypeOffset.
+func isLegacyDetails(details uint64) bool {
+	return details != 0 && details>>quadmap.TileTypeOffset == 0
+}
+
+func remapDetails(mapping map[quadmap.TileType]quadmap.TileType, details uint64) (uint64, error) {
+	types, err := remapTileType(mapping, quadmap.TileType(details>>quadmap.TileTypeOffset))
+	if err != nil {
+		return 0, err
+	}
+	full, err := remapTileType(mapping, quadmap.TileType(details))
+	if err != nil {
+		return 0, err
+	}
+	return uint64(types)<<quadmap.TileTypeOffset | uint64(full), nil
+}
+
+func remapTileType(mapping map[quadmap.TileType]quadmap.TileType, tt quadmap.TileType) (quadmap.TileType, error) {
+	var remapped quadmap.TileType
+	for bit := quadmap.TileType(1); bit != 0; bit <<= 1 {
+		if tt&bit == 0 {
+			continue
+		}
+		mappedBit, ok := mapping[bit]
+		if !ok {
+			return 0, fmt.Errorf("%w: %s", UnknownTileTypeError, bit)
+		}
+		remapped |= mappedBit
+	}
+	return remapped, nil
+}